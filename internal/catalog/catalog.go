@@ -0,0 +1,239 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+var csvHeader = []string{"sku", "name", "description", "price", "quantity"}
+
+// productRow is a product as it is imported and exported. Only the columns
+// of the CSV header cross the file, ids, versions and ratings belong to the
+// instance the file came from.
+type productRow struct {
+	Sku         string `json:"sku"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       int    `json:"price"`
+	Quantity    int    `json:"quantity"`
+}
+
+func newProductRow(product models.Product) productRow {
+	return productRow{
+		Sku:         product.Sku,
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       product.Price,
+		Quantity:    product.Quantity,
+	}
+}
+
+func (r productRow) product() models.Product {
+	return models.Product{
+		Sku:         r.Sku,
+		Name:        r.Name,
+		Description: r.Description,
+		Price:       r.Price,
+		Quantity:    r.Quantity,
+	}
+}
+
+// Row is one decoded line of an import file. Line is 1-based and counts the CSV header.
+type Row struct {
+	Line    int
+	Product models.Product
+	Err     error
+}
+
+// FormatFromPath guesses the format from the file extension.
+func FormatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".jsonl", ".ndjson":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unsupported catalog format %q", filepath.Ext(path))
+	}
+}
+
+// Decode reads every row of r. Rows that can't be parsed are returned with
+// Err set instead of aborting the whole file.
+func Decode(r io.Reader, format string) ([]Row, error) {
+	switch format {
+	case FormatCSV:
+		return decodeCSV(r)
+	case FormatJSONL:
+		return decodeJSONL(r)
+	default:
+		return nil, fmt.Errorf("unsupported catalog format %q", format)
+	}
+}
+
+func decodeCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range csvHeader {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header is missing column %q", name)
+		}
+	}
+
+	rows := []Row{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		row := Row{Line: line}
+		if err != nil {
+			row.Err = err
+			rows = append(rows, row)
+			continue
+		}
+
+		row.Product, row.Err = productFromRecord(record, columns)
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func productFromRecord(record []string, columns map[string]int) (models.Product, error) {
+	get := func(name string) string {
+		i := columns[name]
+		if i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	product := models.Product{
+		Sku:         get("sku"),
+		Name:        get("name"),
+		Description: get("description"),
+	}
+
+	var err error
+	if product.Price, err = strconv.Atoi(get("price")); err != nil {
+		return models.Product{}, fmt.Errorf("invalid price %q", get("price"))
+	}
+
+	if product.Quantity, err = strconv.Atoi(get("quantity")); err != nil {
+		return models.Product{}, fmt.Errorf("invalid quantity %q", get("quantity"))
+	}
+
+	return product, nil
+}
+
+func decodeJSONL(r io.Reader) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	rows := []Row{}
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		// Fields outside of productRow are rejected rather than dropped, so
+		// that a file with ids or versions isn't taken for a different one.
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+
+		row := Row{Line: line}
+		product := productRow{}
+		if row.Err = decoder.Decode(&product); row.Err == nil {
+			row.Product = product.product()
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+// Encoder writes products one at a time, so that a catalog can be streamed.
+type Encoder struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+// NewEncoder returns an encoder for format. For CSV the header is written immediately.
+func NewEncoder(w io.Writer, format string) (*Encoder, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		return &Encoder{csv: writer}, writer.Write(csvHeader)
+	case FormatJSONL:
+		return &Encoder{json: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported catalog format %q", format)
+	}
+}
+
+func (e *Encoder) Write(product models.Product) error {
+	if e.json != nil {
+		return e.json.Encode(newProductRow(product))
+	}
+
+	return e.csv.Write([]string{
+		product.Sku,
+		product.Name,
+		product.Description,
+		strconv.Itoa(product.Price),
+		strconv.Itoa(product.Quantity),
+	})
+}
+
+func (e *Encoder) Flush() error {
+	if e.csv == nil {
+		return nil
+	}
+
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+// Encode writes products in the given format.
+func Encode(w io.Writer, format string, products []models.Product) error {
+	encoder, err := NewEncoder(w, format)
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		if err := encoder.Write(product); err != nil {
+			return err
+		}
+	}
+
+	return encoder.Flush()
+}
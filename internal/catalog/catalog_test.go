@@ -0,0 +1,65 @@
+package catalog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func TestEncodeJSONLWritesOnlyRowFields(t *testing.T) {
+	deletedAt := time.Now()
+	products := []models.Product{
+		{Id: 7, Sku: "KETTLE", Name: "Kettle", Description: "1.7 l", Price: 100, Quantity: 3, Version: 4, Rating: 4.5, ReviewCount: 2, DeletedAt: &deletedAt},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, FormatJSONL, products); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"sku":"KETTLE","name":"Kettle","description":"1.7 l","price":100,"quantity":3}` + "\n"
+	if buf.String() != want {
+		t.Errorf("export = %s, want %s", buf.String(), want)
+	}
+
+	rows, err := Decode(&buf, FormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantProduct := models.Product{Sku: "KETTLE", Name: "Kettle", Description: "1.7 l", Price: 100, Quantity: 3}
+	if len(rows) != 1 || rows[0].Err != nil || rows[0].Product != wantProduct {
+		t.Errorf("rows = %+v, want %+v", rows, wantProduct)
+	}
+}
+
+func TestDecodeJSONLRejectsModelFields(t *testing.T) {
+	lines := []string{
+		`{"sku":"KETTLE","name":"Kettle","price":100,"quantity":3}`,
+		`{"sku":"MUG","name":"Mug","price":10,"quantity":1,"id":7}`,
+		`{"sku":"CUP","name":"Cup","price":5,"quantity":1,"version":3}`,
+		`{"sku":"TEAPOT","name":"Teapot","price":50,"quantity":1,"rating":5,"reviewCount":100}`,
+	}
+
+	rows, err := Decode(strings.NewReader(strings.Join(lines, "\n")), FormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != len(lines) {
+		t.Fatalf("rows = %+v", rows)
+	}
+
+	if rows[0].Err != nil {
+		t.Errorf("line 1: %v", rows[0].Err)
+	}
+
+	for _, row := range rows[1:] {
+		if row.Err == nil {
+			t.Errorf("line %d accepted as %+v", row.Line, row.Product)
+		}
+	}
+}
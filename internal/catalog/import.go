@@ -0,0 +1,107 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/validation"
+)
+
+const (
+	ModeTransactional = "transactional"
+	ModeBestEffort    = "best-effort"
+)
+
+type Importer interface {
+	UpsertProducts(ctx context.Context, products []models.Product, atomic, dryRun bool) ([]models.ImportResult, error)
+}
+
+// Import validates rows and upserts the valid ones by SKU. In transactional
+// mode a single invalid or failing row prevents the whole import.
+func Import(ctx context.Context, store Importer, rows []Row, mode string, dryRun bool) (models.ImportReport, error) {
+	if mode != ModeTransactional && mode != ModeBestEffort {
+		return models.ImportReport{}, fmt.Errorf("unknown import mode %q", mode)
+	}
+
+	report := models.ImportReport{DryRun: dryRun, Mode: mode, Rows: make([]models.ImportResult, len(rows))}
+
+	products := []models.Product{}
+	indexes := []int{}
+	for i, row := range rows {
+		report.Rows[i] = models.ImportResult{Line: row.Line, Sku: row.Product.Sku}
+
+		err := row.Err
+		if err == nil {
+			err = Validate(row.Product)
+		}
+
+		if err != nil {
+			report.Rows[i].Action = models.ImportInvalid
+			report.Rows[i].Error = err.Error()
+			continue
+		}
+
+		products = append(products, row.Product)
+		indexes = append(indexes, i)
+	}
+
+	atomic := mode == ModeTransactional
+	if atomic && len(products) != len(rows) {
+		for _, i := range indexes {
+			report.Rows[i].Action = models.ImportRolledBack
+		}
+
+		return summarize(report), nil
+	}
+
+	results, err := store.UpsertProducts(ctx, products, atomic, dryRun)
+	if err != nil {
+		return models.ImportReport{}, err
+	}
+
+	for j, result := range results {
+		i := indexes[j]
+		result.Line = report.Rows[i].Line
+		report.Rows[i] = result
+	}
+
+	return summarize(report), nil
+}
+
+func summarize(report models.ImportReport) models.ImportReport {
+	for _, row := range report.Rows {
+		switch row.Action {
+		case models.ImportCreated:
+			report.Created++
+		case models.ImportUpdated:
+			report.Updated++
+		case models.ImportInvalid, models.ImportFailed:
+			report.Failed++
+		}
+	}
+
+	return report
+}
+
+var validate = validation.New()
+
+// Validate checks a product before it is imported. Imported products
+// must have a SKU, otherwise the rules are the same as for the API.
+func Validate(product models.Product) error {
+	problems := []string{}
+	if product.Sku == "" {
+		problems = append(problems, "sku is required")
+	}
+
+	for _, fieldError := range validation.FieldErrors(validate.Struct(&product)) {
+		problems = append(problems, fieldError.Field+" "+fieldError.Error)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package catalog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/media"
+)
+
+type Purger interface {
+	PurgeDeletedProducts(ctx context.Context, before time.Time) (int, []string, error)
+}
+
+// Purge removes products soft deleted before the given time, together with
+// the blobs of their images.
+func Purge(ctx context.Context, store Purger, blobs media.BlobStore, before time.Time) (int, error) {
+	purged, imageKeys, err := store.PurgeDeletedProducts(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	media.DeleteImageBlobs(ctx, blobs, imageKeys...)
+	return purged, nil
+}
+
+// PurgeJob periodically removes products that have been soft deleted for
+// longer than the retention period.
+type PurgeJob struct {
+	store     Purger
+	blobs     media.BlobStore
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewPurgeJob(store Purger, blobs media.BlobStore, retention time.Duration) *PurgeJob {
+	return &PurgeJob{
+		store:     store,
+		blobs:     blobs,
+		retention: retention,
+		interval:  time.Hour,
+	}
+}
+
+// Run purges once at startup and then every interval until ctx is cancelled.
+func (j *PurgeJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		j.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *PurgeJob) purge(ctx context.Context) {
+	purged, err := Purge(ctx, j.store, j.blobs, time.Now().Add(-j.retention))
+	if err != nil {
+		slog.ErrorContext(ctx, "catalog: failed to purge deleted products", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		slog.InfoContext(ctx, "catalog: purged deleted products", "count", purged)
+	}
+}
@@ -0,0 +1,53 @@
+package catalog
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+type purger struct {
+	imageKeys []string
+}
+
+func (p purger) PurgeDeletedProducts(ctx context.Context, before time.Time) (int, []string, error) {
+	return 2, p.imageKeys, nil
+}
+
+type blobs struct {
+	deleted []string
+}
+
+func (b *blobs) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	return nil
+}
+
+func (b *blobs) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (b *blobs) Delete(ctx context.Context, key string) error {
+	b.deleted = append(b.deleted, key)
+	return nil
+}
+
+func TestPurgeDeletesImageBlobs(t *testing.T) {
+	store := &blobs{}
+
+	purged, err := Purge(context.Background(), purger{imageKeys: []string{"products/7/a"}}, store, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 2 {
+		t.Errorf("purged = %d, want 2", purged)
+	}
+
+	for _, key := range []string{"products/7/a/original", "products/7/a/150", "products/7/a/400", "products/7/a/800"} {
+		if !slices.Contains(store.deleted, key) {
+			t.Errorf("blob %s was not deleted, deleted %v", key, store.deleted)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ursuldaniel/go-market/internal/config"
+	"github.com/ursuldaniel/go-market/internal/storage"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the HTTP API (default)", runServe},
+	{"migrate", "create or update the database schema", runMigrate},
+	{"config print", "print the resolved configuration with secrets redacted", runConfigPrint},
+	{"user create", "create a user: -username -password [-email] [-role]", runUserCreate},
+	{"user promote", "grant the admin role: user promote <username>", runUserPromote},
+	{"user disable", "disable or re-enable a user: user disable [-enable] <username>", runUserDisable},
+	{"product import", "upsert products by SKU: product import [-dry-run] [-best-effort] <file>", runProductImport},
+	{"product export", "export products: product export [-file-format csv|jsonl] [-o file]", runProductExport},
+	{"product purge", "remove deleted products now: product purge [-older-than 720h]", runProductPurge},
+	{"purchases report", "sales per product, or purchases of one user: [-user id]", runPurchasesReport},
+	{"token mint", "mint a JWT: token mint [-admin] <user id>", runTokenMint},
+	{"key generate", "create a JWT signing key: key generate [-alg EdDSA|RS256] -o <file>", runKeyGenerate},
+	{"fake-idp", "run an OpenID Connect provider for development: fake-idp [-addr localhost:9000]", runFakeIdP},
+}
+
+// Run executes the command named by args and returns the process exit code.
+// Without a command, or when args start with a flag, the API server is started.
+func Run(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return exit(runServe(args))
+	}
+
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return exit(cmd.run(args[len(words):]))
+		}
+	}
+
+	usage(os.Stderr)
+	return 2
+}
+
+func exit(err error) int {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: go-market <command> [flags] [args]")
+	fmt.Fprintln(w)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-18s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command accepts the configuration flags, see go-market serve -h.")
+}
+
+// flags holds the flags shared by every operator command.
+type flags struct {
+	*flag.FlagSet
+	loader *config.Loader
+	format *string
+}
+
+func newFlags(name string) flags {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	return flags{
+		FlagSet: fs,
+		loader:  config.NewLoader(fs),
+		format:  fs.String("format", "table", "output format: table or json"),
+	}
+}
+
+// openStorage loads the configuration and connects to the database.
+func (f flags) openStorage(ctx context.Context) (*storage.PostgresStorage, error) {
+	cfg, err := f.loader.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.NewPostgresStorage(ctx, cfg.DatabaseDSN)
+}
+
+// print writes v as JSON, or headers and rows as an aligned table.
+func (f flags) print(v any, headers []string, rows [][]string) error {
+	switch *f.format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(headers, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", *f.format)
+	}
+}
+
+func runConfigPrint(args []string) error {
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	loader := config.NewLoader(fs)
+	fs.Parse(args)
+
+	cfg, err := loader.Load()
+	if printErr := cfg.Print(os.Stdout); printErr != nil {
+		return printErr
+	}
+
+	return err
+}
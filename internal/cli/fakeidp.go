@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/oidc/fakeidp"
+)
+
+// runFakeIdP serves an OpenID Connect provider that signs in anyone, for
+// trying out the sign-in locally. It takes the client from the same
+// configuration as the API, so that both sides match.
+func runFakeIdP(args []string) error {
+	f := newFlags("fake-idp")
+	addr := f.String("addr", "localhost:9000", "address to listen on")
+	f.Parse(args)
+
+	cfg, err := f.loader.Resolve()
+	if err != nil {
+		return err
+	}
+
+	clientID := cfg.OIDCClientID
+	if clientID == "" {
+		clientID = "go-market"
+	}
+
+	idp, err := fakeidp.New(fakeidp.Config{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     clientID,
+		ClientSecret: cfg.OIDCClientSecret,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("fake identity provider listening on %s for client %q\n", *addr, clientID)
+
+	srv := &http.Server{Addr: *addr, Handler: idp, ReadHeaderTimeout: time.Second * 10}
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/catalog"
+	"github.com/ursuldaniel/go-market/internal/server"
+	"github.com/ursuldaniel/go-market/internal/storage"
+)
+
+func runProductImport(args []string) error {
+	f := newFlags("product import")
+	format := f.String("file-format", "", "csv or jsonl, guessed from the file extension if empty")
+	dryRun := f.Bool("dry-run", false, "validate and report without saving")
+	bestEffort := f.Bool("best-effort", false, "save valid rows even if some rows fail")
+	f.Parse(args)
+
+	if f.NArg() != 1 {
+		return fmt.Errorf("usage: product import [-file-format csv|jsonl] [-dry-run] [-best-effort] <file>")
+	}
+
+	path := f.Arg(0)
+	if *format == "" {
+		var err error
+		if *format, err = catalog.FormatFromPath(path); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rows, err := catalog.Decode(file, *format)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	store, err := f.openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	mode := catalog.ModeTransactional
+	if *bestEffort {
+		mode = catalog.ModeBestEffort
+	}
+
+	return productImport(ctx, store, f, rows, mode, *dryRun)
+}
+
+func productImport(ctx context.Context, store server.Storage, f flags, rows []catalog.Row, mode string, dryRun bool) error {
+	report, err := catalog.Import(ctx, store, rows, mode, dryRun)
+	if err != nil {
+		return err
+	}
+
+	table := [][]string{}
+	for _, row := range report.Rows {
+		table = append(table, []string{strconv.Itoa(row.Line), row.Sku, row.Action, strconv.Itoa(row.Id), row.Error})
+	}
+
+	if err := f.print(report, []string{"LINE", "SKU", "ACTION", "ID", "ERROR"}, table); err != nil {
+		return err
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", report.Failed, len(report.Rows))
+	}
+
+	return nil
+}
+
+func runProductExport(args []string) error {
+	f := newFlags("product export")
+	format := f.String("file-format", catalog.FormatCSV, "csv or jsonl")
+	output := f.String("o", "", "output file, stdout if empty")
+	f.Parse(args)
+
+	ctx := context.Background()
+	store, err := f.openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	encoder, err := catalog.NewEncoder(w, *format)
+	if err != nil {
+		return err
+	}
+
+	if err := store.StreamProducts(ctx, encoder.Write); err != nil {
+		return err
+	}
+
+	return encoder.Flush()
+}
+
+func runProductPurge(args []string) error {
+	f := newFlags("product purge")
+	olderThan := f.Duration("older-than", 0, "purge products deleted longer ago than this, product_retention if zero")
+	f.Parse(args)
+
+	cfg, err := f.loader.Load()
+	if err != nil {
+		return err
+	}
+
+	if *olderThan == 0 {
+		*olderThan = cfg.ProductRetention.Duration
+	}
+
+	blobs, err := openBlobStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	store, err := storage.NewPostgresStorage(ctx, cfg.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	purged, err := catalog.Purge(ctx, store, blobs, time.Now().Add(-*olderThan))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("purged %d products\n", purged)
+	return nil
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/server"
+)
+
+type salesRow struct {
+	ProductId int    `json:"productId"`
+	Name      string `json:"name"`
+	Purchases int    `json:"purchases"`
+	Units     int    `json:"units"`
+	Revenue   int    `json:"revenue"`
+}
+
+func runPurchasesReport(args []string) error {
+	f := newFlags("purchases report")
+	userId := f.Int("user", 0, "list the purchases of this user instead of the sales report")
+	f.Parse(args)
+
+	ctx := context.Background()
+	store, err := f.openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *userId != 0 {
+		return userPurchasesReport(ctx, store, f, *userId)
+	}
+
+	return salesReport(ctx, store, f)
+}
+
+func salesReport(ctx context.Context, store server.Storage, f flags) error {
+	products, err := store.GetAllProducts(ctx)
+	if err != nil {
+		return err
+	}
+
+	report := []salesRow{}
+	table := [][]string{}
+	for _, product := range products {
+		purchases, err := store.GetProductPurchases(ctx, product.Id)
+		if err != nil {
+			return err
+		}
+
+		row := salesRow{ProductId: product.Id, Name: product.Name, Purchases: len(purchases)}
+		for _, purchase := range purchases {
+			row.Units += purchase.Quantity
+		}
+		row.Revenue = row.Units * product.Price
+
+		report = append(report, row)
+		table = append(table, []string{
+			strconv.Itoa(row.ProductId),
+			row.Name,
+			strconv.Itoa(row.Purchases),
+			strconv.Itoa(row.Units),
+			strconv.Itoa(row.Revenue),
+		})
+	}
+
+	return f.print(report, []string{"PRODUCT", "NAME", "PURCHASES", "UNITS", "REVENUE"}, table)
+}
+
+func userPurchasesReport(ctx context.Context, store server.Storage, f flags, userId int) error {
+	purchases, err := store.GetUserPurchases(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	table := [][]string{}
+	for _, purchase := range purchases {
+		table = append(table, purchaseRow(purchase))
+	}
+
+	return f.print(purchases, []string{"ID", "PRODUCT", "QUANTITY", "TIMESTAMP"}, table)
+}
+
+func purchaseRow(purchase models.Purchase) []string {
+	return []string{
+		strconv.Itoa(purchase.Id),
+		strconv.Itoa(purchase.ProductId),
+		strconv.Itoa(purchase.Quantity),
+		purchase.Timestamp,
+	}
+}
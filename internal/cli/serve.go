@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/ursuldaniel/go-market/internal/catalog"
+	"github.com/ursuldaniel/go-market/internal/config"
+	"github.com/ursuldaniel/go-market/internal/logging"
+	"github.com/ursuldaniel/go-market/internal/mail"
+	"github.com/ursuldaniel/go-market/internal/media"
+	"github.com/ursuldaniel/go-market/internal/metrics"
+	"github.com/ursuldaniel/go-market/internal/notify"
+	"github.com/ursuldaniel/go-market/internal/oidc"
+	"github.com/ursuldaniel/go-market/internal/ratelimit"
+	"github.com/ursuldaniel/go-market/internal/server"
+	"github.com/ursuldaniel/go-market/internal/signing"
+	"github.com/ursuldaniel/go-market/internal/storage"
+	"github.com/ursuldaniel/go-market/internal/tracing"
+	"github.com/ursuldaniel/go-market/internal/webhooks"
+)
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	loader := config.NewLoader(fs)
+	fs.Parse(args)
+
+	cfg, err := loader.Load()
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
+
+	return serve(cfg, loader)
+}
+
+func serve(cfg config.Config, loader *config.Loader) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracesExporter)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	store, err := storage.NewPostgresStorage(ctx, cfg.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	metrics.RegisterPoolStats(store.PoolStats)
+
+	blobs, err := openBlobStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	mailer, err := openMailer(cfg)
+	if err != nil {
+		return err
+	}
+
+	idp, err := openIdentityProvider(cfg)
+	if err != nil {
+		return err
+	}
+
+	keys, err := openKeySet(cfg)
+	if err != nil {
+		return err
+	}
+
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+
+	workers.Add(3)
+	go func() {
+		defer workers.Done()
+		webhooks.NewDispatcher(store).Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		catalog.NewPurgeJob(store, blobs, cfg.ProductRetention.Duration).Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		reloadOnHangup(workersCtx, cfg, loader)
+	}()
+
+	server := server.NewServer(cfg, store, blobs, mailer, openNotifier(cfg, store, mailer), openRateLimitStore(cfg, store), idp, keys)
+	err = server.Run(ctx)
+
+	// Background workers are stopped after the HTTP server has drained
+	// and before the storage pool is closed.
+	stopWorkers()
+	workers.Wait()
+	slog.Info("shutdown complete")
+
+	return err
+}
+
+func openBlobStore(cfg config.Config) (media.BlobStore, error) {
+	if cfg.BlobStore == "s3" {
+		return media.NewS3Store(media.S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Bucket:    cfg.S3Bucket,
+			Region:    cfg.S3Region,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+	}
+
+	return media.NewFSStore(cfg.BlobDir)
+}
+
+func openMailer(cfg config.Config) (mail.Mailer, error) {
+	switch cfg.Mailer {
+	case "smtp":
+		return mail.NewSMTPMailer(mail.SMTPConfig{
+			Addr:     cfg.SMTPAddr,
+			From:     cfg.SMTPFrom,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+		}), nil
+	case "file":
+		return mail.NewFileMailer(cfg.MailDir, cfg.SMTPFrom)
+	default:
+		return mail.LogMailer{}, nil
+	}
+}
+
+func openNotifier(cfg config.Config, store *storage.PostgresStorage, mailer mail.Mailer) notify.Notifier {
+	if cfg.Notifier == "email" {
+		return notify.NewEmail(mailer)
+	}
+
+	return notify.NewInbox(store)
+}
+
+func openRateLimitStore(cfg config.Config, store *storage.PostgresStorage) ratelimit.Store {
+	switch cfg.RateLimitStore {
+	case "postgres":
+		return ratelimit.NewSharedStore(store)
+	case "off":
+		return nil
+	default:
+		return ratelimit.NewMemoryStore()
+	}
+}
+
+// openIdentityProvider returns nil when sign-in with an OpenID Connect
+// provider is off. The provider is contacted on the first sign-in.
+func openIdentityProvider(cfg config.Config) (*oidc.Provider, error) {
+	if cfg.OIDCIssuer == "" {
+		return nil, nil
+	}
+
+	return oidc.New(oidc.Config{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  cfg.OIDCRedirectURL,
+		Scopes:       strings.Fields(cfg.OIDCScopes),
+		DiscoveryURL: cfg.OIDCDiscoveryURL,
+		JWKSURL:      cfg.OIDCJWKSURL,
+	})
+}
+
+// openKeySet loads the keys of jwt_keys. Without them tokens are signed
+// with the shared secret, which other services can't verify safely.
+func openKeySet(cfg config.Config) (*signing.KeySet, error) {
+	files := cfg.JWTKeyFiles()
+	if len(files) == 0 {
+		slog.Warn("jwt_keys is empty, tokens are signed with HS256 and secret_key")
+	}
+
+	keys, err := signing.LoadKeySet(files, cfg.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("jwt_keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// reloadOnHangup reloads the settings that are safe to change at runtime on SIGHUP.
+func reloadOnHangup(ctx context.Context, cfg config.Config, loader *config.Loader) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			reloaded, err := loader.Reload(cfg)
+			if err != nil {
+				slog.Error("failed to reload configuration", "error", err)
+				continue
+			}
+
+			cfg = reloaded
+			logging.SetLevel(cfg.LogLevel)
+			slog.Info("configuration reloaded", "log_level", cfg.LogLevel)
+		}
+	}
+}
+
+func runMigrate(args []string) error {
+	f := newFlags("migrate")
+	f.Parse(args)
+
+	ctx := context.Background()
+	store, err := f.openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.Migrate(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("migrations applied")
+	return nil
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ursuldaniel/go-market/internal/server"
+	"github.com/ursuldaniel/go-market/internal/signing"
+)
+
+func runTokenMint(args []string) error {
+	f := newFlags("token mint")
+	admin := f.Bool("admin", false, "mint an admin token")
+	f.Parse(args)
+
+	if f.NArg() != 1 {
+		return fmt.Errorf("usage: token mint [-admin] <user id>")
+	}
+
+	userId, err := strconv.Atoi(f.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid user id %q", f.Arg(0))
+	}
+
+	cfg, err := f.loader.Resolve()
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(cfg.SecretKey) == "" {
+		return fmt.Errorf("secret_key must not be empty")
+	}
+
+	keys, err := signing.LoadKeySet(cfg.JWTKeyFiles(), cfg.SecretKey)
+	if err != nil {
+		return fmt.Errorf("jwt_keys: %w", err)
+	}
+
+	var token string
+	if *admin {
+		token, err = server.CreateAdminToken(keys, userId)
+	} else {
+		token, err = server.CreateUserToken(keys, userId)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return f.print(map[string]string{"token": token}, []string{"TOKEN"}, [][]string{{token}})
+}
+
+// runKeyGenerate writes a new private key for jwt_keys. Rotation: add the
+// new key at the end of jwt_keys so that it is published, move it to the
+// front once caches of the key set have expired, and drop the old key when
+// the last token signed with it has expired.
+func runKeyGenerate(args []string) error {
+	f := newFlags("key generate")
+	algorithm := f.String("alg", signing.EdDSA, "signing algorithm: EdDSA or RS256")
+	output := f.String("o", "", "file to write the PEM encoded private key to")
+	f.Parse(args)
+
+	if *output == "" {
+		return fmt.Errorf("usage: key generate [-alg EdDSA|RS256] -o <file>")
+	}
+
+	data, err := signing.GenerateKey(*algorithm)
+	if err != nil {
+		return err
+	}
+
+	key, err := signing.ParseKey(data)
+	if err != nil {
+		return err
+	}
+
+	// O_EXCL keeps an existing key from being overwritten by accident.
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return f.print(map[string]string{"kid": key.ID, "alg": key.Algorithm, "file": *output},
+		[]string{"KID", "ALG", "FILE"}, [][]string{{key.ID, key.Algorithm, *output}})
+}
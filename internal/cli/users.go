@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/server"
+)
+
+func runUserCreate(args []string) error {
+	f := newFlags("user create")
+	username := f.String("username", "", "login of the new user")
+	password := f.String("password", "", "password of the new user")
+	email := f.String("email", "", "email of the new user")
+	role := f.String("role", models.RoleUser, "role of the new user: user or admin")
+	f.Parse(args)
+
+	if *username == "" || *password == "" {
+		return fmt.Errorf("-username and -password are required")
+	}
+
+	if *role != models.RoleUser && *role != models.RoleAdmin {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+
+	ctx := context.Background()
+	store, err := f.openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return userCreate(ctx, store, f, *username, *password, *email, *role)
+}
+
+func userCreate(ctx context.Context, store server.Storage, f flags, username, password, email, role string) error {
+	if err := store.RegisterUser(ctx, username, password, email); err != nil {
+		return err
+	}
+
+	user, err := store.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if role != models.RoleUser {
+		if err := store.SetUserRole(ctx, user.Id, role); err != nil {
+			return err
+		}
+		user.Role = role
+	}
+
+	return printUser(f, user)
+}
+
+func runUserPromote(args []string) error {
+	f := newFlags("user promote")
+	f.Parse(args)
+
+	if f.NArg() != 1 {
+		return fmt.Errorf("usage: user promote <username>")
+	}
+
+	ctx := context.Background()
+	store, err := f.openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return userSet(ctx, store, f, f.Arg(0), func(user *models.User) error {
+		user.Role = models.RoleAdmin
+		return store.SetUserRole(ctx, user.Id, models.RoleAdmin)
+	})
+}
+
+func runUserDisable(args []string) error {
+	f := newFlags("user disable")
+	enable := f.Bool("enable", false, "re-enable the user instead")
+	f.Parse(args)
+
+	if f.NArg() != 1 {
+		return fmt.Errorf("usage: user disable [-enable] <username>")
+	}
+
+	ctx := context.Background()
+	store, err := f.openStorage(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return userSet(ctx, store, f, f.Arg(0), func(user *models.User) error {
+		user.Disabled = !*enable
+		return store.SetUserDisabled(ctx, user.Id, user.Disabled)
+	})
+}
+
+func userSet(ctx context.Context, store server.Storage, f flags, username string, update func(user *models.User) error) error {
+	user, err := store.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if err := update(&user); err != nil {
+		return err
+	}
+
+	return printUser(f, user)
+}
+
+// userRow is what the user commands print. It lists the fields one by one
+// so that nothing the storage adds to users is printed by accident.
+type userRow struct {
+	Id       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+func printUser(f flags, user models.User) error {
+	row := userRow{Id: user.Id, Username: user.Username, Email: user.Email, Role: user.Role, Disabled: user.Disabled}
+
+	return f.print(row, []string{"ID", "USERNAME", "EMAIL", "ROLE", "DISABLED"}, [][]string{{
+		strconv.Itoa(user.Id),
+		user.Username,
+		user.Email,
+		user.Role,
+		strconv.FormatBool(user.Disabled),
+	}})
+}
@@ -0,0 +1,605 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/ursuldaniel/go-market/internal/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the application. Values are resolved with the
+// following precedence: command line flags, environment variables, config
+// file, defaults.
+type Config struct {
+	ListenAddr       string   `yaml:"listen_addr" toml:"listen_addr"`
+	MetricsAddr      string   `yaml:"metrics_addr" toml:"metrics_addr"`
+	DatabaseDSN      string   `yaml:"database_dsn" toml:"database_dsn"`
+	SecretKey        string   `yaml:"secret_key" toml:"secret_key"`
+	JWTKeys          string   `yaml:"jwt_keys" toml:"jwt_keys"`
+	RequireAdmin2FA  bool     `yaml:"require_admin_2fa" toml:"require_admin_2fa"`
+	MaxLoginFailures int      `yaml:"max_login_failures" toml:"max_login_failures"`
+	MaxIPFailures    int      `yaml:"max_ip_login_failures" toml:"max_ip_login_failures"`
+	LoginLockout     Duration `yaml:"login_lockout" toml:"login_lockout"`
+	RateLimitStore   string   `yaml:"rate_limit_store" toml:"rate_limit_store"`
+	OIDCIssuer       string   `yaml:"oidc_issuer" toml:"oidc_issuer"`
+	OIDCClientID     string   `yaml:"oidc_client_id" toml:"oidc_client_id"`
+	OIDCClientSecret string   `yaml:"oidc_client_secret" toml:"oidc_client_secret"`
+	OIDCRedirectURL  string   `yaml:"oidc_redirect_url" toml:"oidc_redirect_url"`
+	OIDCScopes       string   `yaml:"oidc_scopes" toml:"oidc_scopes"`
+	OIDCDiscoveryURL string   `yaml:"oidc_discovery_url" toml:"oidc_discovery_url"`
+	OIDCJWKSURL      string   `yaml:"oidc_jwks_url" toml:"oidc_jwks_url"`
+	LogLevel         string   `yaml:"log_level" toml:"log_level"`
+	ShutdownTimeout  Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	DrainDelay       Duration `yaml:"drain_delay" toml:"drain_delay"`
+	TracesExporter   string   `yaml:"traces_exporter" toml:"traces_exporter"`
+	ProductRetention Duration `yaml:"product_retention" toml:"product_retention"`
+	BlobStore        string   `yaml:"blob_store" toml:"blob_store"`
+	BlobDir          string   `yaml:"blob_dir" toml:"blob_dir"`
+	S3Endpoint       string   `yaml:"s3_endpoint" toml:"s3_endpoint"`
+	S3Bucket         string   `yaml:"s3_bucket" toml:"s3_bucket"`
+	S3Region         string   `yaml:"s3_region" toml:"s3_region"`
+	S3AccessKey      string   `yaml:"s3_access_key" toml:"s3_access_key"`
+	S3SecretKey      string   `yaml:"s3_secret_key" toml:"s3_secret_key"`
+	Notifier         string   `yaml:"notifier" toml:"notifier"`
+	Mailer           string   `yaml:"mailer" toml:"mailer"`
+	MailDir          string   `yaml:"mail_dir" toml:"mail_dir"`
+	SMTPAddr         string   `yaml:"smtp_addr" toml:"smtp_addr"`
+	SMTPFrom         string   `yaml:"smtp_from" toml:"smtp_from"`
+	SMTPUsername     string   `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword     string   `yaml:"smtp_password" toml:"smtp_password"`
+}
+
+// Duration is a time.Duration that is written as "15s" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+type setting struct {
+	key        string
+	env        string
+	usage      string
+	secret     bool
+	reloadable bool
+	get        func(c *Config) string
+	set        func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{
+		key:   "listen_addr",
+		env:   "LISTEN_ADDR",
+		usage: "address of the HTTP API",
+		get:   func(c *Config) string { return c.ListenAddr },
+		set:   func(c *Config, value string) error { c.ListenAddr = value; return nil },
+	},
+	{
+		key:   "metrics_addr",
+		env:   "METRICS_ADDR",
+		usage: "separate address for /metrics, served on the API behind admin auth if empty",
+		get:   func(c *Config) string { return c.MetricsAddr },
+		set:   func(c *Config, value string) error { c.MetricsAddr = value; return nil },
+	},
+	{
+		key:    "database_dsn",
+		env:    "DB_DSN",
+		usage:  "PostgreSQL connection string",
+		secret: true,
+		get:    func(c *Config) string { return c.DatabaseDSN },
+		set:    func(c *Config, value string) error { c.DatabaseDSN = value; return nil },
+	},
+	{
+		key:    "secret_key",
+		env:    "SECRET_KEY",
+		usage:  "key used to sign short-lived internal tokens, and JWT tokens with HS256 if jwt_keys is empty",
+		secret: true,
+		get:    func(c *Config) string { return c.SecretKey },
+		set:    func(c *Config, value string) error { c.SecretKey = value; return nil },
+	},
+	{
+		key:   "jwt_keys",
+		env:   "JWT_KEYS",
+		usage: "comma separated PEM files with RSA or Ed25519 keys of JWT tokens, the first one signs and the others only verify",
+		get:   func(c *Config) string { return c.JWTKeys },
+		set:   func(c *Config, value string) error { c.JWTKeys = value; return nil },
+	},
+	{
+		key:   "require_admin_2fa",
+		env:   "REQUIRE_ADMIN_2FA",
+		usage: "grant admin tokens only to admins with two-factor authentication",
+		get:   func(c *Config) string { return strconv.FormatBool(c.RequireAdmin2FA) },
+		set: func(c *Config, value string) (err error) {
+			c.RequireAdmin2FA, err = strconv.ParseBool(value)
+			return err
+		},
+	},
+	{
+		key:   "max_login_failures",
+		env:   "MAX_LOGIN_FAILURES",
+		usage: "failed logins after which an account is locked",
+		get:   func(c *Config) string { return strconv.Itoa(c.MaxLoginFailures) },
+		set: func(c *Config, value string) (err error) {
+			c.MaxLoginFailures, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		key:   "max_ip_login_failures",
+		env:   "MAX_IP_LOGIN_FAILURES",
+		usage: "failed logins after which a client address is locked",
+		get:   func(c *Config) string { return strconv.Itoa(c.MaxIPFailures) },
+		set: func(c *Config, value string) (err error) {
+			c.MaxIPFailures, err = strconv.Atoi(value)
+			return err
+		},
+	},
+	{
+		key:   "login_lockout",
+		env:   "LOGIN_LOCKOUT",
+		usage: "how long logins stay locked after too many failures",
+		get:   func(c *Config) string { return c.LoginLockout.String() },
+		set:   func(c *Config, value string) error { return c.LoginLockout.UnmarshalText([]byte(value)) },
+	},
+	{
+		key:   "rate_limit_store",
+		env:   "RATE_LIMIT_STORE",
+		usage: "where rate limits are counted: memory, postgres to share them between instances, or off",
+		get:   func(c *Config) string { return c.RateLimitStore },
+		set:   func(c *Config, value string) error { c.RateLimitStore = value; return nil },
+	},
+	{
+		key:   "oidc_issuer",
+		env:   "OIDC_ISSUER",
+		usage: "issuer URL of the OpenID Connect provider, sign-in with it is off if empty",
+		get:   func(c *Config) string { return c.OIDCIssuer },
+		set:   func(c *Config, value string) error { c.OIDCIssuer = value; return nil },
+	},
+	{
+		key:   "oidc_client_id",
+		env:   "OIDC_CLIENT_ID",
+		usage: "client id registered at the OpenID Connect provider",
+		get:   func(c *Config) string { return c.OIDCClientID },
+		set:   func(c *Config, value string) error { c.OIDCClientID = value; return nil },
+	},
+	{
+		key:    "oidc_client_secret",
+		env:    "OIDC_CLIENT_SECRET",
+		usage:  "client secret, empty for a public client",
+		secret: true,
+		get:    func(c *Config) string { return c.OIDCClientSecret },
+		set:    func(c *Config, value string) error { c.OIDCClientSecret = value; return nil },
+	},
+	{
+		key:   "oidc_redirect_url",
+		env:   "OIDC_REDIRECT_URL",
+		usage: "public URL of /users/oidc/callback registered at the provider",
+		get:   func(c *Config) string { return c.OIDCRedirectURL },
+		set:   func(c *Config, value string) error { c.OIDCRedirectURL = value; return nil },
+	},
+	{
+		key:   "oidc_scopes",
+		env:   "OIDC_SCOPES",
+		usage: "space separated scopes requested from the provider",
+		get:   func(c *Config) string { return c.OIDCScopes },
+		set:   func(c *Config, value string) error { c.OIDCScopes = value; return nil },
+	},
+	{
+		key:   "oidc_discovery_url",
+		env:   "OIDC_DISCOVERY_URL",
+		usage: "discovery document of the provider, the well-known URL under the issuer if empty",
+		get:   func(c *Config) string { return c.OIDCDiscoveryURL },
+		set:   func(c *Config, value string) error { c.OIDCDiscoveryURL = value; return nil },
+	},
+	{
+		key:   "oidc_jwks_url",
+		env:   "OIDC_JWKS_URL",
+		usage: "URL of the provider's signing keys, taken from the discovery document if empty",
+		get:   func(c *Config) string { return c.OIDCJWKSURL },
+		set:   func(c *Config, value string) error { c.OIDCJWKSURL = value; return nil },
+	},
+	{
+		key:        "log_level",
+		env:        "LOG_LEVEL",
+		usage:      "debug, info, warn or error",
+		reloadable: true,
+		get:        func(c *Config) string { return c.LogLevel },
+		set:        func(c *Config, value string) error { c.LogLevel = value; return nil },
+	},
+	{
+		key:   "shutdown_timeout",
+		env:   "SHUTDOWN_TIMEOUT",
+		usage: "how long in-flight requests are drained on shutdown",
+		get:   func(c *Config) string { return c.ShutdownTimeout.String() },
+		set:   func(c *Config, value string) error { return c.ShutdownTimeout.UnmarshalText([]byte(value)) },
+	},
+	{
+		key:   "drain_delay",
+		env:   "DRAIN_DELAY",
+		usage: "how long /readyz reports shutting down before the server stops accepting connections",
+		get:   func(c *Config) string { return c.DrainDelay.String() },
+		set:   func(c *Config, value string) error { return c.DrainDelay.UnmarshalText([]byte(value)) },
+	},
+	{
+		key:   "traces_exporter",
+		env:   "OTEL_TRACES_EXPORTER",
+		usage: "otlp, stdout or none",
+		get:   func(c *Config) string { return c.TracesExporter },
+		set:   func(c *Config, value string) error { c.TracesExporter = value; return nil },
+	},
+	{
+		key:   "product_retention",
+		env:   "PRODUCT_RETENTION",
+		usage: "how long deleted products are kept before they are purged",
+		get:   func(c *Config) string { return c.ProductRetention.String() },
+		set:   func(c *Config, value string) error { return c.ProductRetention.UnmarshalText([]byte(value)) },
+	},
+	{
+		key:   "blob_store",
+		env:   "BLOB_STORE",
+		usage: "where uploaded images are kept: fs or s3",
+		get:   func(c *Config) string { return c.BlobStore },
+		set:   func(c *Config, value string) error { c.BlobStore = value; return nil },
+	},
+	{
+		key:   "blob_dir",
+		env:   "BLOB_DIR",
+		usage: "directory of the fs blob store",
+		get:   func(c *Config) string { return c.BlobDir },
+		set:   func(c *Config, value string) error { c.BlobDir = value; return nil },
+	},
+	{
+		key:   "s3_endpoint",
+		env:   "S3_ENDPOINT",
+		usage: "URL of the S3 compatible service",
+		get:   func(c *Config) string { return c.S3Endpoint },
+		set:   func(c *Config, value string) error { c.S3Endpoint = value; return nil },
+	},
+	{
+		key:   "s3_bucket",
+		env:   "S3_BUCKET",
+		usage: "bucket of the s3 blob store",
+		get:   func(c *Config) string { return c.S3Bucket },
+		set:   func(c *Config, value string) error { c.S3Bucket = value; return nil },
+	},
+	{
+		key:   "s3_region",
+		env:   "S3_REGION",
+		usage: "region used to sign S3 requests",
+		get:   func(c *Config) string { return c.S3Region },
+		set:   func(c *Config, value string) error { c.S3Region = value; return nil },
+	},
+	{
+		key:   "s3_access_key",
+		env:   "S3_ACCESS_KEY",
+		usage: "access key of the s3 blob store",
+		get:   func(c *Config) string { return c.S3AccessKey },
+		set:   func(c *Config, value string) error { c.S3AccessKey = value; return nil },
+	},
+	{
+		key:    "s3_secret_key",
+		env:    "S3_SECRET_KEY",
+		usage:  "secret key of the s3 blob store",
+		secret: true,
+		get:    func(c *Config) string { return c.S3SecretKey },
+		set:    func(c *Config, value string) error { c.S3SecretKey = value; return nil },
+	},
+	{
+		key:   "notifier",
+		env:   "NOTIFIER",
+		usage: "how users are notified: inbox or email",
+		get:   func(c *Config) string { return c.Notifier },
+		set:   func(c *Config, value string) error { c.Notifier = value; return nil },
+	},
+	{
+		key:   "mailer",
+		env:   "MAILER",
+		usage: "how emails are sent: smtp, log or file",
+		get:   func(c *Config) string { return c.Mailer },
+		set:   func(c *Config, value string) error { c.Mailer = value; return nil },
+	},
+	{
+		key:   "mail_dir",
+		env:   "MAIL_DIR",
+		usage: "directory the file mailer writes .eml files to",
+		get:   func(c *Config) string { return c.MailDir },
+		set:   func(c *Config, value string) error { c.MailDir = value; return nil },
+	},
+	{
+		key:   "smtp_addr",
+		env:   "SMTP_ADDR",
+		usage: "host:port of the SMTP server",
+		get:   func(c *Config) string { return c.SMTPAddr },
+		set:   func(c *Config, value string) error { c.SMTPAddr = value; return nil },
+	},
+	{
+		key:   "smtp_from",
+		env:   "SMTP_FROM",
+		usage: "sender address of outgoing emails",
+		get:   func(c *Config) string { return c.SMTPFrom },
+		set:   func(c *Config, value string) error { c.SMTPFrom = value; return nil },
+	},
+	{
+		key:   "smtp_username",
+		env:   "SMTP_USERNAME",
+		usage: "SMTP user, no authentication if empty",
+		get:   func(c *Config) string { return c.SMTPUsername },
+		set:   func(c *Config, value string) error { c.SMTPUsername = value; return nil },
+	},
+	{
+		key:    "smtp_password",
+		env:    "SMTP_PASSWORD",
+		usage:  "SMTP password",
+		secret: true,
+		get:    func(c *Config) string { return c.SMTPPassword },
+		set:    func(c *Config, value string) error { c.SMTPPassword = value; return nil },
+	},
+}
+
+func Default() Config {
+	return Config{
+		ListenAddr:       ":1334",
+		MaxLoginFailures: 5,
+		MaxIPFailures:    20,
+		LoginLockout:     Duration{time.Minute * 15},
+		RateLimitStore:   "memory",
+		OIDCScopes:       "openid email profile",
+		LogLevel:         "info",
+		ShutdownTimeout:  Duration{time.Second * 15},
+		DrainDelay:       Duration{time.Second * 5},
+		TracesExporter:   "none",
+		ProductRetention: Duration{time.Hour * 24 * 30},
+		BlobStore:        "fs",
+		BlobDir:          "data/blobs",
+		S3Region:         "us-east-1",
+		Notifier:         "inbox",
+		Mailer:           "log",
+		MailDir:          "data/mail",
+		SMTPAddr:         "localhost:1025",
+		SMTPFrom:         "go-market@localhost",
+	}
+}
+
+// Loader resolves the configuration from a file, the environment and flags.
+// It remembers its sources so that the configuration can be reloaded.
+type Loader struct {
+	path  string
+	flags map[string]string
+}
+
+// NewLoader registers a flag for every setting and a -config flag on fs.
+// The flags are read after fs has been parsed.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{flags: map[string]string{}}
+
+	fs.Func("config", "path to a YAML or TOML config file (env CONFIG_FILE)", func(value string) error {
+		l.path = value
+		return nil
+	})
+
+	for _, s := range settings {
+		key := s.key
+		fs.Func(strings.ReplaceAll(key, "_", "-"), s.usage+" (env "+s.env+")", func(value string) error {
+			l.flags[key] = value
+			return nil
+		})
+	}
+
+	return l
+}
+
+// Load builds and validates the configuration.
+func (l *Loader) Load() (Config, error) {
+	cfg, err := l.Resolve()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Resolve builds the configuration without validating it, for commands
+// that need only some of the settings.
+func (l *Loader) Resolve() (Config, error) {
+	cfg := Default()
+
+	path := l.path
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(&cfg, value); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := l.flags[s.key]; ok {
+			if err := s.set(&cfg, value); err != nil {
+				return Config{}, fmt.Errorf("-%s: %w", strings.ReplaceAll(s.key, "_", "-"), err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// Reload loads the configuration again and copies only the settings that are
+// safe to change at runtime into current.
+func (l *Loader) Reload(current Config) (Config, error) {
+	cfg, err := l.Load()
+	if err != nil {
+		return current, err
+	}
+
+	for _, s := range settings {
+		if s.reloadable {
+			s.set(&current, s.get(&cfg))
+		}
+	}
+
+	return current, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config file format %q", filepath.Ext(path))
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c Config) Validate() error {
+	errs := []error{}
+	if c.ListenAddr == "" {
+		errs = append(errs, fmt.Errorf("listen_addr is required"))
+	}
+	if c.DatabaseDSN == "" {
+		errs = append(errs, fmt.Errorf("database_dsn is required"))
+	}
+	if strings.TrimSpace(c.SecretKey) == "" {
+		errs = append(errs, fmt.Errorf("secret_key must not be empty"))
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout must be positive"))
+	}
+	if c.DrainDelay.Duration < 0 {
+		errs = append(errs, fmt.Errorf("drain_delay must not be negative"))
+	}
+	if c.MaxLoginFailures <= 0 || c.MaxIPFailures <= 0 {
+		errs = append(errs, fmt.Errorf("max_login_failures and max_ip_login_failures must be positive"))
+	}
+	if c.LoginLockout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("login_lockout must be positive"))
+	}
+	if c.ProductRetention.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("product_retention must be positive"))
+	}
+
+	if c.OIDCIssuer != "" && (c.OIDCClientID == "" || c.OIDCRedirectURL == "") {
+		errs = append(errs, fmt.Errorf("oidc_client_id and oidc_redirect_url are required with oidc_issuer"))
+	}
+
+	switch c.RateLimitStore {
+	case "memory", "postgres", "off":
+	default:
+		errs = append(errs, fmt.Errorf("rate_limit_store must be one of memory, postgres, off"))
+	}
+
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.TracesExporter {
+	case "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("traces_exporter must be one of none, otlp, stdout"))
+	}
+
+	switch c.BlobStore {
+	case "fs":
+		if c.BlobDir == "" {
+			errs = append(errs, fmt.Errorf("blob_dir is required for the fs blob store"))
+		}
+	case "s3":
+		if c.S3Endpoint == "" || c.S3Bucket == "" {
+			errs = append(errs, fmt.Errorf("s3_endpoint and s3_bucket are required for the s3 blob store"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("blob_store must be one of fs, s3"))
+	}
+
+	switch c.Notifier {
+	case "inbox", "email":
+	default:
+		errs = append(errs, fmt.Errorf("notifier must be one of inbox, email"))
+	}
+
+	switch c.Mailer {
+	case "log":
+	case "file":
+		if c.MailDir == "" {
+			errs = append(errs, fmt.Errorf("mail_dir is required for the file mailer"))
+		}
+	case "smtp":
+		if c.SMTPAddr == "" || c.SMTPFrom == "" {
+			errs = append(errs, fmt.Errorf("smtp_addr and smtp_from are required for the smtp mailer"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mailer must be one of smtp, log, file"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// JWTKeyFiles returns the files of jwt_keys in order.
+func (c Config) JWTKeyFiles() []string {
+	files := []string{}
+	for _, file := range strings.Split(c.JWTKeys, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// Print writes the configuration as YAML with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	for _, s := range settings {
+		value := s.get(&c)
+		if s.secret && value != "" {
+			value = "[REDACTED]"
+		}
+
+		if _, err := fmt.Fprintf(w, "%s: %q\n", s.key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,22 +1,231 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrProductNotFound      = errors.New("product not found")
+	ErrNotEnoughProducts    = errors.New("not enough products")
+	ErrInvalidQuantity      = errors.New("quantity must be positive")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrUserDisabled         = errors.New("user is disabled")
+	ErrInvalidCredentials   = errors.New("invalid data")
+	ErrUsernameTaken        = errors.New("username is already taken")
+	ErrEmailTaken           = errors.New("email is already verified by another user")
+	ErrInvalidToken         = errors.New("token is invalid or expired")
+	ErrInvalidTOTP          = errors.New("invalid two-factor code")
+	ErrTOTPEnabled          = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrVersionConflict      = errors.New("version conflict")
+	ErrImageNotFound        = errors.New("image not found")
+	ErrReviewNotFound       = errors.New("review not found")
+	ErrReviewExists         = errors.New("product already reviewed")
+	ErrNotPurchased         = errors.New("only customers who bought the product can review it")
+	ErrInStock              = errors.New("product is in stock")
+	ErrNotificationNotFound = errors.New("notification not found")
+	ErrAPIKeyNotFound       = errors.New("api key not found")
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Purposes of the single-use tokens sent by email.
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
 type Response struct {
 	Message string `json:"message"`
 }
 
+// LoginResponse carries the token in Message. When the account has
+// two-factor authentication, MfaToken has to be exchanged for the token
+// together with a code at POST /users/login/2fa.
+type LoginResponse struct {
+	Message               string `json:"message"`
+	MfaRequired           bool   `json:"mfaRequired,omitempty"`
+	MfaToken              string `json:"mfaToken,omitempty"`
+	MfaEnrollmentRequired bool   `json:"mfaEnrollmentRequired,omitempty"`
+}
+
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+type ValidationResponse struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
 type User struct {
-	Id       int    `json:"id"`
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
-	Email    string `json:"email" validate:"omitempty,email"`
+	Id            int        `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	Role          string     `json:"role"`
+	Disabled      bool       `json:"disabled"`
+	EmailVerified bool       `json:"emailVerified"`
+	TOTPEnabled   bool       `json:"totpEnabled"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	DeletedAt     *time.Time `json:"deletedAt,omitempty"`
+}
+
+const (
+	UserActive   = "active"
+	UserDisabled = "disabled"
+	UserDeleted  = "deleted"
+)
+
+// Status is the state the admin user filter matches: deleted wins over
+// disabled.
+func (u User) Status() string {
+	switch {
+	case u.DeletedAt != nil:
+		return UserDeleted
+	case u.Disabled:
+		return UserDisabled
+	default:
+		return UserActive
+	}
+}
+
+type UserFilter struct {
+	Query          string    `form:"q"`
+	Role           string    `form:"role" validate:"omitempty,oneof=user admin"`
+	Status         string    `form:"status" validate:"omitempty,oneof=active disabled deleted"`
+	RegisteredFrom time.Time `form:"registeredFrom" time_format:"2006-01-02T15:04:05Z07:00"`
+	RegisteredTo   time.Time `form:"registeredTo" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit          int       `form:"limit" validate:"omitempty,min=1,max=500"`
+	Offset         int       `form:"offset" validate:"omitempty,min=0"`
 }
 
 type Product struct {
-	Id          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Price       int    `json:"price"`
-	Quantity    int    `json:"quantity"`
+	Id          int        `json:"id"`
+	Sku         string     `json:"sku" validate:"max=64"`
+	Name        string     `json:"name" validate:"required,max=255"`
+	Description string     `json:"description" validate:"max=2000"`
+	Price       int        `json:"price" validate:"gt=0"`
+	Quantity    int        `json:"quantity" validate:"gte=0"`
+	Version     int        `json:"version"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+	Rating      float64    `json:"rating"`
+	ReviewCount int        `json:"reviewCount"`
+}
+
+const (
+	ImportCreated    = "created"
+	ImportUpdated    = "updated"
+	ImportInvalid    = "invalid"
+	ImportFailed     = "failed"
+	ImportRolledBack = "rolled_back"
+)
+
+type ImportResult struct {
+	Line   int    `json:"line"`
+	Sku    string `json:"sku,omitempty"`
+	Id     int    `json:"id,omitempty"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+
+	// PreviousQuantity is the stock of an updated product before the
+	// import, 0 if it was deleted. With Quantity it tells which products
+	// changed stock or came back in stock.
+	PreviousQuantity int `json:"-"`
+	Quantity         int `json:"-"`
+}
+
+type ImportReport struct {
+	DryRun  bool           `json:"dryRun"`
+	Mode    string         `json:"mode"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Failed  int            `json:"failed"`
+	Rows    []ImportResult `json:"rows"`
+}
+
+// ProductImage is an uploaded picture of a product. The original and its
+// thumbnails are stored in the blob store under Key.
+type ProductImage struct {
+	Id            int               `json:"id"`
+	ProductId     int               `json:"productId"`
+	Key           string            `json:"-"`
+	ContentType   string            `json:"contentType"`
+	ThumbnailType string            `json:"-"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Size          int64             `json:"size"`
+	Position      int               `json:"position"`
+	Primary       bool              `json:"primary"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	Urls          map[string]string `json:"urls"`
+}
+
+const (
+	ReviewVisible = "visible"
+	ReviewFlagged = "flagged"
+	ReviewHidden  = "hidden"
+)
+
+// Review is a rating with an optional text. Hidden reviews are left out of
+// listings and of the product rating.
+type Review struct {
+	Id        int       `json:"id"`
+	ProductId int       `json:"productId"`
+	UserId    int       `json:"userId"`
+	Rating    int       `json:"rating" validate:"required,min=1,max=5"`
+	Text      string    `json:"text" validate:"max=5000"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type Notification struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"userId"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ReadAt    *time.Time `json:"readAt"`
+}
+
+// PurchaseSummary sums up the purchases of a user. Spent is computed with
+// the current prices, the price paid is not stored.
+type PurchaseSummary struct {
+	UserId          int    `json:"userId"`
+	Purchases       int    `json:"purchases"`
+	Items           int    `json:"items"`
+	Products        int    `json:"products"`
+	Spent           int    `json:"spent"`
+	FirstPurchaseAt string `json:"firstPurchaseAt"`
+	LastPurchaseAt  string `json:"lastPurchaseAt"`
+}
+
+// Scopes of API keys.
+const (
+	ScopeCatalogRead   = "catalog:read"
+	ScopeCatalogWrite  = "catalog:write"
+	ScopePurchasesRead = "purchases:read"
+)
+
+// APIKey lets an integration call the API without a user session. Only the
+// hash of the key is stored, Prefix identifies the key.
+type APIKey struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
 }
 
 type Purchase struct {
@@ -26,3 +235,74 @@ type Purchase struct {
 	Quantity  int    `json:"quantity"`
 	Timestamp string `json:"timestamp"`
 }
+
+const (
+	EventPurchaseCreated     = "purchase.created"
+	EventProductStockChanged = "product.stock_changed"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+type Webhook struct {
+	Id     int      `json:"id"`
+	Url    string   `json:"url" validate:"required,url"`
+	Secret string   `json:"secret" validate:"required"`
+	Events []string `json:"events" validate:"required,min=1,dive,oneof=purchase.created product.stock_changed"`
+	Active bool     `json:"active"`
+}
+
+type WebhookDelivery struct {
+	Id            int       `json:"id"`
+	WebhookId     int       `json:"webhookId"`
+	Event         string    `json:"event"`
+	Payload       string    `json:"payload"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	ResponseCode  int       `json:"responseCode"`
+	LastError     string    `json:"lastError"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+type AuditEntry struct {
+	Id        int             `json:"id"`
+	ActorId   int             `json:"actorId"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Diff      json.RawMessage `json:"diff"`
+	Ip        string          `json:"ip"`
+	RequestId string          `json:"requestId"`
+	CreatedAt time.Time       `json:"createdAt"`
+	PrevHash  string          `json:"prevHash"`
+	Hash      string          `json:"hash"`
+}
+
+type AuditFilter struct {
+	ActorId int       `form:"actorId"`
+	Action  string    `form:"action"`
+	Target  string    `form:"target"`
+	From    time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To      time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit   int       `form:"limit" validate:"omitempty,min=1,max=500"`
+	Offset  int       `form:"offset" validate:"omitempty,min=0"`
+}
+
+// ExternalIdentity links an account at an OpenID Connect provider, identified
+// by the issuer and the subject, to a user.
+type ExternalIdentity struct {
+	Id            int        `json:"id"`
+	UserId        int        `json:"userId"`
+	Issuer        string     `json:"issuer"`
+	Subject       string     `json:"subject"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"emailVerified"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	LastLoginAt   *time.Time `json:"lastLoginAt"`
+
+	// Username is the one the provider suggests for a new user.
+	Username string `json:"-"`
+}
@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ctxKey int
+
+const (
+	requestIdKey ctxKey = iota
+	userIdKey
+)
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey, requestId)
+}
+
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
+
+func WithUserId(ctx context.Context, userId int) context.Context {
+	return context.WithValue(ctx, userIdKey, userId)
+}
+
+func UserId(ctx context.Context) int {
+	userId, _ := ctx.Value(userIdKey).(int)
+	return userId
+}
+
+var level slog.LevelVar
+
+// New returns a JSON logger that adds the request, user and trace ids stored in
+// the context to every record logged with one of the *Context methods.
+func New(w io.Writer, lvl string) *slog.Logger {
+	SetLevel(lvl)
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: &level})
+	return slog.New(contextHandler{handler})
+}
+
+// SetLevel changes the level of every logger created by New. Unknown levels
+// log at info.
+func SetLevel(lvl string) {
+	parsed, err := ParseLevel(lvl)
+	if err != nil {
+		parsed = slog.LevelInfo
+	}
+
+	level.Set(parsed)
+}
+
+// ParseLevel accepts debug, info, warn (or warning) and error in any case.
+// The configuration is validated with it, so both accept the same levels.
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("log_level must be one of debug, info, warn, error")
+	}
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestId := RequestId(ctx); requestId != "" {
+		record.AddAttrs(slog.String("request_id", requestId))
+	}
+
+	if userId := UserId(ctx); userId != 0 {
+		record.AddAttrs(slog.Int("user_id", userId))
+	}
+
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()), slog.String("span_id", spanContext.SpanID().String()))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,178 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails. Besides SMTP there are implementations that only log
+// or write files, so that flows relying on email work offline.
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+// smtpTimeout bounds a send when SMTPConfig.Timeout isn't set.
+const smtpTimeout = time.Second * 30
+
+type SMTPConfig struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+	// Timeout bounds the whole conversation with the server, including the
+	// dial.
+	Timeout time.Duration
+}
+
+// SMTPMailer sends plain text emails through an SMTP server.
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPMailer(cfg SMTPConfig) *SMTPMailer {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = smtpTimeout
+	}
+
+	return &SMTPMailer{cfg: cfg}
+}
+
+// Send works like smtp.SendMail, but gives up when ctx is done or the
+// timeout passes, so that a stalled server can't hold a shutdown.
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	host, _, err := net.SplitHostPort(m.cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", m.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	// The deadline covers the timeout, a cancelled ctx interrupts the
+	// conversation at once.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if m.cfg.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+
+		if err := client.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(m.cfg.From); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(mail.To); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(message(m.cfg.From, mail)); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// LogMailer writes emails to the log instead of sending them.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, mail Mail) error {
+	slog.InfoContext(ctx, "mail", "to", mail.To, "subject", mail.Subject, "body", mail.Body)
+	return nil
+}
+
+// FileMailer writes every email as an .eml file into a directory.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, mail Mail) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitize(mail.To))
+	return os.WriteFile(filepath.Join(m.dir, name), message(m.from, mail), 0o600)
+}
+
+func message(from string, mail Mail) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(mail.Body)
+
+	return msg.Bytes()
+}
+
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, address)
+}
@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/mail/smtptest"
+)
+
+func TestSMTPMailerSends(t *testing.T) {
+	server, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	mailer := NewSMTPMailer(SMTPConfig{Addr: server.Addr(), From: "go-market@example.com"})
+	if err := mailer.Send(context.Background(), Mail{To: "alice@example.com", Subject: "Hello", Body: "Hi Alice\n.\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("messages = %+v", messages)
+	}
+
+	message := messages[0]
+	if message.From != "go-market@example.com" || len(message.To) != 1 || message.To[0] != "alice@example.com" {
+		t.Errorf("envelope = %q to %q", message.From, message.To)
+	}
+
+	if data := string(message.Data); !strings.Contains(data, "Subject: Hello\r\n") || !strings.HasSuffix(data, "\r\n\r\nHi Alice\r\n.\r\n") {
+		t.Errorf("data = %q", data)
+	}
+}
+
+func TestSMTPMailerGivesUpOnStalledServer(t *testing.T) {
+	server, err := smtptest.NewStalledServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		{
+			name:    "timeout",
+			timeout: time.Millisecond * 200,
+			ctx:     func() (context.Context, context.CancelFunc) { return context.Background(), func() {} },
+		},
+		{
+			name:    "context",
+			timeout: time.Hour,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), time.Millisecond*200)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := test.ctx()
+			defer cancel()
+
+			mailer := NewSMTPMailer(SMTPConfig{Addr: server.Addr(), From: "go-market@example.com", Timeout: test.timeout})
+
+			start := time.Now()
+			if err := mailer.Send(ctx, Mail{To: "alice@example.com", Subject: "Hello", Body: "Hi"}); err == nil {
+				t.Error("no error from a stalled server")
+			}
+
+			if elapsed := time.Since(start); elapsed > time.Second*2 {
+				t.Errorf("gave up after %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Package smtptest is a minimal SMTP server for tests. It accepts every
+// message without authentication and keeps it in memory, so it must never be
+// reachable from production.
+package smtptest
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Message is a mail as it was received, Data holds the headers and the body.
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// Server listens on a random local port. A stalled server accepts
+// connections but never answers, like a server that hangs.
+type Server struct {
+	listener net.Listener
+	stalled  bool
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	messages []Message
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on 127.0.0.1.
+func NewServer() (*Server, error) {
+	return start(false)
+}
+
+// NewStalledServer starts a server that never answers.
+func NewStalledServer() (*Server, error) {
+	return start(true)
+}
+
+func start(stalled bool) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{listener: listener, stalled: stalled}
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr is the host:port of the server.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// Close stops the server and drops the open connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+
+			if s.stalled {
+				// Wait until either side closes the connection.
+				conn.Read(make([]byte, 1))
+				return
+			}
+
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+
+	if !reply("220 smtptest ready") {
+		return
+	}
+
+	message := Message{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command := strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 smtptest")
+		case "MAIL":
+			message = Message{From: address(command)}
+			reply("250 OK")
+		case "RCPT":
+			message.To = append(message.To, address(command))
+			reply("250 OK")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+
+			data, err := readData(r)
+			if err != nil {
+				return
+			}
+
+			message.Data = data
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// readData reads the lines of a DATA command up to the final dot and undoes
+// the dot-stuffing.
+func readData(r *bufio.Reader) ([]byte, error) {
+	var data bytes.Buffer
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		if line == ".\r\n" {
+			return data.Bytes(), nil
+		}
+
+		data.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+// address returns the address of "MAIL FROM:<a@b>" or "RCPT TO:<a@b>".
+func address(command string) string {
+	start, end := strings.Index(command, "<"), strings.LastIndex(command, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+
+	return command[start+1 : end]
+}
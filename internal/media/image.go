@@ -0,0 +1,159 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailSizes are the bounding boxes, in pixels, of the generated thumbnails.
+var ThumbnailSizes = []int{150, 400, 800}
+
+// MaxPixels guards against decompression bombs: small files that decode
+// into huge images.
+const MaxPixels = 40_000_000
+
+// ErrUnsupportedType is returned for uploads that are not a supported image.
+var ErrUnsupportedType = errors.New("unsupported image type")
+
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Image is a decoded upload together with its generated thumbnails.
+type Image struct {
+	ContentType string
+	Width       int
+	Height      int
+	Thumbnails  map[int][]byte
+	// ThumbnailType is image/png for sources that can be transparent and
+	// image/jpeg otherwise.
+	ThumbnailType string
+}
+
+// SniffContentType detects the type from the content itself, ignoring
+// whatever the client claimed, and rejects anything but supported images.
+func SniffContentType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if !allowedTypes[contentType] {
+		return "", fmt.Errorf("%w %q, use jpeg, png, gif or webp", ErrUnsupportedType, contentType)
+	}
+
+	return contentType, nil
+}
+
+// BlobKey returns the key of a variant of the image stored under key: the
+// original or the thumbnail of a size.
+func BlobKey(key, variant string) string {
+	return key + "/" + variant
+}
+
+// DeleteImageBlobs removes the originals and the thumbnails of the images
+// stored under the keys. Failures are only logged, a leftover blob is
+// harmless.
+func DeleteImageBlobs(ctx context.Context, blobs BlobStore, keys ...string) {
+	for _, key := range keys {
+		variants := []string{BlobKey(key, "original")}
+		for _, size := range ThumbnailSizes {
+			variants = append(variants, BlobKey(key, strconv.Itoa(size)))
+		}
+
+		for _, variant := range variants {
+			if err := blobs.Delete(ctx, variant); err != nil {
+				slog.ErrorContext(ctx, "failed to delete image blob", "key", variant, "error", err)
+			}
+		}
+	}
+}
+
+// Process validates data as an image and renders a thumbnail for every size
+// in ThumbnailSizes. Images are never upscaled.
+func Process(data []byte) (Image, error) {
+	contentType, err := SniffContentType(data)
+	if err != nil {
+		return Image{}, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid image: %w", err)
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return Image{}, fmt.Errorf("image is too large: %dx%d", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid image: %w", err)
+	}
+
+	result := Image{
+		ContentType:   contentType,
+		Width:         config.Width,
+		Height:        config.Height,
+		Thumbnails:    map[int][]byte{},
+		ThumbnailType: "image/jpeg",
+	}
+	if contentType == "image/png" || contentType == "image/gif" {
+		result.ThumbnailType = "image/png"
+	}
+
+	for _, size := range ThumbnailSizes {
+		thumbnail, err := encode(resize(src, size), result.ThumbnailType)
+		if err != nil {
+			return Image{}, err
+		}
+
+		result.Thumbnails[size] = thumbnail
+	}
+
+	return result, nil
+}
+
+// resize scales src to fit into a size x size box keeping the aspect ratio.
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return src
+	}
+
+	if width >= height {
+		height = max(1, height*size/width)
+		width = size
+	} else {
+		width = max(1, width*size/height)
+		height = size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+
+	return buf.Bytes(), err
+}
@@ -0,0 +1,164 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func encodeTestImage(t *testing.T, width, height int, format string) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// pngHeader returns the start of a PNG that claims the given size, which is
+// all DecodeConfig reads.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8], ihdr[9] = 8, 2
+
+	chunk := append([]byte("IHDR"), ihdr...)
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+
+	return buf.Bytes()
+}
+
+func TestProcessSniffsContentType(t *testing.T) {
+	tests := []struct {
+		data          []byte
+		contentType   string
+		thumbnailType string
+	}{
+		{data: encodeTestImage(t, 20, 10, "png"), contentType: "image/png", thumbnailType: "image/png"},
+		{data: encodeTestImage(t, 20, 10, "jpeg"), contentType: "image/jpeg", thumbnailType: "image/jpeg"},
+	}
+
+	for _, test := range tests {
+		processed, err := Process(test.data)
+		if err != nil {
+			t.Fatalf("Process(%s): %v", test.contentType, err)
+		}
+
+		if processed.ContentType != test.contentType || processed.ThumbnailType != test.thumbnailType {
+			t.Errorf("Process(%s) = %s with %s thumbnails", test.contentType, processed.ContentType, processed.ThumbnailType)
+		}
+
+		if processed.Width != 20 || processed.Height != 10 {
+			t.Errorf("Process(%s) size = %dx%d", test.contentType, processed.Width, processed.Height)
+		}
+	}
+
+	for _, data := range [][]byte{[]byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), []byte("%PDF-1.4"), {}} {
+		if _, err := Process(data); !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("Process(%q): %v, want ErrUnsupportedType", data, err)
+		}
+	}
+
+	// A PNG signature alone doesn't make an image.
+	if _, err := Process([]byte("\x89PNG\r\n\x1a\nnot really")); err == nil || errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Process of a broken PNG: %v, want an invalid image", err)
+	}
+}
+
+func TestProcessRejectsTooManyPixels(t *testing.T) {
+	_, err := Process(pngHeader(10_000, 10_000))
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("Process of a 10000x10000 PNG: %v, want too large", err)
+	}
+
+	// Just below the limit the header passes the guard and decoding fails
+	// on the missing pixel data instead.
+	_, err = Process(pngHeader(MaxPixels/1000, 1000))
+	if err == nil || strings.Contains(err.Error(), "too large") {
+		t.Fatalf("Process at the limit: %v, want an invalid image", err)
+	}
+}
+
+func TestProcessThumbnailSizes(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          map[int]image.Point
+	}{
+		{width: 1000, height: 500, want: map[int]image.Point{150: {150, 75}, 400: {400, 200}, 800: {800, 400}}},
+		{width: 300, height: 600, want: map[int]image.Point{150: {75, 150}, 400: {200, 400}, 800: {300, 600}}},
+		{width: 100, height: 80, want: map[int]image.Point{150: {100, 80}, 400: {100, 80}, 800: {100, 80}}},
+	}
+
+	for _, test := range tests {
+		processed, err := Process(encodeTestImage(t, test.width, test.height, "png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(processed.Thumbnails) != len(ThumbnailSizes) {
+			t.Errorf("%dx%d: %d thumbnails, want %d", test.width, test.height, len(processed.Thumbnails), len(ThumbnailSizes))
+		}
+
+		for size, want := range test.want {
+			config, format, err := image.DecodeConfig(bytes.NewReader(processed.Thumbnails[size]))
+			if err != nil {
+				t.Fatalf("%dx%d: thumbnail %d: %v", test.width, test.height, size, err)
+			}
+
+			if format != "png" || config.Width != want.X || config.Height != want.Y {
+				t.Errorf("%dx%d: thumbnail %d is a %dx%d %s, want %dx%d png", test.width, test.height, size, config.Width, config.Height, format, want.X, want.Y)
+			}
+		}
+	}
+}
+
+type recordingStore struct {
+	BlobStore
+	deleted []string
+}
+
+func (r *recordingStore) Delete(ctx context.Context, key string) error {
+	r.deleted = append(r.deleted, key)
+	return nil
+}
+
+func TestDeleteImageBlobs(t *testing.T) {
+	store := &recordingStore{}
+	DeleteImageBlobs(context.Background(), store, "products/1/a", "products/2/b")
+
+	want := []string{
+		"products/1/a/original", "products/1/a/150", "products/1/a/400", "products/1/a/800",
+		"products/2/b/original", "products/2/b/150", "products/2/b/400", "products/2/b/800",
+	}
+	if strings.Join(store.deleted, " ") != strings.Join(want, " ") {
+		t.Errorf("deleted %v, want %v", store.deleted, want)
+	}
+}
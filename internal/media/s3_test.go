@@ -0,0 +1,273 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+type object struct {
+	data        []byte
+	contentType string
+}
+
+// s3Stub is a bucket that checks the Signature Version 4 of every request
+// the way S3 does, from what arrives on the wire.
+type s3Stub struct {
+	bucket string
+	secret string
+
+	mu      sync.Mutex
+	objects map[string]object
+	paths   []string
+}
+
+func newS3Stub(t *testing.T) (*s3Stub, *httptest.Server) {
+	stub := &s3Stub{bucket: "media", secret: testSecretKey, objects: map[string]object{}}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+
+	return stub, server
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.verify(r); err != nil {
+		http.Error(w, "SignatureDoesNotMatch: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	rawPath, _, _ := strings.Cut(r.RequestURI, "?")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths = append(s.paths, rawPath)
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+s.bucket+"/")
+	if !ok {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(data)) {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+
+		s.objects[key] = object{data: data, contentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		obj, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Write(obj.data)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *s3Stub) verify(r *http.Request) error {
+	credential, signedHeaders, signature, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return err
+	}
+
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] != testAccessKey || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return errors.New("bad credential " + credential)
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, parts[1]) {
+		return errors.New("credential date doesn't match x-amz-date")
+	}
+
+	canonicalHeaders := ""
+	for _, name := range strings.Split(signedHeaders, ";") {
+		value := strings.Join(r.Header.Values(name), ",")
+		if name == "host" {
+			value = r.Host
+		}
+
+		canonicalHeaders += name + ":" + strings.TrimSpace(value) + "\n"
+	}
+
+	for _, required := range []string{"host", "x-amz-date", "x-amz-content-sha256"} {
+		if !strings.Contains(";"+signedHeaders+";", ";"+required+";") {
+			return errors.New(required + " is not signed")
+		}
+	}
+
+	rawPath, rawQuery, _ := strings.Cut(r.RequestURI, "?")
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		rawPath,
+		rawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join(parts[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	mac := hmac.New(sha256.New, signingKey(s.secret, parts[1], parts[2], "s3"))
+	mac.Write([]byte(stringToSign))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(signature)) {
+		return errors.New("signature doesn't match")
+	}
+
+	return nil
+}
+
+func parseAuthorization(header string) (string, string, string, error) {
+	params, ok := strings.CutPrefix(header, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return "", "", "", errors.New("unsupported authorization " + header)
+	}
+
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		values[name] = value
+	}
+
+	if values["Credential"] == "" || values["SignedHeaders"] == "" || values["Signature"] == "" {
+		return "", "", "", errors.New("incomplete authorization " + header)
+	}
+
+	return values["Credential"], values["SignedHeaders"], values["Signature"], nil
+}
+
+func newTestS3Store(t *testing.T, endpoint, secret string) *S3Store {
+	t.Helper()
+
+	store, err := NewS3Store(S3Config{Endpoint: endpoint + "/", Bucket: "media", AccessKey: testAccessKey, SecretKey: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestS3StorePutGetDelete(t *testing.T) {
+	stub, server := newS3Stub(t)
+	store := newTestS3Store(t, server.URL, testSecretKey)
+	ctx := context.Background()
+
+	keys := []string{"products/1/abc/original", "products/1/a b/ü+=.png"}
+	for _, key := range keys {
+		data := []byte("content of " + key)
+		if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+
+		body, err := store.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+
+		got, _ := io.ReadAll(body)
+		body.Close()
+		if !bytes.Equal(got, data) {
+			t.Errorf("Get(%q) = %q, want %q", key, got, data)
+		}
+
+		if err := store.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete(%q): %v", key, err)
+		}
+
+		if _, err := store.Get(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("Get(%q) after Delete: %v, want ErrBlobNotFound", key, err)
+		}
+	}
+
+	// Deleting a missing blob is not an error.
+	if err := store.Delete(ctx, "products/1/missing/original"); err != nil {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	// The canonical URI encodes everything but the unreserved characters.
+	if want := "/media/products/1/a%20b/%C3%BC%2B%3D.png"; !slices.Contains(stub.paths, want) {
+		t.Errorf("paths %v, want %s among them", stub.paths, want)
+	}
+}
+
+func TestS3StoreContentType(t *testing.T) {
+	stub, server := newS3Stub(t)
+	store := newTestS3Store(t, server.URL, testSecretKey)
+
+	if err := store.Put(context.Background(), "a/original", strings.NewReader("x"), 1, "image/webp"); err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if got := stub.objects["a/original"].contentType; got != "image/webp" {
+		t.Errorf("content type = %q", got)
+	}
+}
+
+func TestS3StoreWrongSecret(t *testing.T) {
+	_, server := newS3Stub(t)
+	store := newTestS3Store(t, server.URL, "not-the-secret")
+
+	err := store.Put(context.Background(), "a/original", strings.NewReader("x"), 1, "image/png")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Put with a wrong secret: %v, want 403", err)
+	}
+}
+
+// TestSigningKey checks the key derivation against the example of the AWS
+// documentation.
+func TestSigningKey(t *testing.T) {
+	key := signingKey(testSecretKey, "20120215", "us-east-1", "iam")
+
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got := hex.EncodeToString(key); got != want {
+		t.Errorf("signing key = %s, want %s", got, want)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := map[string]string{
+		"products/1/abc/original": "products/1/abc/original",
+		"a b":                     "a%20b",
+		"ü":                       "%C3%BC",
+		"a+b=c&d":                 "a%2Bb%3Dc%26d",
+		"-_.~":                    "-_.~",
+	}
+
+	for path, want := range tests {
+		if got := escapePath(path); got != want {
+			t.Errorf("escapePath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
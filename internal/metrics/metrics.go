@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomarket_http_requests_total",
+		Help: "Number of HTTP requests by route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gomarket_http_request_duration_seconds",
+		Help:    "HTTP request latency by route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	storageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gomarket_storage_query_duration_seconds",
+		Help:    "Latency of database queries by storage method.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"method"})
+
+	storageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomarket_storage_errors_total",
+		Help: "Number of failed database queries by storage method.",
+	}, []string{"method"})
+
+	purchases = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomarket_purchases_total",
+		Help: "Number of successful purchases.",
+	})
+
+	unitsSold = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomarket_units_sold_total",
+		Help: "Number of product units sold.",
+	})
+
+	revenue = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomarket_revenue_total",
+		Help: "Sum of price times quantity of successful purchases.",
+	})
+
+	failedPurchases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomarket_failed_purchases_total",
+		Help: "Number of failed purchases by reason.",
+	}, []string{"reason"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+
+	labels := prometheus.Labels{"method": method, "route": route, "status": strconv.Itoa(status)}
+	httpRequests.With(labels).Inc()
+	httpDuration.With(labels).Observe(duration.Seconds())
+}
+
+func ObserveQuery(method string, duration time.Duration, err error) {
+	storageDuration.WithLabelValues(method).Observe(duration.Seconds())
+	if err != nil {
+		storageErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func PurchaseMade(quantity, price int) {
+	purchases.Inc()
+	unitsSold.Add(float64(quantity))
+	revenue.Add(float64(quantity * price))
+}
+
+func PurchaseFailed(reason string) {
+	failedPurchases.WithLabelValues(reason).Inc()
+}
+
+// RegisterPoolStats exposes the connection pool statistics returned by stat.
+func RegisterPoolStats(stat func() *pgxpool.Stat) {
+	prometheus.MustRegister(poolCollector{stat: stat})
+}
+
+var (
+	poolAcquiredConns = prometheus.NewDesc("gomarket_db_pool_acquired_conns", "Number of currently acquired connections.", nil, nil)
+	poolIdleConns     = prometheus.NewDesc("gomarket_db_pool_idle_conns", "Number of currently idle connections.", nil, nil)
+	poolTotalConns    = prometheus.NewDesc("gomarket_db_pool_total_conns", "Total number of connections in the pool.", nil, nil)
+	poolMaxConns      = prometheus.NewDesc("gomarket_db_pool_max_conns", "Maximum size of the pool.", nil, nil)
+	poolAcquireCount  = prometheus.NewDesc("gomarket_db_pool_acquire_total", "Number of successful connection acquires.", nil, nil)
+	poolAcquireWait   = prometheus.NewDesc("gomarket_db_pool_acquire_wait_seconds_total", "Time spent waiting for a connection.", nil, nil)
+	poolEmptyAcquire  = prometheus.NewDesc("gomarket_db_pool_empty_acquire_total", "Number of acquires that had to wait for a connection.", nil, nil)
+)
+
+type poolCollector struct {
+	stat func() *pgxpool.Stat
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquireCount
+	ch <- poolAcquireWait
+	ch <- poolEmptyAcquire
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireWait, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquire, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+}
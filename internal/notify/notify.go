@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ursuldaniel/go-market/internal/mail"
+)
+
+// Message is a notification for one user. Email is only used by notifiers
+// that deliver outside of the application.
+type Message struct {
+	UserId  int
+	Email   string
+	Subject string
+	Body    string
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+type InboxStore interface {
+	AddNotification(ctx context.Context, userId int, subject, body string) error
+}
+
+// Inbox keeps notifications in the database, users read them through the API.
+type Inbox struct {
+	store InboxStore
+}
+
+func NewInbox(store InboxStore) *Inbox {
+	return &Inbox{store: store}
+}
+
+func (i *Inbox) Notify(ctx context.Context, message Message) error {
+	return i.store.AddNotification(ctx, message.UserId, message.Subject, message.Body)
+}
+
+// Email sends notifications to the email address of the user.
+type Email struct {
+	mailer mail.Mailer
+}
+
+func NewEmail(mailer mail.Mailer) *Email {
+	return &Email{mailer: mailer}
+}
+
+func (e *Email) Notify(ctx context.Context, message Message) error {
+	if message.Email == "" {
+		return fmt.Errorf("user %d has no email", message.UserId)
+	}
+
+	return e.mailer.Send(ctx, mail.Mail{To: message.Email, Subject: message.Subject, Body: message.Body})
+}
@@ -0,0 +1,317 @@
+// Package fakeidp is a minimal OpenID Connect provider for development and
+// tests. It signs in whoever enters an email address, so it must never be
+// reachable from production. The flow is checked as strictly as by a real
+// provider: PKCE is required, codes are single-use and short-lived, and ID
+// tokens are signed with RS256.
+package fakeidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/ursuldaniel/go-market/internal/oidc"
+)
+
+const (
+	codeTTL    = time.Minute
+	idTokenTTL = time.Minute * 10
+)
+
+type Config struct {
+	// Issuer defaults to http://<host of the request>, which suits
+	// httptest servers whose address is known only after they start.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// Server is the provider. It is an http.Handler.
+type Server struct {
+	cfg   Config
+	key   *rsa.PrivateKey
+	keyID string
+	mux   *http.ServeMux
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is what a code stands for until it is redeemed.
+type authorization struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	issuer      string
+	expiresAt   time.Time
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Fake identity provider</title></head>
+<body>
+<h1>Fake identity provider</h1>
+<form method="post">
+{{range $name, $values := .}}<input type="hidden" name="{{$name}}" value="{{index $values 0}}">
+{{end}}<label>Email <input type="email" name="login_hint" required autofocus></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+func New(cfg Config) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:   cfg,
+		key:   key,
+		keyID: oidc.RandomString(8),
+		mux:   http.NewServeMux(),
+		codes: map[string]authorization{},
+	}
+
+	s.mux.HandleFunc("/.well-known/openid-configuration", s.handleDiscovery)
+	s.mux.HandleFunc("/jwks", s.handleKeys)
+	s.mux.HandleFunc("/authorize", s.handleAuthorize)
+	s.mux.HandleFunc("/token", s.handleToken)
+
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) issuer(r *http.Request) string {
+	if s.cfg.Issuer != "" {
+		return strings.TrimSuffix(s.cfg.Issuer, "/")
+	}
+
+	return "http://" + r.Host
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	issuer := s.issuer(r)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	jwk, err := oidc.NewJSONWebKey(s.keyID, "RS256", &s.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, oidc.JSONWebKeySet{Keys: []oidc.JSONWebKey{jwk}})
+}
+
+// handleAuthorize shows the login form, or signs in the user of login_hint
+// right away, which lets tests run the flow without a browser.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Errors about the client and the redirect URI are shown to the user,
+	// the provider must not redirect to an unchecked address.
+	redirectURI := r.Form.Get("redirect_uri")
+	if r.Form.Get("client_id") != s.cfg.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(redirectURI)
+	if err != nil || !target.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	state := r.Form.Get("state")
+	redirectError := func(code, description string) {
+		query := target.Query()
+		query.Set("error", code)
+		query.Set("error_description", description)
+		query.Set("state", state)
+		target.RawQuery = query.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}
+
+	if r.Form.Get("response_type") != "code" {
+		redirectError("unsupported_response_type", "only the code flow is supported")
+		return
+	}
+
+	if r.Form.Get("code_challenge") == "" || r.Form.Get("code_challenge_method") != "S256" {
+		redirectError("invalid_request", "a S256 code challenge is required")
+		return
+	}
+
+	if !strings.Contains(" "+r.Form.Get("scope")+" ", " openid ") {
+		redirectError("invalid_scope", "the openid scope is required")
+		return
+	}
+
+	email := strings.TrimSpace(r.Form.Get("login_hint"))
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, r.Form)
+		return
+	}
+
+	code := oidc.RandomString(32)
+	s.mu.Lock()
+	for unused, auth := range s.codes {
+		if time.Now().After(auth.expiresAt) {
+			delete(s.codes, unused)
+		}
+	}
+	s.codes[code] = authorization{
+		redirectURI: redirectURI,
+		challenge:   r.Form.Get("code_challenge"),
+		nonce:       r.Form.Get("nonce"),
+		email:       strings.ToLower(email),
+		issuer:      s.issuer(r),
+		expiresAt:   time.Now().Add(codeTTL),
+	}
+	s.mu.Unlock()
+
+	query := target.Query()
+	query.Set("code", code)
+	query.Set("state", state)
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if !s.authenticateClient(r) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// Codes are removed on first use, whether the exchange succeeds or not.
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	auth, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(auth.expiresAt) {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code is invalid or expired")
+		return
+	}
+
+	if r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri doesn't match")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(oidc.Challenge(r.PostForm.Get("code_verifier"))), []byte(auth.challenge)) != 1 {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier doesn't match the challenge")
+		return
+	}
+
+	idToken, err := s.idToken(auth)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": oidc.RandomString(32),
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+// authenticateClient accepts client_secret_basic and client_secret_post.
+// Public clients without a secret rely on PKCE alone.
+func (s *Server) authenticateClient(r *http.Request) bool {
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	return clientID == s.cfg.ClientID && subtle.ConstantTimeCompare([]byte(secret), []byte(s.cfg.ClientSecret)) == 1
+}
+
+func (s *Server) idToken(auth authorization) (string, error) {
+	// The subject is stable per email, like a real account id.
+	sum := sha256.Sum256([]byte(auth.email))
+	username, _, _ := strings.Cut(auth.email, "@")
+
+	claims := jwt.MapClaims{
+		"iss":                auth.issuer,
+		"sub":                hex.EncodeToString(sum[:16]),
+		"aud":                s.cfg.ClientID,
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(idTokenTTL).Unix(),
+		"email":              auth.email,
+		"email_verified":     true,
+		"name":               username,
+		"preferred_username": username,
+	}
+	if auth.nonce != "" {
+		claims["nonce"] = auth.nonce
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+
+	return token.SignedString(s.key)
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,379 @@
+// Package oidc implements the relying party side of OpenID Connect: the
+// authorization code flow with PKCE (RFC 7636) and the verification of ID
+// tokens against the keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// keysRefreshInterval limits how often an unknown key id makes the provider
+// fetch its keys again, so that forged tokens can't flood the provider.
+const keysRefreshInterval = time.Minute
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// DiscoveryURL defaults to the well-known address under Issuer.
+	DiscoveryURL string
+
+	// JWKSURL overrides the jwks_uri of the discovery document.
+	JWKSURL string
+}
+
+// Metadata is the part of the discovery document the flow needs.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Claims are the claims of a verified ID token that identify the user.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Provider talks to one OpenID Connect provider. The discovery document and
+// the keys are fetched on first use and cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *Metadata
+	keys          map[string]any
+	keysFetchedAt time.Time
+}
+
+func New(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc issuer, client id and redirect url are required")
+	}
+
+	if cfg.DiscoveryURL == "" {
+		cfg.DiscoveryURL = strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid"}
+	}
+
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: time.Second * 10}}, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// AuthCodeURL returns the address the user is sent to for signing in. The
+// verifier stays with the caller, only its challenge is sent.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code and returns the claims of the ID
+// token that comes with it.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var response struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Claims{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&response); err != nil {
+		return Claims{}, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	if response.Error != "" {
+		return Claims{}, fmt.Errorf("token endpoint: %s %s", response.Error, response.ErrorDescription)
+	}
+
+	if resp.StatusCode != http.StatusOK || response.IdToken == "" {
+		return Claims{}, fmt.Errorf("token endpoint returned %s without an id token", resp.Status)
+	}
+
+	return p.VerifyIDToken(ctx, response.IdToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, lifetime and nonce
+// of an ID token.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (Claims, error) {
+	errInvalid := errors.New("id token is invalid")
+
+	token, err := jwt.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return Claims{}, fmt.Errorf("%w: %v", errInvalid, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Claims{}, errInvalid
+	}
+
+	// exp is optional for jwt.MapClaims.Valid, but required in ID tokens.
+	if _, ok := claims["exp"]; !ok {
+		return Claims{}, fmt.Errorf("%w: no expiry", errInvalid)
+	}
+
+	if !claims.VerifyIssuer(p.cfg.Issuer, true) {
+		return Claims{}, fmt.Errorf("%w: wrong issuer", errInvalid)
+	}
+
+	if !p.verifyAudience(claims) {
+		return Claims{}, fmt.Errorf("%w: wrong audience", errInvalid)
+	}
+
+	tokenNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return Claims{}, fmt.Errorf("%w: wrong nonce", errInvalid)
+	}
+
+	result := Claims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.Name, _ = claims["name"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+
+	// Some providers send email_verified as a string.
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string:
+		result.EmailVerified = verified == "true"
+	}
+
+	if result.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: no subject", errInvalid)
+	}
+
+	return result, nil
+}
+
+// verifyAudience requires the client among the audiences, and as the
+// authorized party when there are several.
+func (p *Provider) verifyAudience(claims jwt.MapClaims) bool {
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+
+	found := false
+	for _, aud := range audiences {
+		if aud == p.cfg.ClientID {
+			found = true
+		}
+	}
+
+	if len(audiences) > 1 {
+		azp, _ := claims["azp"].(string)
+		return found && azp == p.cfg.ClientID
+	}
+
+	return found
+}
+
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	metadata := &Metadata{}
+	if err := p.getJSON(ctx, p.cfg.DiscoveryURL, metadata); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+
+	if metadata.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q doesn't match the configured %q", metadata.Issuer, p.cfg.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" {
+		return nil, fmt.Errorf("oidc discovery: authorization or token endpoint is missing")
+	}
+
+	if p.cfg.JWKSURL != "" {
+		metadata.JWKSURI = p.cfg.JWKSURL
+	}
+
+	p.metadata = metadata
+	return metadata, nil
+}
+
+// key returns the public key with the given id, fetching the keys again
+// when the provider has rotated them.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	keySet := JSONWebKeySet{}
+	if err := p.getJSON(ctx, metadata.JWKSURI, &keySet); err != nil {
+		return nil, fmt.Errorf("oidc keys: %w", err)
+	}
+
+	p.keys = keySet.PublicKeys()
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookupKey finds a key by id. Tokens without a key id are accepted only
+// when the provider has a single key.
+func (p *Provider) lookupKey(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() string {
+	return RandomString(32)
+}
+
+// Challenge derives the S256 code challenge of a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// RandomString returns n random bytes encoded for use in URLs, for states
+// and nonces.
+func RandomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,171 @@
+// Package ratelimit implements token buckets. A bucket holds up to Burst
+// tokens and gets Limit tokens back every Period; every request takes one.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+type Policy struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// Rate returns the number of tokens added per second.
+func (p Policy) Rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// String formats the policy for the RateLimit-Policy header.
+func (p Policy) String() string {
+	return fmt.Sprintf("%d;w=%d;burst=%d", p.Limit, int(p.Period.Seconds()), p.Burst)
+}
+
+// Result describes a bucket after a request took a token from it.
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token when the request is denied.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps the buckets. A store shared by several instances makes them
+// enforce the limits together.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+// NewResult computes the result of a request from the tokens left in the bucket.
+func NewResult(policy Policy, tokens float64, allowed bool) Result {
+	result := Result{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(policy.Burst) - tokens) / policy.Rate()),
+	}
+
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / policy.Rate())
+	}
+
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Max(seconds, 0) * float64(time.Second))
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// MemoryStore keeps the buckets of a single instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(policy.Burst), updatedAt: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(policy.Burst), b.tokens+now.Sub(b.updatedAt).Seconds()*policy.Rate())
+	b.updatedAt = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	result := NewResult(policy, b.tokens, allowed)
+	b.fullAt = now.Add(result.Reset)
+
+	return result, nil
+}
+
+// sweep drops full buckets once a minute, they are the same as missing ones.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+
+	for key, b := range m.buckets {
+		if !now.Before(b.fullAt) {
+			delete(m.buckets, key)
+		}
+	}
+
+	m.lastSweep = now
+}
+
+type SharedStorage interface {
+	TakeRateLimitToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error)
+	DeleteFullRateLimits(ctx context.Context) (int, error)
+}
+
+// SharedStore keeps the buckets in the database, so that all instances
+// count against the same limits.
+type SharedStore struct {
+	store SharedStorage
+
+	mu        sync.Mutex
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func NewSharedStore(store SharedStorage) *SharedStore {
+	return &SharedStore{store: store, now: time.Now}
+}
+
+func (s *SharedStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	s.sweep(ctx)
+
+	tokens, allowed, err := s.store.TakeRateLimitToken(ctx, key, policy.Rate(), policy.Burst)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return NewResult(policy, tokens, allowed), nil
+}
+
+// sweep drops full buckets from the database once a minute, like
+// MemoryStore.sweep. A failure is only logged: the buckets are retried on
+// the next sweep and the request is still limited.
+func (s *SharedStore) sweep(ctx context.Context) {
+	s.mu.Lock()
+	now := s.now()
+	if now.Sub(s.lastSweep) < time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	if _, err := s.store.DeleteFullRateLimits(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to delete full rate limit buckets", "error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type sharedStorage struct {
+	tokens float64
+	sweeps int
+}
+
+func (s *sharedStorage) TakeRateLimitToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	return s.tokens, true, nil
+}
+
+func (s *sharedStorage) DeleteFullRateLimits(ctx context.Context) (int, error) {
+	s.sweeps++
+	return 0, nil
+}
+
+func TestSharedStoreSweepsOnceAMinute(t *testing.T) {
+	storage := &sharedStorage{tokens: 4}
+	store := NewSharedStore(storage)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	policy := Policy{Limit: 5, Period: time.Minute, Burst: 5}
+	steps := []struct {
+		after  time.Duration
+		sweeps int
+	}{
+		{0, 1},
+		{time.Second, 1},
+		{time.Second * 58, 1},
+		{time.Second, 2},
+		{time.Minute * 10, 3},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.after)
+		result, err := store.Take(context.Background(), "api:1.2.3.4", policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !result.Allowed || result.Remaining != 4 {
+			t.Errorf("result = %+v", result)
+		}
+
+		if storage.sweeps != step.sweeps {
+			t.Errorf("at %s: %d sweeps, want %d", now.Format(time.TimeOnly), storage.sweeps, step.sweeps)
+		}
+	}
+}
+
+func TestMemoryStoreDropsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	policy := Policy{Limit: 1, Period: time.Second, Burst: 10}
+	for i := 0; i < 3; i++ {
+		store.Take(context.Background(), "a", policy)
+	}
+
+	// b keeps being used, a refills after 3 seconds.
+	for i := 0; i < 4; i++ {
+		now = now.Add(time.Second * 30)
+		store.Take(context.Background(), "b", policy)
+	}
+
+	if _, ok := store.buckets["a"]; ok {
+		t.Error("the full bucket is kept")
+	}
+
+	if _, ok := store.buckets["b"]; !ok {
+		t.Error("the bucket in use is dropped")
+	}
+}
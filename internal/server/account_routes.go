@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/mail"
+)
+
+const (
+	verifyEmailTTL   = time.Hour * 48
+	resetPasswordTTL = time.Hour
+)
+
+type tokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+func (s *Server) handleVerifyEmail(c *gin.Context) {
+	request := tokenRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	userId, err := s.store.VerifyEmail(c.Request.Context(), hashToken(request.Token))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.verify_email", "user:"+strconv.Itoa(userId), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "email successfully verified"})
+}
+
+func (s *Server) handleResendVerification(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if user.Email == "" || user.EmailVerified {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "there is no unverified email"})
+		return
+	}
+
+	if err := s.sendVerificationEmail(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Message: "verification email sent"})
+}
+
+// handleForgotPassword answers the same way whether the email is known or
+// not, so that it can't be used to find out who has an account.
+func (s *Server) handleForgotPassword(c *gin.Context) {
+	request := forgotPasswordRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	response := models.Response{Message: "if the email belongs to an account, a reset link has been sent"}
+
+	user, err := s.store.GetUserByEmail(c.Request.Context(), request.Email)
+	if errors.Is(err, models.ErrUserNotFound) || (err == nil && user.Disabled) {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	token, hash := newSecretToken()
+	if err := s.store.AddUserToken(c.Request.Context(), user.Id, models.TokenResetPassword, hash, time.Now().Add(resetPasswordTTL)); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.sendMail(c.Request.Context(), mail.Mail{
+		To:      user.Email,
+		Subject: "Reset your go-market password",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your account. "+
+			"To choose a new password, send this token to POST /users/password/reset within %s:\n\n%s\n\n"+
+			"If it wasn't you, ignore this email.\n", user.Username, resetPasswordTTL, token),
+	})
+
+	s.audit(c, "user.password_forgot", "user:"+strconv.Itoa(user.Id), nil, nil)
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) handleResetPassword(c *gin.Context) {
+	request := resetPasswordRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	userId, err := s.store.ResetPassword(c.Request.Context(), hashToken(request.Token), request.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.password_reset", "user:"+strconv.Itoa(userId), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "password successfully reset"})
+}
+
+// sendVerificationEmail issues a new verification token for the email of user.
+func (s *Server) sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, hash := newSecretToken()
+	if err := s.store.AddUserToken(ctx, user.Id, models.TokenVerifyEmail, hash, time.Now().Add(verifyEmailTTL)); err != nil {
+		return err
+	}
+
+	s.sendMail(ctx, mail.Mail{
+		To:      user.Email,
+		Subject: "Confirm your email for go-market",
+		Body: fmt.Sprintf("Hi %s,\n\nto confirm your email, send this token to POST /users/verify within %s:\n\n%s\n",
+			user.Username, verifyEmailTTL, token),
+	})
+
+	return nil
+}
+
+// sendMail sends in the background, so that the response time doesn't
+// depend on the mail server. Failures are logged.
+func (s *Server) sendMail(ctx context.Context, message mail.Mail) {
+	ctx = context.WithoutCancel(ctx)
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+
+		if err := s.mailer.Send(ctx, message); err != nil {
+			slog.ErrorContext(ctx, "failed to send mail", "subject", message.Subject, "error", err)
+		}
+	}()
+}
+
+// newSecretToken returns a random token for the user and the hash that is
+// stored instead of it.
+func newSecretToken() (string, string) {
+	b := make([]byte, 32)
+	rand.Read(b)
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token)
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/mail"
+)
+
+var mailToken = regexp.MustCompile(`\n\n([A-Za-z0-9_-]{43})\n`)
+
+// newMailTestServer sends the mail of the server into a directory and
+// returns a function that waits for the mail and returns the last token
+// sent.
+func newMailTestServer(t *testing.T, store *fakeStorage) (*Server, func() string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	mailer, err := mail.NewFileMailer(dir, "go-market@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, store)
+	s.mailer = mailer
+
+	lastToken := func() string {
+		t.Helper()
+		s.background.Wait()
+
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) == 0 {
+			t.Fatalf("no mail sent: %v", err)
+		}
+
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		slices.Sort(names)
+
+		data, err := os.ReadFile(filepath.Join(dir, names[len(names)-1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		match := mailToken.FindSubmatch(data)
+		if match == nil {
+			t.Fatalf("no token in the mail:\n%s", data)
+		}
+
+		return string(match[1])
+	}
+
+	return s, lastToken
+}
+
+// checkStoredAsHash fails unless the storage has the hash of the token but
+// not the token itself.
+func checkStoredAsHash(t *testing.T, store *fakeStorage, token, purpose string, ttl time.Duration) {
+	t.Helper()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.userTokens[token]; ok {
+		t.Fatal("the token is stored in plain text")
+	}
+
+	stored, ok := store.userTokens[hashToken(token)]
+	if !ok || stored.purpose != purpose {
+		t.Fatalf("the hash of the token isn't stored as %s: %+v", purpose, store.userTokens)
+	}
+
+	if left := time.Until(stored.expiresAt); left <= ttl-time.Minute || left > ttl {
+		t.Errorf("token expires in %s, want %s", left, ttl)
+	}
+}
+
+func TestVerifyEmailToken(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "alice", Email: "alice@example.com"}
+	s, lastToken := newMailTestServer(t, store)
+	handler := s.routes()
+
+	w := do(t, handler, http.MethodPost, "/users/verify/resend", userToken(t, s, 1), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("resend: status = %d, body %s", w.Code, w.Body)
+	}
+
+	token := lastToken()
+	checkStoredAsHash(t, store, token, models.TokenVerifyEmail, verifyEmailTTL)
+
+	body := `{"token": "` + token + `"}`
+	if w := do(t, handler, http.MethodPost, "/users/verify", "", body); w.Code != http.StatusOK {
+		t.Fatalf("verify: status = %d, body %s", w.Code, w.Body)
+	}
+
+	if !store.users[1].EmailVerified {
+		t.Error("email isn't verified")
+	}
+
+	if w := do(t, handler, http.MethodPost, "/users/verify", "", body); w.Code != http.StatusBadRequest {
+		t.Errorf("second use: status = %d, want 400", w.Code)
+	}
+}
+
+func TestVerifyEmailTokenExpires(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "alice", Email: "alice@example.com"}
+	s, lastToken := newMailTestServer(t, store)
+	handler := s.routes()
+
+	do(t, handler, http.MethodPost, "/users/verify/resend", userToken(t, s, 1), "")
+	token := lastToken()
+
+	store.userTokens[hashToken(token)].expiresAt = time.Now().Add(-time.Second)
+
+	if w := do(t, handler, http.MethodPost, "/users/verify", "", `{"token": "`+token+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expired token: status = %d, want 400", w.Code)
+	}
+
+	if store.users[1].EmailVerified {
+		t.Error("email verified with an expired token")
+	}
+}
+
+func TestResetPasswordToken(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "alice", Email: "alice@example.com", EmailVerified: true}
+	s, lastToken := newMailTestServer(t, store)
+	handler := s.routes()
+
+	forgot := func() string {
+		t.Helper()
+
+		w := do(t, handler, http.MethodPost, "/users/password/forgot", "", `{"email": "Alice@example.com"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("forgot: status = %d, body %s", w.Code, w.Body)
+		}
+
+		return lastToken()
+	}
+
+	first := forgot()
+	checkStoredAsHash(t, store, first, models.TokenResetPassword, resetPasswordTTL)
+
+	// A new token replaces the one sent before.
+	second := forgot()
+	if w := do(t, handler, http.MethodPost, "/users/password/reset", "", `{"token": "`+first+`", "password": "new-password"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("replaced token: status = %d, want 400", w.Code)
+	}
+
+	tooLong := `{"token": "` + second + `", "password": "` + strings.Repeat("a", 73) + `"}`
+	if w := do(t, handler, http.MethodPost, "/users/password/reset", "", tooLong); w.Code != http.StatusBadRequest {
+		t.Errorf("73 byte password: status = %d, want 400", w.Code)
+	}
+
+	body := `{"token": "` + second + `", "password": "new-password"}`
+	if w := do(t, handler, http.MethodPost, "/users/password/reset", "", body); w.Code != http.StatusOK {
+		t.Fatalf("reset: status = %d, body %s", w.Code, w.Body)
+	}
+
+	if store.passwords[1] != "new-password" {
+		t.Errorf("password = %q", store.passwords[1])
+	}
+
+	body = `{"token": "` + second + `", "password": "other-password"}`
+	if w := do(t, handler, http.MethodPost, "/users/password/reset", "", body); w.Code != http.StatusBadRequest {
+		t.Errorf("second use: status = %d, want 400", w.Code)
+	}
+
+	third := forgot()
+	store.userTokens[hashToken(third)].expiresAt = time.Now().Add(-time.Second)
+
+	body = `{"token": "` + third + `", "password": "other-password"}`
+	if w := do(t, handler, http.MethodPost, "/users/password/reset", "", body); w.Code != http.StatusBadRequest {
+		t.Errorf("expired token: status = %d, want 400", w.Code)
+	}
+
+	if store.passwords[1] != "new-password" {
+		t.Errorf("password changed to %q", store.passwords[1])
+	}
+}
+
+func TestForgotPasswordIgnoresUnverifiedEmail(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "alice", Email: "alice@example.com"}
+	s, _ := newMailTestServer(t, store)
+
+	w := do(t, s.routes(), http.MethodPost, "/users/password/forgot", "", `{"email": "alice@example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	s.background.Wait()
+
+	if len(store.userTokens) != 0 {
+		t.Errorf("a reset token was issued for an unverified email: %+v", store.userTokens)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/mail"
+)
+
+// adminPasswordResetTTL is longer than the self-service one, the user
+// doesn't expect the email.
+const adminPasswordResetTTL = time.Hour * 24
+
+type setRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=user admin"`
+}
+
+// handleGetUsers lists users page by page. The number of all matching users
+// is sent in the X-Total-Count header.
+func (s *Server) handleGetUsers(c *gin.Context) {
+	filter := models.UserFilter{}
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&filter); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	users, total, err := s.store.GetUsers(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	responses := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		responses = append(responses, newUserResponse(user))
+	}
+
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.JSON(http.StatusOK, responses)
+}
+
+func (s *Server) handleDisableUser(c *gin.Context) {
+	s.setUserDisabled(c, true)
+}
+
+func (s *Server) handleEnableUser(c *gin.Context) {
+	s.setUserDisabled(c, false)
+}
+
+func (s *Server) setUserDisabled(c *gin.Context, disabled bool) {
+	user, ok := s.managedUser(c)
+	if !ok {
+		return
+	}
+
+	if err := s.store.SetUserDisabled(c.Request.Context(), user.Id, disabled); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	action := "user.enable"
+	if disabled {
+		action = "user.disable"
+	}
+	s.audit(c, action, "user:"+strconv.Itoa(user.Id), gin.H{"disabled": user.Disabled}, gin.H{"disabled": disabled})
+
+	user.Disabled = disabled
+	c.JSON(http.StatusOK, newUserResponse(user))
+}
+
+// handleLogoutUser revokes every token issued to the user so far.
+func (s *Server) handleLogoutUser(c *gin.Context) {
+	user, ok := s.managedUser(c)
+	if !ok {
+		return
+	}
+
+	if err := s.store.RevokeUserTokens(c.Request.Context(), user.Id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.logout", "user:"+strconv.Itoa(user.Id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "user successfully logged out"})
+}
+
+// handleForcePasswordReset removes the password of the user, logs them out
+// and emails a reset token.
+func (s *Server) handleForcePasswordReset(c *gin.Context) {
+	user, ok := s.managedUser(c)
+	if !ok {
+		return
+	}
+
+	if user.Email == "" {
+		c.JSON(http.StatusConflict, models.Response{Message: "user has no email to send the reset token to"})
+		return
+	}
+
+	if err := s.store.ForcePasswordReset(c.Request.Context(), user.Id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	token, hash := newSecretToken()
+	if err := s.store.AddUserToken(c.Request.Context(), user.Id, models.TokenResetPassword, hash, time.Now().Add(adminPasswordResetTTL)); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.sendMail(c.Request.Context(), mail.Mail{
+		To:      user.Email,
+		Subject: "Your go-market password has been reset",
+		Body: fmt.Sprintf("Hi %s,\n\nan administrator has reset the password of your account. "+
+			"To choose a new password, send this token to POST /users/password/reset within %s:\n\n%s\n",
+			user.Username, adminPasswordResetTTL, token),
+	})
+
+	s.audit(c, "user.password_force_reset", "user:"+strconv.Itoa(user.Id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "password reset, the user has been emailed a reset token"})
+}
+
+// handleSetUserRole changes the role of a user. Tokens carry the role, so
+// the user is logged out to make the change take effect.
+func (s *Server) handleSetUserRole(c *gin.Context) {
+	request := setRoleRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	user, ok := s.managedUser(c)
+	if !ok {
+		return
+	}
+
+	if err := s.store.SetUserRole(c.Request.Context(), user.Id, request.Role); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.RevokeUserTokens(c.Request.Context(), user.Id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.set_role", "user:"+strconv.Itoa(user.Id), gin.H{"role": user.Role}, gin.H{"role": request.Role})
+
+	user.Role = request.Role
+	c.JSON(http.StatusOK, newUserResponse(user))
+}
+
+func (s *Server) handleGetPurchaseSummary(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	summary, err := s.store.GetPurchaseSummary(c.Request.Context(), user.Id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// targetUser loads the user of the :id parameter, or responds with an error.
+func (s *Server) targetUser(c *gin.Context) (models.User, bool) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return models.User{}, false
+	}
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return models.User{}, false
+	}
+
+	if user.Id == 0 {
+		c.JSON(http.StatusNotFound, models.Response{Message: models.ErrUserNotFound.Error()})
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+// managedUser is targetUser for changes. Admins can't change their own
+// account this way, so that nobody locks themselves out by accident.
+func (s *Server) managedUser(c *gin.Context) (models.User, bool) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return models.User{}, false
+	}
+
+	if user.Id == c.GetInt("id") {
+		c.JSON(http.StatusForbidden, models.Response{Message: "admins can't change their own account here"})
+		return models.User{}, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// TestLoginAdminFromRole checks that only the role makes an admin, whatever
+// the username and password.
+func TestLoginAdminFromRole(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleUser}
+	store.users[2] = models.User{Id: 2, Username: "root", Role: models.RoleAdmin}
+	store.passwords[1] = "admin"
+	store.passwords[2] = "root-password"
+	s := newTestServer(t, store)
+	handler := s.routes()
+
+	tests := []struct {
+		body  string
+		admin bool
+	}{
+		{`{"username": "admin", "password": "admin"}`, false},
+		{`{"username": "root", "password": "root-password"}`, true},
+	}
+
+	for _, test := range tests {
+		w := do(t, handler, http.MethodPost, "/users/login", "", test.body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", test.body, w.Code, w.Body)
+		}
+
+		response := models.LoginResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+
+		if w := do(t, handler, http.MethodGet, "/admin/users", response.Message, ""); (w.Code == http.StatusOK) != test.admin {
+			t.Errorf("%s: GET /admin/users status = %d, admin = %v", test.body, w.Code, test.admin)
+		}
+	}
+}
+
+func TestAdminUsersHaveStatus(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	deletedAt := createdAt.Add(time.Hour)
+
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin, CreatedAt: createdAt}
+	store.users[2] = models.User{Id: 2, Username: "alice", Role: models.RoleUser, Disabled: true, CreatedAt: createdAt}
+	store.users[3] = models.User{Id: 3, Username: "bob", Role: models.RoleUser, Disabled: true, DeletedAt: &deletedAt, CreatedAt: createdAt}
+	s := newTestServer(t, store)
+
+	w := do(t, s.routes(), http.MethodGet, "/admin/users", adminToken(t, s, 1), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	users := []struct {
+		Id        int       `json:"id"`
+		Status    string    `json:"status"`
+		CreatedAt time.Time `json:"createdAt"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int]string{1: models.UserActive, 2: models.UserDisabled, 3: models.UserDeleted}
+	if len(users) != len(want) {
+		t.Fatalf("users = %s", w.Body)
+	}
+
+	for _, user := range users {
+		if user.Status != want[user.Id] || !user.CreatedAt.Equal(createdAt) {
+			t.Errorf("user %d: status = %q, createdAt = %s", user.Id, user.Status, user.CreatedAt)
+		}
+	}
+}
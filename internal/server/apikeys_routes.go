@@ -0,0 +1,229 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/logging"
+)
+
+// API keys look like gm_<prefix>_<secret>. The prefix is stored as is to
+// find the key, the whole key only as a hash.
+const apiKeyPrefix = "gm_"
+
+// apiKeyScopes lists the routes that accept API keys and the scope each one
+// needs. API keys are rejected everywhere else.
+var apiKeyScopes = map[string]string{
+	"GET /products/list":                         models.ScopeCatalogRead,
+	"GET /products/:id":                          models.ScopeCatalogRead,
+	"GET /products/:id/images":                   models.ScopeCatalogRead,
+	"GET /products/:id/images/:imageId":          models.ScopeCatalogRead,
+	"GET /products/:id/reviews":                  models.ScopeCatalogRead,
+	"GET /admin/products/export":                 models.ScopeCatalogRead,
+	"GET /admin/products/deleted":                models.ScopeCatalogRead,
+	"POST /products/":                            models.ScopeCatalogWrite,
+	"PUT /products/:id":                          models.ScopeCatalogWrite,
+	"PATCH /products/:id":                        models.ScopeCatalogWrite,
+	"DELETE /products/:id":                       models.ScopeCatalogWrite,
+	"POST /products/:id/images":                  models.ScopeCatalogWrite,
+	"DELETE /products/:id/images/:imageId":       models.ScopeCatalogWrite,
+	"PUT /products/:id/images/order":             models.ScopeCatalogWrite,
+	"POST /products/:id/images/:imageId/primary": models.ScopeCatalogWrite,
+	"POST /admin/products/import":                models.ScopeCatalogWrite,
+	"POST /admin/products/:id/restore":           models.ScopeCatalogWrite,
+	"GET /purchases/list/:id":                    models.ScopePurchasesRead,
+}
+
+type apiKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=255"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=catalog:read catalog:write purchases:read"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func (s *Server) handleAddAPIKey(c *gin.Context) {
+	request := apiKeyRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "expiresAt must be in the future"})
+		return
+	}
+
+	secret, prefix := newAPIKey()
+	key, err := s.store.AddAPIKey(c.Request.Context(), models.APIKey{
+		Name:      request.Name,
+		Prefix:    prefix,
+		KeyHash:   hashToken(secret),
+		Scopes:    request.Scopes,
+		CreatedBy: c.GetInt("id"),
+		ExpiresAt: request.ExpiresAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "api_key.create", "api_key:"+strconv.Itoa(key.Id), nil, newAPIKeyResponse(key))
+
+	// The key is only shown once, it can't be recovered from the hash.
+	response := newAPIKeyResponse(key)
+	response.Key = secret
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) handleGetAPIKeys(c *gin.Context) {
+	keys, err := s.store.GetAPIKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	responses := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		responses = append(responses, newAPIKeyResponse(key))
+	}
+
+	c.JSON(http.StatusOK, responses)
+}
+
+func (s *Server) handleRevokeAPIKey(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	err = s.store.RevokeAPIKey(c.Request.Context(), id)
+	if errors.Is(err, models.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "api_key.revoke", "api_key:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "api key successfully revoked"})
+}
+
+// apiKeyFromHeader returns the API key of the request, sent in Authorization
+// either as is or after "ApiKey ".
+func apiKeyFromHeader(c *gin.Context) (string, bool) {
+	header := strings.TrimPrefix(c.GetHeader("Authorization"), "ApiKey ")
+	return header, strings.HasPrefix(header, apiKeyPrefix)
+}
+
+// authenticateAPIKey checks the API key of the request and the scope of the
+// route, and aborts the request if either doesn't allow it. A request is
+// checked once even if it passes several auth middlewares.
+func (s *Server) authenticateAPIKey(c *gin.Context, secret string) bool {
+	if _, ok := c.Get("apiKeyId"); ok {
+		return true
+	}
+
+	key, err := s.apiKey(c, secret)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.Response{Message: err.Error()})
+		c.Abort()
+		return false
+	}
+
+	scope, ok := apiKeyScopes[c.Request.Method+" "+c.FullPath()]
+	if !ok || !hasScope(key.Scopes, scope) {
+		c.JSON(http.StatusForbidden, models.Response{Message: "api key is not allowed to access this resource"})
+		c.Abort()
+		return false
+	}
+
+	// The key acts for the admin who issued it, so it stops working when
+	// they are disabled, deleted or no longer an admin.
+	creator, err := s.store.GetUserProfile(c.Request.Context(), key.CreatedBy)
+	if err != nil || creator.Status() != models.UserActive || creator.Role != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, models.Response{Message: "api key is not allowed to access this resource"})
+		c.Abort()
+		return false
+	}
+
+	if err := s.store.TouchAPIKey(c.Request.Context(), key.Id); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to record api key use", "apiKeyId", key.Id, "error", err)
+	}
+
+	// Changes made with the key are attributed to the admin who issued it.
+	c.Set("id", key.CreatedBy)
+	c.Set("apiKeyId", key.Id)
+	c.Request = c.Request.WithContext(logging.WithUserId(c.Request.Context(), key.CreatedBy))
+	return true
+}
+
+// apiKey returns the valid key for secret. The key is looked up once per
+// request, both the rate limiter and the auth middlewares need it.
+func (s *Server) apiKey(c *gin.Context, secret string) (models.APIKey, error) {
+	if key, ok := c.Get("apiKey"); ok {
+		return key.(models.APIKey), nil
+	}
+
+	errInvalid := errors.New("api key is invalid, expired or revoked")
+
+	parts := strings.SplitN(strings.TrimPrefix(secret, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return models.APIKey{}, errInvalid
+	}
+
+	key, err := s.store.GetAPIKeyByPrefix(c.Request.Context(), parts[0])
+	if err != nil {
+		return models.APIKey{}, errInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(key.KeyHash)) != 1 {
+		return models.APIKey{}, errInvalid
+	}
+
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now())) {
+		return models.APIKey{}, errInvalid
+	}
+
+	c.Set("apiKey", key)
+	return key, nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newAPIKey returns a new key and its prefix.
+func newAPIKey() (string, string) {
+	b := make([]byte, 5)
+	rand.Read(b)
+	prefix := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+
+	b = make([]byte, 32)
+	rand.Read(b)
+
+	return apiKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(b), prefix
+}
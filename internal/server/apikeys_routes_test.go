@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// TestAPIKeyFollowsCreator checks that a key stops working once the admin
+// who issued it can't act as an admin anymore.
+func TestAPIKeyFollowsCreator(t *testing.T) {
+	deletedAt := time.Now()
+
+	tests := []struct {
+		name    string
+		creator models.User
+		want    int
+	}{
+		{"admin", models.User{Role: models.RoleAdmin}, http.StatusOK},
+		{"demoted", models.User{Role: models.RoleUser}, http.StatusForbidden},
+		{"disabled", models.User{Role: models.RoleAdmin, Disabled: true}, http.StatusForbidden},
+		{"deleted", models.User{Role: models.RoleAdmin, DeletedAt: &deletedAt}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newFakeStorage()
+			store.users[1] = models.User{Id: 1, Username: "erp-owner", Role: test.creator.Role, Disabled: test.creator.Disabled, DeletedAt: test.creator.DeletedAt}
+
+			secret, prefix := newAPIKey()
+			store.apiKeys = []models.APIKey{{Id: 1, Prefix: prefix, KeyHash: hashToken(secret), Scopes: []string{models.ScopeCatalogRead}, CreatedBy: 1}}
+
+			s := newTestServer(t, store)
+			if w := do(t, s.routes(), http.MethodGet, "/products/list", secret, ""); w.Code != test.want {
+				t.Errorf("status = %d, want %d, body %s", w.Code, test.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/logging"
+)
+
+func (s *Server) handleGetAuditEntries(c *gin.Context) {
+	filter := models.AuditFilter{}
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&filter); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	entries, err := s.store.GetAuditEntries(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+func (s *Server) handleVerifyAuditChain(c *gin.Context) {
+	brokenId, err := s.store.VerifyAuditChain(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if brokenId != 0 {
+		c.JSON(http.StatusConflict, gin.H{"message": "audit chain is broken", "brokenId": brokenId})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Message: "audit chain is intact"})
+}
+
+// audit records an action performed by the current user. before and after
+// may be nil; only the fields that differ between them are stored.
+func (s *Server) audit(c *gin.Context, action, target string, before, after any) {
+	diff, err := json.Marshal(auditDiff(before, after))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "audit: failed to encode diff", "action", action, "error", err)
+		return
+	}
+
+	entry := models.AuditEntry{
+		ActorId:   c.GetInt("id"),
+		Action:    action,
+		Target:    target,
+		Diff:      diff,
+		Ip:        c.ClientIP(),
+		RequestId: logging.RequestId(c.Request.Context()),
+	}
+
+	if err := s.store.AddAuditEntry(c.Request.Context(), entry); err != nil {
+		slog.ErrorContext(c.Request.Context(), "audit: failed to record entry", "action", action, "error", err)
+	}
+}
+
+var auditRedactedFields = map[string]bool{"password": true, "secret": true}
+
+func auditDiff(before, after any) map[string]map[string]any {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	diff := map[string]map[string]any{}
+	for _, fields := range []map[string]any{beforeFields, afterFields} {
+		for key := range fields {
+			if _, ok := diff[key]; ok || reflect.DeepEqual(beforeFields[key], afterFields[key]) {
+				continue
+			}
+
+			change := map[string]any{"before": beforeFields[key], "after": afterFields[key]}
+			if auditRedactedFields[key] {
+				change = map[string]any{"changed": true}
+			}
+
+			diff[key] = change
+		}
+	}
+
+	return diff
+}
+
+func auditFields(value any) map[string]any {
+	fields := map[string]any{}
+	if value == nil {
+		return fields
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+
+	json.Unmarshal(data, &fields)
+	return fields
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuditDiffRedactsSecrets(t *testing.T) {
+	type account struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Secret   string `json:"secret"`
+		Role     string `json:"role"`
+	}
+
+	before := account{Username: "alice", Password: "old-password", Secret: "old-secret", Role: "user"}
+	after := account{Username: "alice", Password: "new-password", Secret: "new-secret", Role: "admin"}
+
+	want := map[string]map[string]any{
+		"password": {"changed": true},
+		"secret":   {"changed": true},
+		"role":     {"before": "user", "after": "admin"},
+	}
+
+	diff := auditDiff(before, after)
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff = %v, want %v", diff, want)
+	}
+
+	// Created and deleted records are redacted the same way.
+	for name, diff := range map[string]map[string]map[string]any{"created": auditDiff(nil, after), "deleted": auditDiff(before, nil)} {
+		data, err := json.Marshal(diff)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, secret := range []string{"old-password", "new-password", "old-secret", "new-secret"} {
+			if strings.Contains(string(data), secret) {
+				t.Errorf("%s: diff %s contains %q", name, data, secret)
+			}
+		}
+
+		if diff["password"]["changed"] != true || diff["secret"]["changed"] != true {
+			t.Errorf("%s: diff = %v", name, diff)
+		}
+	}
+}
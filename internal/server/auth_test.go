@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// TestTokenRevocation checks that a logout revokes the tokens issued in the
+// same second, which have the same iat as the logout.
+func TestTokenRevocation(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "alice"}
+	store.products[1] = models.Product{Id: 1, Name: "Kettle", Price: 100, Version: 1}
+	s := newTestServer(t, store)
+	handler := s.routes()
+
+	// In the past, so that no token is issued in the future.
+	loggedOutAt := time.Now().Truncate(time.Second).Add(-time.Minute)
+	store.validAfter[1] = loggedOutAt
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		want     int
+	}{
+		{"before the logout", loggedOutAt.Add(-time.Second), http.StatusUnauthorized},
+		{"in the second of the logout", loggedOutAt, http.StatusUnauthorized},
+		{"after the logout", loggedOutAt.Add(time.Second), http.StatusOK},
+	}
+
+	for _, test := range tests {
+		token, err := s.keys.Sign(jwt.MapClaims{
+			"id":  1,
+			"iat": test.issuedAt.Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if w := do(t, handler, http.MethodGet, "/products/1", token, ""); w.Code != test.want {
+			t.Errorf("token issued %s: status = %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// secretFields are the names, compared without case and underscores, that
+// no JSON response may contain.
+var secretFields = map[string]bool{
+	"password": true,
+	"hash":     true,
+	"secret":   true,
+	"totp":     true,
+	"keyhash":  true,
+}
+
+// secretValues are stored by newContractStorage and must never be sent.
+var secretValues = []string{
+	"$2a$10$storedpasswordhashstoredpasswordhashstoredpasswo",
+	"storedkeyhashstoredkeyhashstoredkeyhashstoredkeyha",
+	"webhook-signing-secret",
+}
+
+func newContractStorage() *fakeStorage {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Email: "admin@example.com", Role: models.RoleAdmin, EmailVerified: true}
+	store.users[2] = models.User{Id: 2, Username: "alice", Email: "alice@example.com", Role: models.RoleUser, TOTPEnabled: true}
+	store.passwords[1] = secretValues[0]
+	store.passwords[2] = secretValues[0]
+
+	deletedAt := time.Now()
+	store.products[1] = models.Product{Id: 1, Sku: "KETTLE", Name: "Kettle", Price: 100, Quantity: 3, Version: 1}
+	store.products[2] = models.Product{Id: 2, Sku: "MUG", Name: "Mug", Price: 10, Version: 2, DeletedAt: &deletedAt}
+	store.purchases = []models.Purchase{{Id: 1, UserId: 2, ProductId: 1, Quantity: 2, Timestamp: "2024-01-01T00:00:00Z"}}
+	store.subscribers[1] = []int{2}
+	store.identities = []models.ExternalIdentity{{Id: 1, UserId: 2, Issuer: "https://idp.example.com", Subject: "alice", Email: "alice@example.com"}}
+	store.apiKeys = []models.APIKey{{Id: 1, Name: "erp", Prefix: "abcdefgh", KeyHash: secretValues[1], Scopes: []string{models.ScopeCatalogRead}, CreatedBy: 1}}
+	store.webhooks = []models.Webhook{{Id: 1, Url: "https://example.com/hook", Secret: secretValues[2], Events: []string{models.EventPurchaseCreated}, Active: true}}
+	store.audit = []models.AuditEntry{{Id: 1, ActorId: 2, Action: "user.login", Target: "user:alice", Diff: json.RawMessage(`{}`), PrevHash: "0", Hash: "abc"}}
+
+	return store
+}
+
+// TestResponsesHaveNoSecrets calls the user, product, purchase, admin and
+// export endpoints and fails if a response carries a secret, by name or by
+// value.
+func TestResponsesHaveNoSecrets(t *testing.T) {
+	store := newContractStorage()
+	s := newTestServer(t, store)
+	handler := s.routes()
+
+	user, admin := userToken(t, s, 2), adminToken(t, s, 1)
+
+	requests := []struct {
+		method, target, token, body string
+	}{
+		{http.MethodGet, "/users/profile", user, ""},
+		{http.MethodGet, "/users/2", admin, ""},
+		{http.MethodGet, "/users/profile/export", user, ""},
+		{http.MethodGet, "/users/wishlist", user, ""},
+		{http.MethodGet, "/users/notifications", user, ""},
+		{http.MethodGet, "/products/list", user, ""},
+		{http.MethodGet, "/products/1", user, ""},
+		{http.MethodGet, "/purchases/list", user, ""},
+		{http.MethodGet, "/purchases/list/1", admin, ""},
+		{http.MethodGet, "/admin/users", admin, ""},
+		{http.MethodGet, "/admin/users/2/purchases", admin, ""},
+		{http.MethodPost, "/admin/api-keys", admin, `{"name": "shop", "scopes": ["catalog:read"]}`},
+		{http.MethodGet, "/admin/api-keys", admin, ""},
+		{http.MethodPost, "/admin/webhooks", admin, `{"url": "https://example.com/other", "secret": "webhook-signing-secret", "events": ["purchase.created"]}`},
+		{http.MethodGet, "/admin/webhooks/list", admin, ""},
+		{http.MethodGet, "/admin/webhooks/1", admin, ""},
+		{http.MethodGet, "/admin/products/deleted", admin, ""},
+		{http.MethodGet, "/admin/products/export?format=jsonl", admin, ""},
+	}
+
+	for _, r := range requests {
+		w := do(t, handler, r.method, r.target, r.token, r.body)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s: status = %d, body %s", r.method, r.target, w.Code, w.Body)
+			continue
+		}
+
+		checkNoSecrets(t, r.method+" "+r.target, w.Body.String(), true)
+	}
+
+	// The audit log is signed with a hash chain that admins verify, so only
+	// the values are checked there: the diffs must not repeat secrets.
+	w := do(t, handler, http.MethodGet, "/admin/audit", admin, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/audit: status = %d, body %s", w.Code, w.Body)
+	}
+
+	checkNoSecrets(t, "GET /admin/audit", w.Body.String(), false)
+}
+
+// checkNoSecrets checks every JSON document of body, which is either one
+// document or JSON lines.
+func checkNoSecrets(t *testing.T, request, body string, checkFields bool) {
+	t.Helper()
+
+	for _, value := range secretValues {
+		if strings.Contains(body, value) {
+			t.Errorf("%s: response contains the secret %q", request, value)
+		}
+	}
+
+	if !checkFields {
+		return
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var document any
+		if err := json.Unmarshal(scanner.Bytes(), &document); err != nil {
+			t.Errorf("%s: response is not JSON: %v", request, err)
+			return
+		}
+
+		for _, field := range fieldNames(document) {
+			if secretFields[strings.ToLower(strings.ReplaceAll(field, "_", ""))] {
+				t.Errorf("%s: response has the field %q", request, field)
+			}
+		}
+	}
+}
+
+// fieldNames returns the names of all object fields in a decoded JSON value.
+func fieldNames(value any) []string {
+	names := []string{}
+	switch value := value.(type) {
+	case map[string]any:
+		for name, field := range value {
+			names = append(names, name)
+			names = append(names, fieldNames(field)...)
+		}
+	case []any:
+		for _, item := range value {
+			names = append(names, fieldNames(item)...)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// Requests and responses of the API. They are kept apart from the storage
+// entities in models, so that a new column never reaches a client or becomes
+// writable by accident: every field that crosses the API is listed here.
+
+type registerRequest struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+	Email    string `json:"email" validate:"omitempty,email"`
+}
+
+type loginRequest struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type userResponse struct {
+	Id            int       `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	Status        string    `json:"status"`
+	Disabled      bool      `json:"disabled"`
+	EmailVerified bool      `json:"emailVerified"`
+	TOTPEnabled   bool      `json:"totpEnabled"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func newUserResponse(user models.User) userResponse {
+	return userResponse{
+		Id:            user.Id,
+		Username:      user.Username,
+		Email:         user.Email,
+		Role:          user.Role,
+		Status:        user.Status(),
+		Disabled:      user.Disabled,
+		EmailVerified: user.EmailVerified,
+		TOTPEnabled:   user.TOTPEnabled,
+		CreatedAt:     user.CreatedAt,
+	}
+}
+
+// productRequest holds the fields of a product that admins write.
+type productRequest struct {
+	Sku         string `json:"sku" validate:"max=64"`
+	Name        string `json:"name" validate:"required,max=255"`
+	Description string `json:"description" validate:"max=2000"`
+	Price       int    `json:"price" validate:"gt=0"`
+	Quantity    int    `json:"quantity" validate:"gte=0"`
+}
+
+func newProductRequest(product models.Product) productRequest {
+	return productRequest{
+		Sku:         product.Sku,
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       product.Price,
+		Quantity:    product.Quantity,
+	}
+}
+
+// apply returns product with the fields of the request.
+func (r productRequest) apply(product models.Product) models.Product {
+	product.Sku = r.Sku
+	product.Name = r.Name
+	product.Description = r.Description
+	product.Price = r.Price
+	product.Quantity = r.Quantity
+	return product
+}
+
+type productResponse struct {
+	Id          int        `json:"id"`
+	Sku         string     `json:"sku"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Price       int        `json:"price"`
+	Quantity    int        `json:"quantity"`
+	Version     int        `json:"version"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+	Rating      float64    `json:"rating"`
+	ReviewCount int        `json:"reviewCount"`
+}
+
+func newProductResponse(product models.Product) productResponse {
+	return productResponse{
+		Id:          product.Id,
+		Sku:         product.Sku,
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       product.Price,
+		Quantity:    product.Quantity,
+		Version:     product.Version,
+		DeletedAt:   product.DeletedAt,
+		Rating:      product.Rating,
+		ReviewCount: product.ReviewCount,
+	}
+}
+
+func newProductResponses(products []models.Product) []productResponse {
+	responses := make([]productResponse, 0, len(products))
+	for _, product := range products {
+		responses = append(responses, newProductResponse(product))
+	}
+
+	return responses
+}
+
+type purchaseResponse struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"userId"`
+	ProductId int    `json:"productId"`
+	Quantity  int    `json:"quantity"`
+	Timestamp string `json:"timestamp"`
+}
+
+func newPurchaseResponses(purchases []models.Purchase) []purchaseResponse {
+	responses := make([]purchaseResponse, 0, len(purchases))
+	for _, purchase := range purchases {
+		responses = append(responses, purchaseResponse{
+			Id:        purchase.Id,
+			UserId:    purchase.UserId,
+			ProductId: purchase.ProductId,
+			Quantity:  purchase.Quantity,
+			Timestamp: purchase.Timestamp,
+		})
+	}
+
+	return responses
+}
+
+type apiKeyResponse struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+func newAPIKeyResponse(key models.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		Id:         key.Id,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedBy:  key.CreatedBy,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+// webhookResponse leaves out the secret: it is only written, never read back.
+type webhookResponse struct {
+	Id     int      `json:"id"`
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	Active bool     `json:"active"`
+}
+
+func newWebhookResponse(webhook models.Webhook) webhookResponse {
+	return webhookResponse{
+		Id:     webhook.Id,
+		Url:    webhook.Url,
+		Events: webhook.Events,
+		Active: webhook.Active,
+	}
+}
+
+// activityResponse is an audit entry of the user's own actions, without the
+// hash chain that only matters to admins verifying the log.
+type activityResponse struct {
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Diff      json.RawMessage `json:"diff"`
+	Ip        string          `json:"ip"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+func newActivityResponses(entries []models.AuditEntry) []activityResponse {
+	responses := make([]activityResponse, 0, len(entries))
+	for _, entry := range entries {
+		responses = append(responses, activityResponse{
+			Action:    entry.Action,
+			Target:    entry.Target,
+			Diff:      entry.Diff,
+			Ip:        entry.Ip,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+
+	return responses
+}
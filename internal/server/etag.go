@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// productETag changes with the version of the product and with its rating,
+// which is part of the representation but not of the version. The version
+// comes first, If-Match only compares that part.
+func productETag(product models.Product) string {
+	return fmt.Sprintf(`"%d-%d-%g"`, product.Version, product.ReviewCount, product.Rating)
+}
+
+// etagMatches reports whether header, a comma separated list of entity tags
+// or "*", contains etag. This is the weak comparison of If-None-Match: weak
+// tags are compared by their opaque part.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// versionMatches reports whether header, the If-Match list of entity tags or
+// "*", names version. This is the strong comparison of RFC 7232, so weak tags
+// never match. Only the version part of a tag is compared: new reviews change
+// the ETag but not the product that is being updated.
+func versionMatches(header string, version int) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if len(candidate) < 2 || !strings.HasPrefix(candidate, `"`) || !strings.HasSuffix(candidate, `"`) {
+			continue
+		}
+
+		tagVersion, _, _ := strings.Cut(candidate[1:len(candidate)-1], "-")
+		if tagVersion == strconv.Itoa(version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfMatch requires an If-Match header that matches the current version
+// of product. On failure the response is written and false is returned.
+func checkIfMatch(c *gin.Context, product models.Product) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		c.JSON(http.StatusPreconditionRequired, models.Response{Message: "If-Match header is required"})
+		return false
+	}
+
+	if !versionMatches(header, product.Version) {
+		c.Header("ETag", productETag(product))
+		c.JSON(http.StatusPreconditionFailed, models.Response{Message: models.ErrVersionConflict.Error()})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func TestVersionMatches(t *testing.T) {
+	reviewed := productETag(models.Product{Version: 3, ReviewCount: 2, Rating: 4.5})
+
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: `*`, want: true},
+		{header: `"3-0-0"`, want: true},
+		{header: reviewed, want: true},
+		{header: `"3"`, want: true},
+		{header: `"1-0-0", "3-1-5"`, want: true},
+		{header: `"2-2-4.5"`, want: false},
+		{header: `"33-0-0"`, want: false},
+		{header: `W/"3-0-0"`, want: false},
+		{header: `3-0-0`, want: false},
+	}
+
+	for _, test := range tests {
+		if got := versionMatches(test.header, 3); got != test.want {
+			t.Errorf("versionMatches(%s, 3) = %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	etag := productETag(models.Product{Version: 3, ReviewCount: 2, Rating: 4.5})
+
+	for _, header := range []string{etag, "W/" + etag, `"1-0-0", ` + etag, "*"} {
+		if !etagMatches(header, etag) {
+			t.Errorf("etagMatches(%s) = false", header)
+		}
+	}
+
+	if etagMatches(`"3-0-0"`, etag) {
+		t.Errorf("a tag from before the reviews matches")
+	}
+}
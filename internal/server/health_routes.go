@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func (s *Server) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, models.Response{Message: "ok"})
+}
+
+func (s *Server) handleReadyz(c *gin.Context) {
+	if s.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, models.Response{Message: "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Second*2)
+	defer cancel()
+
+	if err := s.store.Ready(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Message: "ready"})
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func TestReadyzFailsWhileDraining(t *testing.T) {
+	s := newTestServer(t, newFakeStorage())
+	s.addr = freeAddr(t)
+	s.drainDelay = time.Millisecond * 300
+	s.shutdownTimeout = time.Second
+
+	readyz := func() int {
+		resp, err := http.Get("http://" + s.addr + "/readyz")
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	waitFor := func(status int) {
+		t.Helper()
+
+		for deadline := time.Now().Add(time.Second * 2); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+			if readyz() == status {
+				return
+			}
+		}
+
+		t.Fatalf("/readyz never returned %d", status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	waitFor(http.StatusOK)
+
+	stopped := time.Now()
+	cancel()
+	waitFor(http.StatusServiceUnavailable)
+
+	select {
+	case err := <-done:
+		t.Fatalf("server stopped while /readyz still had to fail: %v", err)
+	default:
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(stopped); elapsed < s.drainDelay {
+		t.Errorf("server stopped %s after the signal, want at least the drain delay of %s", elapsed, s.drainDelay)
+	}
+
+	if status := readyz(); status != 0 {
+		t.Errorf("/readyz = %d after shutdown, want the connection refused", status)
+	}
+}
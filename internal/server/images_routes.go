@@ -0,0 +1,294 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/media"
+)
+
+const maxImageSize = 10 << 20
+
+func (s *Server) handleUploadProductImage(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if _, err := s.store.GetProductById(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	// The limit leaves room for the multipart framing around the file.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageSize+1<<20)
+
+	header, err := c.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "multipart field image is required: " + err.Error()})
+		return
+	}
+
+	if header.Size > maxImageSize {
+		c.JSON(http.StatusRequestEntityTooLarge, models.Response{Message: fmt.Sprintf("image must not exceed %d bytes", maxImageSize)})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	processed, err := media.Process(data)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		c.JSON(http.StatusUnsupportedMediaType, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	image := models.ProductImage{
+		ProductId:     id,
+		Key:           newImageKey(id),
+		ContentType:   processed.ContentType,
+		ThumbnailType: processed.ThumbnailType,
+		Width:         processed.Width,
+		Height:        processed.Height,
+		Size:          int64(len(data)),
+	}
+
+	if err := s.putImageBlobs(c.Request.Context(), image, data, processed.Thumbnails); err != nil {
+		media.DeleteImageBlobs(c.Request.Context(), s.blobs, image.Key)
+		c.JSON(http.StatusInternalServerError, models.Response{Message: err.Error()})
+		return
+	}
+
+	saved, err := s.store.AddProductImage(c.Request.Context(), image)
+	if err != nil {
+		media.DeleteImageBlobs(c.Request.Context(), s.blobs, image.Key)
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	image = saved
+
+	s.audit(c, "product.image_upload", "product:"+strconv.Itoa(id), nil, image)
+
+	image.Urls = imageUrls(image)
+	c.JSON(http.StatusOK, image)
+}
+
+func (s *Server) handleGetProductImages(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	images, err := s.store.GetProductImages(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	for i := range images {
+		images[i].Urls = imageUrls(images[i])
+	}
+
+	c.JSON(http.StatusOK, images)
+}
+
+// handleGetProductImage streams the original image, or the thumbnail selected
+// with ?size=150.
+func (s *Server) handleGetProductImage(c *gin.Context) {
+	image, ok := s.productImage(c)
+	if !ok {
+		return
+	}
+
+	key, contentType := blobKey(image, "original"), image.ContentType
+	if size := c.Query("size"); size != "" && size != "original" {
+		n, err := strconv.Atoi(size)
+		if err != nil || !slices.Contains(media.ThumbnailSizes, n) {
+			c.JSON(http.StatusBadRequest, models.Response{Message: fmt.Sprintf("size must be original or one of %v", media.ThumbnailSizes)})
+			return
+		}
+
+		key, contentType = blobKey(image, size), image.ThumbnailType
+	}
+
+	blob, err := s.blobs.Get(c.Request.Context(), key)
+	if errors.Is(err, media.ErrBlobNotFound) {
+		c.JSON(http.StatusNotFound, models.Response{Message: models.ErrImageNotFound.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{Message: err.Error()})
+		return
+	}
+	defer blob.Close()
+
+	// Images are never changed in place, a new upload gets a new id.
+	c.DataFromReader(http.StatusOK, -1, contentType, blob, map[string]string{
+		"Cache-Control":          "private, max-age=86400",
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+func (s *Server) handleDeleteProductImage(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	imageId, err := ParseId(c.Param("imageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	image, err := s.store.DeleteProductImage(c.Request.Context(), id, imageId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	media.DeleteImageBlobs(c.Request.Context(), s.blobs, image.Key)
+	s.audit(c, "product.image_delete", "product:"+strconv.Itoa(id), image, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "image successfully deleted"})
+}
+
+type imageOrderRequest struct {
+	Ids []int `json:"ids" validate:"required,min=1"`
+}
+
+func (s *Server) handleReorderProductImages(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	request := imageOrderRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	if err := s.store.ReorderProductImages(c.Request.Context(), id, request.Ids); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "product.image_reorder", "product:"+strconv.Itoa(id), nil, request)
+
+	c.JSON(http.StatusOK, models.Response{Message: "images successfully reordered"})
+}
+
+func (s *Server) handleSetPrimaryProductImage(c *gin.Context) {
+	image, ok := s.productImage(c)
+	if !ok {
+		return
+	}
+
+	if err := s.store.SetPrimaryProductImage(c.Request.Context(), image.ProductId, image.Id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "product.image_primary", "product:"+strconv.Itoa(image.ProductId), nil, gin.H{"imageId": image.Id})
+
+	c.JSON(http.StatusOK, models.Response{Message: "primary image successfully set"})
+}
+
+// productImage loads the image addressed by the :id and :imageId parameters.
+// On failure the response is written and false is returned.
+func (s *Server) productImage(c *gin.Context) (models.ProductImage, bool) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return models.ProductImage{}, false
+	}
+
+	imageId, err := ParseId(c.Param("imageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return models.ProductImage{}, false
+	}
+
+	image, err := s.store.GetProductImage(c.Request.Context(), id, imageId)
+	if errors.Is(err, models.ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, models.Response{Message: err.Error()})
+		return models.ProductImage{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return models.ProductImage{}, false
+	}
+
+	return image, true
+}
+
+func (s *Server) putImageBlobs(ctx context.Context, image models.ProductImage, original []byte, thumbnails map[int][]byte) error {
+	if err := s.blobs.Put(ctx, blobKey(image, "original"), bytes.NewReader(original), int64(len(original)), image.ContentType); err != nil {
+		return err
+	}
+
+	for size, data := range thumbnails {
+		if err := s.blobs.Put(ctx, blobKey(image, strconv.Itoa(size)), bytes.NewReader(data), int64(len(data)), image.ThumbnailType); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newImageKey(productId int) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return fmt.Sprintf("products/%d/%s", productId, hex.EncodeToString(b))
+}
+
+func blobKey(image models.ProductImage, variant string) string {
+	return media.BlobKey(image.Key, variant)
+}
+
+func imageUrls(image models.ProductImage) map[string]string {
+	url := fmt.Sprintf("/products/%d/images/%d", image.ProductId, image.Id)
+
+	urls := map[string]string{"original": url}
+	for _, size := range media.ThumbnailSizes {
+		urls[strconv.Itoa(size)] = url + "?size=" + strconv.Itoa(size)
+	}
+
+	return urls
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleJWKS publishes the public keys of the tokens, so that other services
+// can verify them without the secret. Caches should expire well before a
+// new key starts signing, which is why rotation publishes keys first.
+func (s *Server) handleJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, s.keys.JWKS())
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ursuldaniel/go-market/internal/signing"
+)
+
+// TestJWKSPublishesPublicKeys checks that every key is published with its
+// id, algorithm and use, and that no private part of a key is.
+func TestJWKSPublishesPublicKeys(t *testing.T) {
+	dir := t.TempDir()
+	algorithms := []string{signing.RS256, signing.EdDSA}
+	paths, kids := []string{}, []string{}
+	for _, algorithm := range algorithms {
+		data, err := signing.GenerateKey(algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := signing.ParseKey(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kids = append(kids, key.ID)
+
+		path := filepath.Join(dir, algorithm+".pem")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	keys, err := signing.LoadKeySet(paths, "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, newFakeStorage())
+	s.keys = keys
+
+	w := do(t, s.routes(), http.MethodGet, "/.well-known/jwks.json", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	set := struct {
+		Keys []map[string]any `json:"keys"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.Keys) != 2 {
+		t.Fatalf("keys = %v, want both", set.Keys)
+	}
+
+	// d is the private exponent of RSA keys and the seed of Ed25519 keys,
+	// the others are the RSA primes and CRT values.
+	private := []string{"d", "p", "q", "dp", "dq", "qi", "k"}
+	for i, key := range set.Keys {
+		want := map[string]any{"kid": kids[i], "alg": algorithms[i], "use": "sig"}
+		for field, value := range want {
+			if key[field] != value {
+				t.Errorf("key %d: %s = %v, want %v", i, field, key[field], value)
+			}
+		}
+
+		for _, field := range private {
+			if _, ok := key[field]; ok {
+				t.Errorf("key %d publishes %q", i, field)
+			}
+		}
+	}
+
+	// The kid of every key verifies its tokens.
+	for _, path := range paths {
+		one, err := signing.LoadKeySet([]string{path}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := one.Sign(map[string]any{"id": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := keys.Parse(token); err != nil {
+			t.Errorf("token of %s rejected: %v", filepath.Base(path), err)
+		}
+	}
+}
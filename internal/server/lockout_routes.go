@@ -0,0 +1,109 @@
+package server
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// maxLoginDelay caps the delay between failed logins before the lockout.
+const maxLoginDelay = time.Second * 30
+
+func loginUserKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLoginLock aborts with 429 while the account or the client address is
+// locked. Unknown usernames are tracked like real ones, so the lock doesn't
+// tell whether an account exists.
+func (s *Server) checkLoginLock(c *gin.Context, username string) bool {
+	until, err := s.store.GetLoginLock(c.Request.Context(), []string{loginUserKey(username), loginIPKey(c.ClientIP())})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return false
+	}
+
+	if until.IsZero() {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	c.JSON(http.StatusTooManyRequests, models.Response{Message: "too many failed login attempts, try again later"})
+	return false
+}
+
+// recordLoginFailure delays the next attempt for the account and the client
+// address, doubling the delay with every failure in a row, and locks them
+// once they reach their limit.
+func (s *Server) recordLoginFailure(c *gin.Context, username string) {
+	s.lockAfterFailure(c, loginUserKey(username), s.maxLoginFailures, "user.lockout", "user:"+username)
+	s.lockAfterFailure(c, loginIPKey(c.ClientIP()), s.maxIPFailures, "ip.lockout", "ip:"+c.ClientIP())
+}
+
+func (s *Server) lockAfterFailure(c *gin.Context, key string, limit int, action, target string) {
+	ctx := c.Request.Context()
+
+	failures, err := s.store.RecordLoginFailure(ctx, key, s.loginLockout)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to record login failure", "key", key, "error", err)
+		return
+	}
+
+	delay := min(time.Second<<min(failures-1, 16), maxLoginDelay)
+	if failures >= limit {
+		delay = s.loginLockout
+	}
+
+	if err := s.store.LockLogin(ctx, key, time.Now().Add(delay)); err != nil {
+		slog.ErrorContext(ctx, "failed to lock login", "key", key, "error", err)
+		return
+	}
+
+	if failures >= limit {
+		slog.WarnContext(ctx, "login locked", "key", key, "failures", failures, "until", time.Now().Add(delay))
+		s.audit(c, action, target, nil, map[string]any{"failures": failures, "lockout": delay.String()})
+	}
+}
+
+// clearLoginFailures starts the count of the account over after a successful
+// login. The client address keeps its count, so that one known password
+// doesn't help guessing others.
+func (s *Server) clearLoginFailures(c *gin.Context, username string) {
+	if err := s.store.ClearLoginFailures(c.Request.Context(), []string{loginUserKey(username)}); err != nil {
+		slog.ErrorContext(c.Request.Context(), "failed to clear login failures", "username", username, "error", err)
+	}
+}
+
+// handleUnlockUser lifts the lockout of a user, and of the client address
+// given in ?ip= if any.
+func (s *Server) handleUnlockUser(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	keys := []string{loginUserKey(user.Username)}
+	if ip := c.Query("ip"); ip != "" {
+		keys = append(keys, loginIPKey(ip))
+	}
+
+	if err := s.store.ClearLoginFailures(c.Request.Context(), keys); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.unlock", "user:"+strconv.Itoa(user.Id), nil, map[string]any{"keys": keys})
+
+	c.JSON(http.StatusOK, models.Response{Message: "user successfully unlocked"})
+}
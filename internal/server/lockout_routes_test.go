@@ -0,0 +1,187 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// testClientIP is the address httptest gives every request.
+const testClientIP = "192.0.2.1"
+
+func newLockoutTestServer(t *testing.T) (*Server, *fakeStorage, http.Handler) {
+	t.Helper()
+
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin}
+	store.users[2] = models.User{Id: 2, Username: "alice", Role: models.RoleUser}
+	store.passwords[2] = "alice-password"
+
+	s := newTestServer(t, store)
+	s.maxLoginFailures = 8
+	s.maxIPFailures = 100
+	s.loginLockout = time.Minute * 15
+
+	return s, store, s.routes()
+}
+
+func login(t *testing.T, handler http.Handler, username, password string) int {
+	t.Helper()
+
+	return do(t, handler, http.MethodPost, "/users/login", "", `{"username": "`+username+`", "password": "`+password+`"}`).Code
+}
+
+// lockedFor returns how long key is locked, rounded up to the second.
+func lockedFor(store *fakeStorage, key string) time.Duration {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return time.Until(store.locks[key]).Round(time.Second)
+}
+
+// skipDelay lifts the locks but keeps the count of failures, as if the
+// delay had passed.
+func skipDelay(store *fakeStorage) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	clear(store.locks)
+}
+
+func TestLoginDelayDoubles(t *testing.T) {
+	s, store, handler := newLockoutTestServer(t)
+
+	second := time.Second
+	want := []time.Duration{second, 2 * second, 4 * second, 8 * second, 16 * second, maxLoginDelay, maxLoginDelay}
+	for i, delay := range want {
+		skipDelay(store)
+
+		if code := login(t, handler, "alice", "wrong"); code != http.StatusBadRequest {
+			t.Fatalf("failure %d: status = %d", i+1, code)
+		}
+
+		if locked := lockedFor(store, loginUserKey("alice")); locked != delay {
+			t.Errorf("failure %d: locked for %s, want %s", i+1, locked, delay)
+		}
+	}
+
+	// The 8th failure in a row locks the account.
+	skipDelay(store)
+	login(t, handler, "alice", "wrong")
+
+	if locked := lockedFor(store, loginUserKey("alice")); locked != s.loginLockout {
+		t.Errorf("locked for %s after %d failures, want %s", locked, s.maxLoginFailures, s.loginLockout)
+	}
+
+	lockouts := 0
+	for _, entry := range store.audit {
+		if entry.Action == "user.lockout" && entry.Target == "user:alice" {
+			lockouts++
+		}
+	}
+
+	if lockouts != 1 {
+		t.Errorf("%d lockouts audited, want 1", lockouts)
+	}
+}
+
+func TestLoginLockedResponds429(t *testing.T) {
+	s, store, handler := newLockoutTestServer(t)
+
+	for i := 0; i < s.maxLoginFailures; i++ {
+		skipDelay(store)
+		login(t, handler, "alice", "wrong")
+	}
+
+	// The right password doesn't help while the account is locked.
+	w := do(t, handler, http.MethodPost, "/users/login", "", `{"username": "alice", "password": "alice-password"}`)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429, body %s", w.Code, w.Body)
+	}
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter < int(s.loginLockout.Seconds())-1 || retryAfter > int(s.loginLockout.Seconds()) {
+		t.Errorf("Retry-After = %q, want about %d", w.Header().Get("Retry-After"), int(s.loginLockout.Seconds()))
+	}
+}
+
+func TestLoginSuccessKeepsIPFailures(t *testing.T) {
+	_, store, handler := newLockoutTestServer(t)
+
+	for i := 0; i < 2; i++ {
+		skipDelay(store)
+		login(t, handler, "alice", "wrong")
+	}
+
+	skipDelay(store)
+	if code := login(t, handler, "alice", "alice-password"); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+
+	if failures := store.failures[loginUserKey("alice")]; failures != 0 {
+		t.Errorf("account failures = %d after a successful login, want 0", failures)
+	}
+
+	if failures := store.failures[loginIPKey(testClientIP)]; failures != 2 {
+		t.Errorf("address failures = %d after a successful login, want 2", failures)
+	}
+}
+
+// TestLoginUnknownUserIsTracked checks that the response and the lock for
+// an unknown username are those of a wrong password.
+func TestLoginUnknownUserIsTracked(t *testing.T) {
+	_, store, handler := newLockoutTestServer(t)
+
+	known := do(t, handler, http.MethodPost, "/users/login", "", `{"username": "alice", "password": "wrong"}`)
+	skipDelay(store)
+	unknown := do(t, handler, http.MethodPost, "/users/login", "", `{"username": "mallory", "password": "wrong"}`)
+
+	if known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+		t.Errorf("unknown user: %d %s, wrong password: %d %s", unknown.Code, unknown.Body, known.Code, known.Body)
+	}
+
+	if locked := lockedFor(store, loginUserKey("mallory")); locked != time.Second {
+		t.Errorf("unknown user locked for %s, want 1s", locked)
+	}
+
+	if code := login(t, handler, "mallory", "wrong"); code != http.StatusTooManyRequests {
+		t.Errorf("second attempt for the unknown user: status = %d, want 429", code)
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	s, store, handler := newLockoutTestServer(t)
+
+	for i := 0; i < s.maxLoginFailures; i++ {
+		skipDelay(store)
+		login(t, handler, "alice", "wrong")
+	}
+
+	if code := login(t, handler, "alice", "alice-password"); code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want the account locked", code)
+	}
+
+	w := do(t, handler, http.MethodPost, "/admin/users/2/unlock?ip="+testClientIP, adminToken(t, s, 1), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("unlock: status = %d, body %s", w.Code, w.Body)
+	}
+
+	if code := login(t, handler, "alice", "alice-password"); code != http.StatusOK {
+		t.Errorf("login after unlock: status = %d, want 200", code)
+	}
+
+	index := slices.IndexFunc(store.audit, func(entry models.AuditEntry) bool { return entry.Action == "user.unlock" })
+	if index < 0 {
+		t.Fatal("unlock isn't audited")
+	}
+
+	entry := store.audit[index]
+	wantDiff := `{"keys":{"after":["user:alice","ip:` + testClientIP + `"],"before":null}}`
+	if entry.ActorId != 1 || entry.Target != "user:2" || string(entry.Diff) != wantDiff {
+		t.Errorf("audit entry = %+v, diff %s", entry, entry.Diff)
+	}
+}
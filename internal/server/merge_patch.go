@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7386) to target and
+// decodes the result back into a value of the same type. Fields that are
+// not part of T are rejected.
+func applyMergePatch[T any](target T, patch any) (T, error) {
+	var result T
+
+	data, err := json.Marshal(target)
+	if err != nil {
+		return result, err
+	}
+
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return result, err
+	}
+
+	merged, err := json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		return result, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
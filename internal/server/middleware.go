@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/logging"
+	"github.com/ursuldaniel/go-market/internal/metrics"
+)
+
+const RequestIdHeader = "X-Request-ID"
+
+// RequestId propagates the X-Request-ID header of the incoming request or
+// generates a new id, and stores it in the request context.
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(RequestIdHeader)
+		if !isValidRequestId(requestId) {
+			requestId = newRequestId()
+		}
+
+		c.Request = c.Request.WithContext(logging.WithRequestId(c.Request.Context(), requestId))
+		c.Header(RequestIdHeader, requestId)
+		c.Next()
+	}
+}
+
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		metrics.ObserveRequest(c.Request.Method, c.FullPath(), status, time.Since(start))
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		slog.LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("user_id", c.GetInt("id")),
+			slog.String("ip", c.ClientIP()),
+			slog.Int("size", c.Writer.Size()),
+		)
+	}
+}
+
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > 128 {
+		return false
+	}
+
+	for _, r := range requestId {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/oidc"
+)
+
+const (
+	oidcLoginCookie = "oidc_login"
+	oidcCookiePath  = "/users/oidc"
+	oidcLoginTTL    = time.Minute * 10
+)
+
+// handleOIDCLogin sends the user to the identity provider. The state, nonce
+// and PKCE verifier of the attempt travel in a signed cookie, so that the
+// callback only accepts the answer to a login started in the same browser.
+func (s *Server) handleOIDCLogin(c *gin.Context) {
+	if s.idp == nil {
+		c.JSON(http.StatusNotFound, models.Response{Message: "sign-in with an identity provider is not configured"})
+		return
+	}
+
+	state, nonce, verifier := oidc.RandomString(24), oidc.RandomString(24), oidc.NewVerifier()
+
+	authURL, err := s.idp.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "identity provider is unavailable", "error", err)
+		c.JSON(http.StatusBadGateway, models.Response{Message: "identity provider is unavailable"})
+		return
+	}
+
+	cookie, err := createOIDCLoginToken(s.secret, state, nonce, verifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{Message: err.Error()})
+		return
+	}
+
+	// Lax lets the cookie come back with the redirect from the provider.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLoginCookie, cookie, int(oidcLoginTTL.Seconds()), oidcCookiePath, "", secureRequest(c), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// handleOIDCCallback finishes the sign-in: it redeems the code, links the
+// identity to a user, creating one on first sign-in, and responds like
+// handleLoginUser.
+func (s *Server) handleOIDCCallback(c *gin.Context) {
+	if s.idp == nil {
+		c.JSON(http.StatusNotFound, models.Response{Message: "sign-in with an identity provider is not configured"})
+		return
+	}
+
+	cookie, err := c.Cookie(oidcLoginCookie)
+
+	// The cookie is good for a single attempt.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLoginCookie, "", -1, oidcCookiePath, "", secureRequest(c), true)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "there is no sign-in in progress"})
+		return
+	}
+
+	state, nonce, verifier, err := parseOIDCLoginToken(s.secret, cookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.Query("state")), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "state doesn't match the sign-in in progress"})
+		return
+	}
+
+	if errorCode := c.Query("error"); errorCode != "" {
+		c.JSON(http.StatusUnauthorized, models.Response{Message: strings.TrimSpace("identity provider: " + errorCode + " " + c.Query("error_description"))})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "code is missing"})
+		return
+	}
+
+	claims, err := s.idp.Exchange(c.Request.Context(), code, verifier, nonce)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "identity provider sign-in failed", "error", err)
+		s.audit(c, "user.oidc_login_failed", "issuer:"+s.idp.Issuer(), nil, nil)
+		c.JSON(http.StatusUnauthorized, models.Response{Message: "sign-in with the identity provider failed"})
+		return
+	}
+
+	user, created, err := s.store.LoginExternalUser(c.Request.Context(), models.ExternalIdentity{
+		Issuer:        s.idp.Issuer(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Username:      suggestedUsername(claims),
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.Set("id", user.Id)
+
+	if created {
+		s.audit(c, "user.register_oidc", "user:"+strconv.Itoa(user.Id), nil, gin.H{"issuer": s.idp.Issuer(), "subject": claims.Subject})
+	}
+
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, models.Response{Message: models.ErrUserDisabled.Error()})
+		return
+	}
+
+	s.completeLogin(c, user, user.Role == models.RoleAdmin)
+}
+
+func suggestedUsername(claims oidc.Claims) string {
+	if claims.PreferredUsername != "" {
+		return claims.PreferredUsername
+	}
+
+	if local, _, ok := strings.Cut(claims.Email, "@"); ok && local != "" {
+		return local
+	}
+
+	return claims.Name
+}
+
+// secureRequest reports whether the client reached the API over HTTPS,
+// directly or through a proxy.
+func secureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+func createOIDCLoginToken(secret, state, nonce, verifier string) (string, error) {
+	claims := &jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcLoginTTL).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(secret))
+}
+
+func parseOIDCLoginToken(secret, tokenString string) (string, string, string, error) {
+	errInvalid := errors.New("sign-in has expired, start it again")
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil || !token.Valid {
+		return "", "", "", errInvalid
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", "", errInvalid
+	}
+
+	state, _ := claims["state"].(string)
+	nonce, _ := claims["nonce"].(string)
+	verifier, _ := claims["verifier"].(string)
+	if state == "" || nonce == "" || verifier == "" {
+		return "", "", "", errInvalid
+	}
+
+	return state, nonce, verifier, nil
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/oidc"
+	"github.com/ursuldaniel/go-market/internal/oidc/fakeidp"
+)
+
+const oidcRedirectURL = "http://api.example.com/users/oidc/callback"
+
+// oidcFlow drives a sign-in through the server and a fake provider the way
+// a browser would.
+type oidcFlow struct {
+	t       *testing.T
+	s       *Server
+	handler http.Handler
+	store   *fakeStorage
+	issuer  string
+}
+
+func newOIDCFlow(t *testing.T, store *fakeStorage) *oidcFlow {
+	t.Helper()
+
+	idp, err := fakeidp.New(fakeidp.Config{ClientID: "go-market"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idpServer := httptest.NewServer(idp)
+	t.Cleanup(idpServer.Close)
+
+	provider, err := oidc.New(oidc.Config{Issuer: idpServer.URL, ClientID: "go-market", RedirectURL: oidcRedirectURL, Scopes: []string{"openid", "email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, store)
+	s.idp = provider
+
+	return &oidcFlow{t: t, s: s, handler: s.routes(), store: store, issuer: idpServer.URL}
+}
+
+// login starts a sign-in and returns the address of the provider and the
+// cookie that holds the state, nonce and verifier.
+func (f *oidcFlow) login() (*url.URL, *http.Cookie) {
+	f.t.Helper()
+
+	w := do(f.t, f.handler, http.MethodGet, "/users/oidc/login", "", "")
+	if w.Code != http.StatusFound {
+		f.t.Fatalf("login: status = %d, body %s", w.Code, w.Body)
+	}
+
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcLoginCookie {
+			return authURL, cookie
+		}
+	}
+
+	f.t.Fatal("login: no cookie")
+	return nil, nil
+}
+
+// authorize signs in at the provider as email and returns the query of the
+// redirect back to the callback.
+func (f *oidcFlow) authorize(authURL *url.URL, email string) url.Values {
+	f.t.Helper()
+
+	query := authURL.Query()
+	query.Set("login_hint", email)
+	authURL.RawQuery = query.Encode()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL.String())
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		f.t.Fatalf("authorize: status = %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	if callback.Query().Get("code") == "" {
+		f.t.Fatalf("authorize: no code in %s", callback)
+	}
+
+	return callback.Query()
+}
+
+func (f *oidcFlow) callback(query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	f.t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/users/oidc/callback?"+query.Encode(), nil)
+	req.AddCookie(cookie)
+
+	w := httptest.NewRecorder()
+	f.handler.ServeHTTP(w, req)
+	return w
+}
+
+// loggedInAs returns the id of the user whose token the response carries.
+func (f *oidcFlow) loggedInAs(w *httptest.ResponseRecorder) int {
+	f.t.Helper()
+
+	if w.Code != http.StatusOK {
+		f.t.Fatalf("callback: status = %d, body %s", w.Code, w.Body)
+	}
+
+	response := models.LoginResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		f.t.Fatal(err)
+	}
+
+	claims, err := f.s.keys.Parse(response.Message)
+	if err != nil {
+		f.t.Fatalf("callback: invalid token: %v", err)
+	}
+
+	id, _ := claims["id"].(float64)
+	return int(id)
+}
+
+// forgeCookie returns the cookie of a sign-in with some of its values
+// replaced by edit.
+func (f *oidcFlow) forgeCookie(cookie *http.Cookie, edit func(state, nonce, verifier *string)) *http.Cookie {
+	f.t.Helper()
+
+	state, nonce, verifier, err := parseOIDCLoginToken(f.s.secret, cookie.Value)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	edit(&state, &nonce, &verifier)
+
+	value, err := createOIDCLoginToken(f.s.secret, state, nonce, verifier)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	return &http.Cookie{Name: oidcLoginCookie, Value: value}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "alice", Email: "alice@example.com", Role: models.RoleUser, EmailVerified: true}
+	store.users[2] = models.User{Id: 2, Username: "bob", Email: "bob@example.com", Role: models.RoleUser}
+	flow := newOIDCFlow(t, store)
+
+	authURL, cookie := flow.login()
+	if id := flow.loggedInAs(flow.callback(flow.authorize(authURL, "Alice@example.com"), cookie)); id != 1 {
+		t.Errorf("signed in as %d, want the user with the verified email", id)
+	}
+
+	if len(store.identities) != 1 {
+		t.Fatalf("identities = %+v", store.identities)
+	}
+
+	identity := store.identities[0]
+	if identity.Issuer != flow.issuer || identity.Subject == "" || identity.Email != "alice@example.com" || !identity.EmailVerified {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	// The second sign-in finds the identity.
+	authURL, cookie = flow.login()
+	if id := flow.loggedInAs(flow.callback(flow.authorize(authURL, "alice@example.com"), cookie)); id != 1 {
+		t.Errorf("second sign-in as %d, want 1", id)
+	}
+
+	// Bob hasn't verified his email, so whoever signs in with it gets a new
+	// user instead of his account.
+	authURL, cookie = flow.login()
+	if id := flow.loggedInAs(flow.callback(flow.authorize(authURL, "bob@example.com"), cookie)); id == 2 || id == 0 {
+		t.Errorf("signed in as %d, want a new user", id)
+	}
+
+	if len(store.identities) != 2 {
+		t.Errorf("identities = %+v", store.identities)
+	}
+}
+
+func TestOIDCCallbackRejectsMismatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		forge  func(flow *oidcFlow, query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie)
+		status int
+	}{
+		{
+			name: "state",
+			forge: func(flow *oidcFlow, query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie) {
+				query.Set("state", oidc.RandomString(24))
+				return query, cookie
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "cookie of another sign-in",
+			forge: func(flow *oidcFlow, query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie) {
+				_, other := flow.login()
+				return query, other
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "nonce",
+			forge: func(flow *oidcFlow, query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie) {
+				return query, flow.forgeCookie(cookie, func(state, nonce, verifier *string) { *nonce = oidc.RandomString(24) })
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "verifier",
+			forge: func(flow *oidcFlow, query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie) {
+				return query, flow.forgeCookie(cookie, func(state, nonce, verifier *string) { *verifier = oidc.NewVerifier() })
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "unsigned cookie",
+			forge: func(flow *oidcFlow, query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie) {
+				return query, &http.Cookie{Name: oidcLoginCookie, Value: cookie.Value + "x"}
+			},
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newFakeStorage()
+			store.users[1] = models.User{Id: 1, Username: "alice", Email: "alice@example.com", Role: models.RoleUser, EmailVerified: true}
+			flow := newOIDCFlow(t, store)
+
+			authURL, cookie := flow.login()
+			query, cookie := test.forge(flow, flow.authorize(authURL, "alice@example.com"), cookie)
+
+			if w := flow.callback(query, cookie); w.Code != test.status {
+				t.Errorf("status = %d, want %d, body %s", w.Code, test.status, w.Body)
+			}
+
+			if len(store.identities) != 0 {
+				t.Errorf("the identity was linked: %+v", store.identities)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/mail"
+	"github.com/ursuldaniel/go-market/internal/mail/smtptest"
+	"github.com/ursuldaniel/go-market/internal/notify"
+)
+
+func TestImportNotifiesBackInStock(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin}
+	store.users[2] = models.User{Id: 2, Username: "alice", Email: "alice@example.com"}
+	store.products[1] = models.Product{Id: 1, Sku: "KETTLE", Name: "Kettle", Price: 100, Quantity: 0, Version: 1}
+	store.products[2] = models.Product{Id: 2, Sku: "MUG", Name: "Mug", Price: 10, Quantity: 5, Version: 1}
+	store.products[3] = models.Product{Id: 3, Sku: "TEAPOT", Name: "Teapot", Price: 50, Quantity: 2, Version: 1}
+	store.subscribers[1] = []int{2}
+	store.subscribers[3] = []int{2}
+
+	notifier := &fakeNotifier{}
+	s := newTestServer(t, store)
+	s.notifier = notifier
+
+	body := "sku,name,description,price,quantity\n" +
+		"KETTLE,Kettle,,100,7\n" +
+		"MUG,Mug,,10,5\n" +
+		"TEAPOT,Teapot,,50,0\n" +
+		"CUP,Cup,,5,3\n"
+	w := do(t, s.routes(), http.MethodPost, "/admin/products/import?format=csv", adminToken(t, s, 1), body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	s.background.Wait()
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	if len(notifier.messages) != 1 || notifier.messages[0].UserId != 2 || !strings.Contains(notifier.messages[0].Subject, "Kettle") {
+		t.Errorf("notifications = %+v, want one for the kettle", notifier.messages)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	stockChanged := []string{}
+	for _, event := range store.events {
+		if strings.HasPrefix(event, models.EventProductStockChanged+" ") {
+			stockChanged = append(stockChanged, event)
+		}
+	}
+
+	// The kettle came back and the teapot sold out, the mug didn't change
+	// and the cup is new.
+	if len(stockChanged) != 2 || !strings.Contains(stockChanged[0], `"KETTLE"`) || !strings.Contains(stockChanged[1], `"TEAPOT"`) {
+		t.Errorf("stock changed events = %v, want the kettle and the teapot", stockChanged)
+	}
+
+	if len(store.subscribers[3]) != 1 {
+		t.Errorf("subscribers of the sold out teapot were taken")
+	}
+}
+
+// TestImportEmailsBackInStock sends the back in stock notification through
+// an SMTP server.
+func TestImportEmailsBackInStock(t *testing.T) {
+	smtpServer, err := smtptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smtpServer.Close()
+
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin}
+	store.users[2] = models.User{Id: 2, Username: "alice", Email: "alice@example.com"}
+	store.products[1] = models.Product{Id: 1, Sku: "KETTLE", Name: "Kettle", Price: 100, Quantity: 0, Version: 1}
+	store.subscribers[1] = []int{2}
+
+	s := newTestServer(t, store)
+	s.notifier = notify.NewEmail(mail.NewSMTPMailer(mail.SMTPConfig{Addr: smtpServer.Addr(), From: "go-market@example.com", Timeout: time.Second * 5}))
+
+	w := do(t, s.routes(), http.MethodPost, "/admin/products/import?format=csv", adminToken(t, s, 1), "sku,name,description,price,quantity\nKETTLE,Kettle,,100,7\n")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	s.background.Wait()
+
+	messages := smtpServer.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("messages = %+v, want one", messages)
+	}
+
+	if to := messages[0].To; len(to) != 1 || to[0] != "alice@example.com" {
+		t.Errorf("recipients = %q", to)
+	}
+
+	received, err := netmail.ReadMessage(bytes.NewReader(messages[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(received.Header.Get("Subject"))
+	if err != nil || subject != "Kettle is back in stock" {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+
+	body, err := io.ReadAll(received.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Hi alice,\r\n\r\nKettle is available again: /products/1\r\n"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestImportDryRunAnnouncesNothing(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin}
+	store.products[1] = models.Product{Id: 1, Sku: "KETTLE", Name: "Kettle", Price: 100, Quantity: 0, Version: 1}
+	store.subscribers[1] = []int{1}
+
+	notifier := &fakeNotifier{}
+	s := newTestServer(t, store)
+	s.notifier = notifier
+
+	body := "sku,name,description,price,quantity\nKETTLE,Kettle,,100,7\n"
+	w := do(t, s.routes(), http.MethodPost, "/admin/products/import?format=csv&dryRun=true", adminToken(t, s, 1), body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	s.background.Wait()
+
+	if len(notifier.messages) != 0 || len(store.events) != 0 {
+		t.Errorf("dry run sent %v and published %v", notifier.messages, store.events)
+	}
+}
@@ -1,35 +1,68 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/catalog"
 	"github.com/ursuldaniel/go-market/internal/domain/models"
 )
 
 func (s *Server) handleAddProduct(c *gin.Context) {
-	product := models.Product{}
-	if err := c.ShouldBindBodyWithJSON(&product); err != nil {
+	request := productRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	if err := s.store.AddProduct(product.Name, product.Description, product.Price, product.Quantity); err != nil {
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	product := request.apply(models.Product{})
+
+	id, err := s.store.AddProduct(c.Request.Context(), product.Sku, product.Name, product.Description, product.Price, product.Quantity)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
+	product.Id = id
+	s.audit(c, "product.create", "product:"+strconv.Itoa(id), nil, product)
+
 	c.JSON(http.StatusOK, models.Response{Message: "product successfully added"})
 }
 
 func (s *Server) handleGetAllProducts(c *gin.Context) {
-	products, err := s.store.GetAllProducts()
+	products, err := s.store.GetAllProducts(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, products)
+	switch c.DefaultQuery("sort", "id") {
+	case "id":
+	case "rating":
+		// Best rated first, more reviews win a tie.
+		sort.SliceStable(products, func(i, j int) bool {
+			if products[i].Rating != products[j].Rating {
+				return products[i].Rating > products[j].Rating
+			}
+			return products[i].ReviewCount > products[j].ReviewCount
+		})
+	default:
+		c.JSON(http.StatusBadRequest, models.Response{Message: "sort must be id or rating"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newProductResponses(products))
 }
 
 func (s *Server) handleGetProductById(c *gin.Context) {
@@ -39,13 +72,19 @@ func (s *Server) handleGetProductById(c *gin.Context) {
 		return
 	}
 
-	product, err := s.store.GetProductById(id)
+	product, err := s.store.GetProductById(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, product)
+	c.Header("ETag", productETag(product))
+	if header := c.GetHeader("If-None-Match"); header != "" && etagMatches(header, productETag(product)) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, newProductResponse(product))
 }
 
 func (s *Server) handleUpdateProduct(c *gin.Context) {
@@ -55,17 +94,96 @@ func (s *Server) handleUpdateProduct(c *gin.Context) {
 		return
 	}
 
-	product := models.Product{}
-	if err := c.ShouldBindBodyWithJSON(&product); err != nil {
+	request := productRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	oldProduct, err := s.store.GetProductById(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.saveProduct(c, oldProduct, request)
+}
+
+// handlePatchProduct applies a JSON Merge Patch (RFC 7386) to the product,
+// so only the fields present in the body are changed.
+func (s *Server) handlePatchProduct(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	var patch any
+	if err := c.ShouldBindBodyWithJSON(&patch); err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	if err = s.store.UpdateProduct(id, product.Name, product.Description, product.Price, product.Quantity); err != nil {
+	if fields, ok := patch.(map[string]any); !ok {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "patch must be a JSON object"})
+		return
+	} else if _, ok := fields["id"]; ok {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "id cannot be changed"})
+		return
+	}
+
+	oldProduct, err := s.store.GetProductById(c.Request.Context(), id)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
+	request, err := applyMergePatch(newProductRequest(oldProduct), patch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.saveProduct(c, oldProduct, request)
+}
+
+// saveProduct validates and stores the new state of oldProduct. The request
+// must carry an If-Match header with the current version of oldProduct.
+func (s *Server) saveProduct(c *gin.Context, oldProduct models.Product, request productRequest) {
+	if !checkIfMatch(c, oldProduct) {
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	product := request.apply(oldProduct)
+
+	version, err := s.store.UpdateProduct(c.Request.Context(), product.Id, product.Version, product.Sku, product.Name, product.Description, product.Price, product.Quantity)
+	if errors.Is(err, models.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	product.Version = version
+	c.Header("ETag", productETag(product))
+
+	s.audit(c, "product.update", "product:"+strconv.Itoa(product.Id), oldProduct, product)
+
+	if oldProduct.Quantity != product.Quantity {
+		s.publishEvent(c.Request.Context(), models.EventProductStockChanged, product)
+	}
+
+	if oldProduct.Quantity <= 0 && product.Quantity > 0 {
+		s.notifyBackInStock(c.Request.Context(), product)
+	}
+
 	c.JSON(http.StatusOK, models.Response{Message: "product successfully updated"})
 }
 
@@ -76,10 +194,162 @@ func (s *Server) handleDeleteProduct(c *gin.Context) {
 		return
 	}
 
-	if err := s.store.DeleteProduct(id); err != nil {
+	oldProduct, err := s.store.GetProductById(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if !checkIfMatch(c, oldProduct) {
+		return
+	}
+
+	err = s.store.DeleteProduct(c.Request.Context(), id, oldProduct.Version)
+	if errors.Is(err, models.ErrVersionConflict) {
+		c.JSON(http.StatusPreconditionFailed, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
+	s.audit(c, "product.delete", "product:"+strconv.Itoa(id), oldProduct, nil)
+
 	c.JSON(http.StatusOK, models.Response{Message: "product successfully deleted"})
 }
+
+func (s *Server) handleGetDeletedProducts(c *gin.Context) {
+	products, err := s.store.GetDeletedProducts(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newProductResponses(products))
+}
+
+func (s *Server) handleRestoreProduct(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.RestoreProduct(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "product.restore", "product:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "product successfully restored"})
+}
+
+const maxImportSize = 10 << 20
+
+func (s *Server) handleImportProducts(c *gin.Context) {
+	format, err := catalogFormat(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", catalog.ModeTransactional)
+	dryRun := c.Query("dryRun") == "true"
+
+	rows, err := catalog.Decode(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize), format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	report, err := catalog.Import(c.Request.Context(), s.store, rows, mode, dryRun)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if !dryRun {
+		summary := gin.H{"mode": mode, "created": report.Created, "updated": report.Updated, "failed": report.Failed}
+		s.audit(c, "product.import", "products", nil, summary)
+		s.announceImportedStock(c.Request.Context(), report.Rows)
+	}
+
+	status := http.StatusOK
+	if report.Failed > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	c.JSON(status, report)
+}
+
+// announceImportedStock does for imported products what saveProduct does
+// for a single one: stock changes are published and the subscribers of
+// products back in stock are notified.
+func (s *Server) announceImportedStock(ctx context.Context, rows []models.ImportResult) {
+	for _, row := range rows {
+		if row.Action != models.ImportUpdated || row.PreviousQuantity == row.Quantity {
+			continue
+		}
+
+		product, err := s.store.GetProductById(ctx, row.Id)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to load imported product", "product_id", row.Id, "error", err)
+			continue
+		}
+
+		s.publishEvent(ctx, models.EventProductStockChanged, product)
+
+		if row.PreviousQuantity <= 0 && row.Quantity > 0 {
+			s.notifyBackInStock(ctx, product)
+		}
+	}
+}
+
+func (s *Server) handleExportProducts(c *gin.Context) {
+	format := c.DefaultQuery("format", catalog.FormatCSV)
+
+	contentType := "text/csv"
+	if format == catalog.FormatJSONL {
+		contentType = "application/x-ndjson"
+	}
+
+	encoder, err := catalog.NewEncoder(c.Writer, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename=products."+format)
+	c.Status(http.StatusOK)
+
+	err = s.store.StreamProducts(c.Request.Context(), func(product models.Product) error {
+		return encoder.Write(product)
+	})
+	if err == nil {
+		err = encoder.Flush()
+	}
+
+	if err != nil {
+		// The status line has already been sent, so the error can only be logged.
+		slog.ErrorContext(c.Request.Context(), "failed to export products", "error", err)
+	}
+}
+
+// catalogFormat reads the import format from the format query parameter or the Content-Type header.
+func catalogFormat(c *gin.Context) (string, error) {
+	if format := c.Query("format"); format != "" {
+		return format, nil
+	}
+
+	switch c.ContentType() {
+	case "text/csv":
+		return catalog.FormatCSV, nil
+	case "application/x-ndjson", "application/jsonl":
+		return catalog.FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown import format, use ?format=csv|jsonl or a text/csv or application/x-ndjson body")
+	}
+}
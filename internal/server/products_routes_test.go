@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+var testKettle = models.Product{Id: 1, Sku: "KETTLE", Name: "Kettle", Description: "1.7 l, steel", Price: 100, Quantity: 3, Version: 1}
+
+func newProductTestServer(t *testing.T) (*Server, *fakeStorage, http.Handler) {
+	t.Helper()
+
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin}
+	store.products[1] = testKettle
+
+	s := newTestServer(t, store)
+	return s, store, s.routes()
+}
+
+// patchProduct sends a merge patch for the current version of product 1.
+func patchProduct(t *testing.T, s *Server, handler http.Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPatch, "/products/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("Authorization", adminToken(t, s, 1))
+	req.Header.Set("If-Match", "*")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+// fieldErrors returns the fields of a validation response.
+func fieldErrors(t *testing.T, w *httptest.ResponseRecorder) []string {
+	t.Helper()
+
+	response := models.ValidationResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := []string{}
+	for _, fieldError := range response.Errors {
+		if fieldError.Error == "" {
+			t.Errorf("field %s has no message", fieldError.Field)
+		}
+		fields = append(fields, fieldError.Field)
+	}
+	slices.Sort(fields)
+
+	return fields
+}
+
+func TestPatchProduct(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  func(product models.Product) models.Product
+	}{
+		{
+			name:  "name only",
+			patch: `{"name": "Electric kettle"}`,
+			want: func(product models.Product) models.Product {
+				product.Name = "Electric kettle"
+				return product
+			},
+		},
+		{
+			name:  "null clears the description",
+			patch: `{"description": null}`,
+			want: func(product models.Product) models.Product {
+				product.Description = ""
+				return product
+			},
+		},
+		{
+			name:  "several fields",
+			patch: `{"price": 120, "quantity": 0}`,
+			want: func(product models.Product) models.Product {
+				product.Price, product.Quantity = 120, 0
+				return product
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, store, handler := newProductTestServer(t)
+
+			if w := patchProduct(t, s, handler, test.patch); w.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", w.Code, w.Body)
+			}
+
+			want := test.want(testKettle)
+			want.Version++
+			if store.products[1] != want {
+				t.Errorf("product = %+v, want %+v", store.products[1], want)
+			}
+		})
+	}
+}
+
+func TestPatchProductRejectsUnknownFields(t *testing.T) {
+	for _, patch := range []string{`{"color": "red"}`, `{"name": "Teapot", "version": 7}`, `{"rating": 5}`, `{"id": 2}`, `[]`} {
+		s, store, handler := newProductTestServer(t)
+
+		if w := patchProduct(t, s, handler, patch); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", patch, w.Code)
+		}
+
+		if store.products[1] != testKettle {
+			t.Errorf("%s: product changed to %+v", patch, store.products[1])
+		}
+	}
+}
+
+func TestProductValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		fields []string
+	}{
+		{"zero price", http.MethodPost, `{"name": "Mug", "price": 0, "quantity": 1}`, []string{"price"}},
+		{"negative quantity", http.MethodPost, `{"name": "Mug", "price": 10, "quantity": -1}`, []string{"quantity"}},
+		{"every field", http.MethodPost, `{"price": -5, "quantity": -1}`, []string{"name", "price", "quantity"}},
+		{"patched zero price", http.MethodPatch, `{"price": 0}`, []string{"price"}},
+		{"patched negative quantity", http.MethodPatch, `{"quantity": -1, "name": null}`, []string{"name", "quantity"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, store, handler := newProductTestServer(t)
+
+			var w *httptest.ResponseRecorder
+			if test.method == http.MethodPatch {
+				w = patchProduct(t, s, handler, test.body)
+			} else {
+				w = do(t, handler, http.MethodPost, "/products/", adminToken(t, s, 1), test.body)
+			}
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400, body %s", w.Code, w.Body)
+			}
+
+			if fields := fieldErrors(t, w); !slices.Equal(fields, test.fields) {
+				t.Errorf("errors for %v, want %v", fields, test.fields)
+			}
+
+			if len(store.products) != 1 || store.products[1] != testKettle {
+				t.Errorf("products changed: %+v", store.products)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// profileUpdate holds the fields of the profile that users change themselves.
+type profileUpdate struct {
+	Username string `json:"username" validate:"required,max=64"`
+	Email    string `json:"email" validate:"omitempty,email"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,max=72,nefield=CurrentPassword"`
+}
+
+type deleteProfileRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// profileExport is everything the application keeps about a user.
+type profileExport struct {
+	ExportedAt         time.Time                 `json:"exportedAt"`
+	Profile            userResponse              `json:"profile"`
+	Identities         []models.ExternalIdentity `json:"identities"`
+	Purchases          []purchaseResponse        `json:"purchases"`
+	Reviews            []models.Review           `json:"reviews"`
+	Wishlist           []productResponse         `json:"wishlist"`
+	StockSubscriptions []int                     `json:"stockSubscriptions"`
+	Notifications      []models.Notification     `json:"notifications"`
+	Activity           []activityResponse        `json:"activity"`
+}
+
+// handleUpdateProfile applies a JSON Merge Patch to the username and email
+// of the caller. A new email has to be verified again.
+func (s *Server) handleUpdateProfile(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	var patch any
+	if err := c.ShouldBindBodyWithJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if _, ok := patch.(map[string]any); !ok {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "patch must be a JSON object"})
+		return
+	}
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	oldProfile := profileUpdate{Username: user.Username, Email: user.Email}
+	profile, err := applyMergePatch(oldProfile, patch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&profile); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	err = s.store.UpdateUserProfile(c.Request.Context(), id, profile.Username, profile.Email)
+	if errors.Is(err, models.ErrUsernameTaken) {
+		c.JSON(http.StatusConflict, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.update_profile", "user:"+strconv.Itoa(id), oldProfile, profile)
+
+	if profile.Email != oldProfile.Email && profile.Email != "" {
+		user.Username, user.Email = profile.Username, profile.Email
+		if err := s.sendVerificationEmail(c.Request.Context(), user); err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to send verification email", "userId", id, "error", err)
+		}
+	}
+
+	s.handleProfile(c)
+}
+
+func (s *Server) handleChangePassword(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	request := changePasswordRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	// A stolen token must not be a way around the login lockout.
+	if !s.checkLoginLock(c, user.Username) {
+		return
+	}
+
+	err = s.store.ChangePassword(c.Request.Context(), id, request.CurrentPassword, request.NewPassword)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		s.recordLoginFailure(c, user.Username)
+		c.JSON(http.StatusForbidden, models.Response{Message: "current password is wrong"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.password_change", "user:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "password successfully changed"})
+}
+
+// handleDeleteProfile anonymizes the account of the caller after checking
+// the password once more.
+func (s *Server) handleDeleteProfile(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	request := deleteProfileRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if !s.checkLoginLock(c, user.Username) {
+		return
+	}
+
+	if loginId, err := s.store.LoginUser(c.Request.Context(), user.Username, request.Password); err != nil || loginId != id {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			s.recordLoginFailure(c, user.Username)
+		}
+		c.JSON(http.StatusForbidden, models.Response{Message: "password is wrong"})
+		return
+	}
+
+	if err := s.store.DeleteUser(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.delete", "user:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "account successfully deleted"})
+}
+
+// handleExportProfile returns all data of the caller as a JSON file.
+func (s *Server) handleExportProfile(c *gin.Context) {
+	id := c.MustGet("id").(int)
+	ctx := c.Request.Context()
+
+	export := profileExport{ExportedAt: time.Now().UTC()}
+
+	user, err := s.store.GetUserProfile(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+	export.Profile = newUserResponse(user)
+
+	if export.Identities, err = s.store.GetUserIdentities(ctx, id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	purchases, err := s.store.GetUserPurchases(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+	export.Purchases = newPurchaseResponses(purchases)
+
+	if export.Reviews, err = s.store.GetUserReviews(ctx, id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	wishlist, err := s.store.GetWishlist(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+	export.Wishlist = newProductResponses(wishlist)
+
+	if export.StockSubscriptions, err = s.store.GetStockSubscriptions(ctx, id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if export.Notifications, err = s.store.GetNotifications(ctx, id, false); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	activity, err := s.store.GetAuditEntries(ctx, models.AuditFilter{ActorId: id, Limit: 500})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+	export.Activity = newActivityResponses(activity)
+
+	s.audit(c, "user.export", "user:"+strconv.Itoa(id), nil, nil)
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="go-market-export-%d.json"`, id))
+	c.JSON(http.StatusOK, export)
+}
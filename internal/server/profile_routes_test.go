@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// TestChangePasswordTooLong checks that passwords bcrypt would truncate are
+// rejected before they reach the storage.
+func TestChangePasswordTooLong(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "alice"}
+	s := newTestServer(t, store)
+
+	body := `{"currentPassword": "old-password", "newPassword": "` + strings.Repeat("a", 73) + `"}`
+	w := do(t, s.routes(), http.MethodPost, "/users/password", userToken(t, s, 1), body)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "newPassword") {
+		t.Errorf("status = %d, body %s, want 400 about newPassword", w.Code, w.Body)
+	}
+}
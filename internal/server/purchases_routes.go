@@ -1,11 +1,13 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/metrics"
 )
 
 func (s *Server) handleMakePurchase(c *gin.Context) {
@@ -13,6 +15,7 @@ func (s *Server) handleMakePurchase(c *gin.Context) {
 
 	productId, err := ParseId(c.Param("id"))
 	if err != nil {
+		metrics.PurchaseFailed("invalid_request")
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
@@ -20,28 +23,43 @@ func (s *Server) handleMakePurchase(c *gin.Context) {
 	quantity_ := c.Query("quantity")
 	quantity, err := strconv.Atoi(quantity_)
 	if err != nil {
+		metrics.PurchaseFailed("invalid_request")
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	if err := s.store.MakePurchase(userId, productId, quantity); err != nil {
+	if quantity <= 0 {
+		metrics.PurchaseFailed("invalid_request")
+		c.JSON(http.StatusBadRequest, models.Response{Message: models.ErrInvalidQuantity.Error()})
+		return
+	}
+
+	purchase, err := s.store.MakePurchase(c.Request.Context(), userId, productId, quantity)
+	if err != nil {
+		metrics.PurchaseFailed(purchaseFailureReason(err))
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
+	s.publishEvent(c.Request.Context(), models.EventPurchaseCreated, purchase)
+	if product, err := s.store.GetProductById(c.Request.Context(), productId); err == nil {
+		metrics.PurchaseMade(quantity, product.Price)
+		s.publishEvent(c.Request.Context(), models.EventProductStockChanged, product)
+	}
+
 	c.JSON(http.StatusOK, models.Response{Message: "purchase successfully made"})
 }
 
 func (s *Server) handleGetUserPurchases(c *gin.Context) {
 	userId := c.MustGet("id").(int)
 
-	purchases, err := s.store.GetUserPurchases(userId)
+	purchases, err := s.store.GetUserPurchases(c.Request.Context(), userId)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, purchases)
+	c.JSON(http.StatusOK, newPurchaseResponses(purchases))
 }
 
 func (s *Server) handleGetProductPurchases(c *gin.Context) {
@@ -51,11 +69,24 @@ func (s *Server) handleGetProductPurchases(c *gin.Context) {
 		return
 	}
 
-	purchases, err := s.store.GetProductPurchases(productId)
+	purchases, err := s.store.GetProductPurchases(c.Request.Context(), productId)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, purchases)
+	c.JSON(http.StatusOK, newPurchaseResponses(purchases))
+}
+
+func purchaseFailureReason(err error) string {
+	switch {
+	case errors.Is(err, models.ErrNotEnoughProducts):
+		return "out_of_stock"
+	case errors.Is(err, models.ErrProductNotFound):
+		return "product_not_found"
+	case errors.Is(err, models.ErrInvalidQuantity):
+		return "invalid_request"
+	default:
+		return "internal_error"
+	}
 }
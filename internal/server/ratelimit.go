@@ -0,0 +1,114 @@
+package server
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/ratelimit"
+)
+
+// Identities that requests are limited by.
+const (
+	identityAnonymous = "ip"
+	identityUser      = "user"
+	identityAdmin     = "admin"
+	identityAPIKey    = "apikey"
+)
+
+// rateLimitPolicies are the limits of every route group by identity. The
+// auth group covers the endpoints that check passwords, tokens and codes.
+var rateLimitPolicies = map[string]map[string]ratelimit.Policy{
+	"auth": {
+		identityAnonymous: {Limit: 10, Period: time.Minute, Burst: 10},
+		identityUser:      {Limit: 10, Period: time.Minute, Burst: 10},
+		identityAdmin:     {Limit: 10, Period: time.Minute, Burst: 10},
+		identityAPIKey:    {Limit: 10, Period: time.Minute, Burst: 10},
+	},
+	"api": {
+		identityAnonymous: {Limit: 60, Period: time.Minute, Burst: 30},
+		identityUser:      {Limit: 300, Period: time.Minute, Burst: 100},
+		identityAdmin:     {Limit: 1200, Period: time.Minute, Burst: 300},
+		identityAPIKey:    {Limit: 1200, Period: time.Minute, Burst: 300},
+	},
+	"admin": {
+		identityAnonymous: {Limit: 30, Period: time.Minute, Burst: 10},
+		identityUser:      {Limit: 30, Period: time.Minute, Burst: 10},
+		identityAdmin:     {Limit: 1200, Period: time.Minute, Burst: 300},
+		identityAPIKey:    {Limit: 1200, Period: time.Minute, Burst: 300},
+	},
+}
+
+// RateLimit takes a token from the bucket of the caller in the given route
+// group and rejects the request with 429 when it is empty. It runs before
+// authentication, so the caller is identified by a valid token or API key if
+// there is one and by the client address otherwise.
+func RateLimit(s *Server, group string) gin.HandlerFunc {
+	policies := rateLimitPolicies[group]
+
+	return func(c *gin.Context) {
+		if s.limiter == nil {
+			c.Next()
+			return
+		}
+
+		identity, subject := s.rateLimitIdentity(c)
+		policy := policies[identity]
+
+		result, err := s.limiter.Take(c.Request.Context(), group+":"+identity+":"+subject, policy)
+		if err != nil {
+			// Failing open keeps the API up when the shared store is down.
+			slog.ErrorContext(c.Request.Context(), "rate limit store failed", "group", group, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", policy.String())
+		c.Header("RateLimit-Limit", strconv.Itoa(policy.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(result.Reset))
+
+		if !result.Allowed {
+			c.Header("Retry-After", ceilSeconds(result.RetryAfter))
+			c.JSON(http.StatusTooManyRequests, models.Response{Message: "rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (s *Server) rateLimitIdentity(c *gin.Context) (string, string) {
+	// Only a valid key gets its own bucket, made up ones would let a client
+	// start over with every request.
+	if secret, ok := apiKeyFromHeader(c); ok {
+		if key, err := s.apiKey(c, secret); err == nil {
+			return identityAPIKey, strconv.Itoa(key.Id)
+		}
+
+		return identityAnonymous, c.ClientIP()
+	}
+
+	if tokenString := c.GetHeader("Authorization"); tokenString != "" {
+		if claims, err := s.keys.Parse(tokenString); err == nil {
+			if id, ok := claims["id"].(float64); ok {
+				if role, _ := claims["role"].(string); role == models.RoleAdmin {
+					return identityAdmin, strconv.Itoa(int(id))
+				}
+
+				return identityUser, strconv.Itoa(int(id))
+			}
+		}
+	}
+
+	return identityAnonymous, c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/ratelimit"
+)
+
+// TestRateLimitAPIKeys checks that every API key has its own bucket and
+// that invalid keys count against the address of the client.
+func TestRateLimitAPIKeys(t *testing.T) {
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin}
+
+	keys := []string{}
+	for i := 1; i <= 2; i++ {
+		secret, prefix := newAPIKey()
+		store.apiKeys = append(store.apiKeys, models.APIKey{Id: i, Prefix: prefix, KeyHash: hashToken(secret), Scopes: []string{models.ScopeCatalogRead}, CreatedBy: 1})
+		keys = append(keys, secret)
+	}
+
+	s := newTestServer(t, store)
+	s.limiter = ratelimit.NewMemoryStore()
+	handler := s.routes()
+
+	remaining := func(token string) int {
+		t.Helper()
+
+		w := do(t, handler, http.MethodGet, "/products/list", token, "")
+		n, err := strconv.Atoi(w.Header().Get("RateLimit-Remaining"))
+		if err != nil {
+			t.Fatalf("RateLimit-Remaining = %q", w.Header().Get("RateLimit-Remaining"))
+		}
+
+		return n
+	}
+
+	keyBurst := rateLimitPolicies["api"][identityAPIKey].Burst
+	anonymousBurst := rateLimitPolicies["api"][identityAnonymous].Burst
+
+	steps := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"first key", keys[0], keyBurst - 1},
+		{"first key again", "ApiKey " + keys[0], keyBurst - 2},
+		{"second key", keys[1], keyBurst - 1},
+		{"made up key", apiKeyPrefix + "nosuchkey_secret", anonymousBurst - 1},
+		{"wrong secret", keys[0] + "x", anonymousBurst - 2},
+		{"no key", "", anonymousBurst - 3},
+	}
+
+	for _, step := range steps {
+		if got := remaining(step.token); got != step.want {
+			t.Errorf("%s: %d remaining, want %d", step.name, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func (s *Server) handleGetProductReviews(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	reviews, err := s.store.GetProductReviews(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reviews)
+}
+
+// handleAddReview accepts a review only from a user who bought the product.
+func (s *Server) handleAddReview(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	review := models.Review{}
+	if err := c.ShouldBindBodyWithJSON(&review); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&review); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	purchases, err := s.store.GetUserPurchases(c.Request.Context(), userId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	bought := slices.ContainsFunc(purchases, func(purchase models.Purchase) bool {
+		return purchase.ProductId == id
+	})
+	if !bought {
+		c.JSON(http.StatusForbidden, models.Response{Message: models.ErrNotPurchased.Error()})
+		return
+	}
+
+	review.ProductId = id
+	review.UserId = userId
+	review, err = s.store.AddReview(c.Request.Context(), review)
+	if errors.Is(err, models.ErrReviewExists) {
+		c.JSON(http.StatusConflict, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "review.create", "review:"+strconv.Itoa(review.Id), nil, review)
+
+	c.JSON(http.StatusOK, review)
+}
+
+func (s *Server) handleUpdateReview(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	id, reviewId, ok := reviewParams(c)
+	if !ok {
+		return
+	}
+
+	review := models.Review{}
+	if err := c.ShouldBindBodyWithJSON(&review); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&review); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	review, err := s.store.UpdateReview(c.Request.Context(), id, reviewId, userId, review.Rating, review.Text)
+	if errors.Is(err, models.ErrReviewNotFound) {
+		c.JSON(http.StatusNotFound, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "review.update", "review:"+strconv.Itoa(reviewId), nil, review)
+
+	c.JSON(http.StatusOK, review)
+}
+
+func (s *Server) handleDeleteReview(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	id, reviewId, ok := reviewParams(c)
+	if !ok {
+		return
+	}
+
+	err := s.store.DeleteReview(c.Request.Context(), id, reviewId, userId)
+	if errors.Is(err, models.ErrReviewNotFound) {
+		c.JSON(http.StatusNotFound, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "review.delete", "review:"+strconv.Itoa(reviewId), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "review successfully deleted"})
+}
+
+func (s *Server) handleGetReviews(c *gin.Context) {
+	reviews, err := s.store.GetReviews(c.Request.Context(), c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, reviews)
+}
+
+type reviewStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=visible flagged hidden"`
+}
+
+// handleSetReviewStatus lets admins hide a review or flag it for a closer look.
+func (s *Server) handleSetReviewStatus(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	request := reviewStatusRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	err = s.store.SetReviewStatus(c.Request.Context(), id, request.Status)
+	if errors.Is(err, models.ErrReviewNotFound) {
+		c.JSON(http.StatusNotFound, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "review.moderate", "review:"+strconv.Itoa(id), nil, request)
+
+	c.JSON(http.StatusOK, models.Response{Message: "review status successfully updated"})
+}
+
+// reviewParams parses the :id and :reviewId parameters. On failure the
+// response is written and false is returned.
+func reviewParams(c *gin.Context) (int, int, bool) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return 0, 0, false
+	}
+
+	reviewId, err := ParseId(c.Param("reviewId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return 0, 0, false
+	}
+
+	return id, reviewId, true
+}
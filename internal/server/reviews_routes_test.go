@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func newReviewTestServer(t *testing.T) (*Server, *fakeStorage, http.Handler) {
+	t.Helper()
+
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin}
+	store.users[2] = models.User{Id: 2, Username: "alice", Role: models.RoleUser}
+	store.users[3] = models.User{Id: 3, Username: "bob", Role: models.RoleUser}
+	store.products[1] = models.Product{Id: 1, Sku: "KETTLE", Name: "Kettle", Price: 100, Quantity: 3, Version: 1}
+	store.products[2] = models.Product{Id: 2, Sku: "MUG", Name: "Mug", Price: 10, Quantity: 3, Version: 1}
+	store.purchases = []models.Purchase{
+		{Id: 1, UserId: 2, ProductId: 1, Quantity: 1},
+		{Id: 2, UserId: 3, ProductId: 1, Quantity: 1},
+	}
+
+	s := newTestServer(t, store)
+	return s, store, s.routes()
+}
+
+func TestAddReviewRules(t *testing.T) {
+	s, store, handler := newReviewTestServer(t)
+	alice := userToken(t, s, 2)
+
+	steps := []struct {
+		name    string
+		target  string
+		status  int
+		message string
+	}{
+		{"not bought", "/products/2/reviews", http.StatusForbidden, models.ErrNotPurchased.Error()},
+		{"bought", "/products/1/reviews", http.StatusOK, ""},
+		{"second review", "/products/1/reviews", http.StatusConflict, models.ErrReviewExists.Error()},
+	}
+
+	for _, step := range steps {
+		w := do(t, handler, http.MethodPost, step.target, alice, `{"rating": 4, "text": "Boils fast"}`)
+		if w.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d, body %s", step.name, w.Code, step.status, w.Body)
+		}
+
+		if step.message == "" {
+			continue
+		}
+
+		response := models.Response{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Message != step.message {
+			t.Errorf("%s: message = %q, want %q", step.name, response.Message, step.message)
+		}
+	}
+
+	if len(store.reviews) != 1 || store.reviews[0].UserId != 2 || store.reviews[0].ProductId != 1 {
+		t.Errorf("reviews = %+v, want the one of alice for the kettle", store.reviews)
+	}
+}
+
+func TestHiddenReviewsLeaveRating(t *testing.T) {
+	s, store, handler := newReviewTestServer(t)
+
+	for id, rating := range map[int]string{2: "5", 3: "1"} {
+		if w := do(t, handler, http.MethodPost, "/products/1/reviews", userToken(t, s, id), `{"rating": `+rating+`}`); w.Code != http.StatusOK {
+			t.Fatalf("review of user %d: status = %d, body %s", id, w.Code, w.Body)
+		}
+	}
+
+	check := func(name string, rating float64, reviews int) {
+		t.Helper()
+
+		w := do(t, handler, http.MethodGet, "/products/1", userToken(t, s, 2), "")
+		product := productResponse{}
+		if err := json.Unmarshal(w.Body.Bytes(), &product); err != nil {
+			t.Fatal(err)
+		}
+
+		if product.Rating != rating || product.ReviewCount != reviews {
+			t.Errorf("%s: rating = %v of %d reviews, want %v of %d", name, product.Rating, product.ReviewCount, rating, reviews)
+		}
+
+		w = do(t, handler, http.MethodGet, "/products/1/reviews", userToken(t, s, 2), "")
+		listed := []models.Review{}
+		if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(listed) != reviews {
+			t.Errorf("%s: %d reviews listed, want %d", name, len(listed), reviews)
+		}
+	}
+
+	check("both visible", 3, 2)
+
+	bobsReview := 0
+	for _, review := range store.reviews {
+		if review.UserId == 3 {
+			bobsReview = review.Id
+		}
+	}
+
+	for _, step := range []struct {
+		status  string
+		rating  float64
+		reviews int
+	}{
+		{models.ReviewFlagged, 3, 2},
+		{models.ReviewHidden, 5, 1},
+		{models.ReviewVisible, 3, 2},
+	} {
+		target := "/admin/reviews/" + strconv.Itoa(bobsReview) + "/status"
+		if w := do(t, handler, http.MethodPut, target, adminToken(t, s, 1), `{"status": "`+step.status+`"}`); w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", step.status, w.Code, w.Body)
+		}
+
+		check(step.status, step.rating, step.reviews)
+	}
+}
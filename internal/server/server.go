@@ -1,69 +1,340 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
 	jwt "github.com/golang-jwt/jwt"
+	"github.com/ursuldaniel/go-market/internal/config"
 	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/logging"
+	"github.com/ursuldaniel/go-market/internal/mail"
+	"github.com/ursuldaniel/go-market/internal/media"
+	"github.com/ursuldaniel/go-market/internal/metrics"
+	"github.com/ursuldaniel/go-market/internal/notify"
+	"github.com/ursuldaniel/go-market/internal/oidc"
+	"github.com/ursuldaniel/go-market/internal/ratelimit"
+	"github.com/ursuldaniel/go-market/internal/signing"
+	"github.com/ursuldaniel/go-market/internal/tracing"
+	"github.com/ursuldaniel/go-market/internal/validation"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type Storage interface {
-	RegisterUser(username, password, email string) error
-	LoginUser(username, password string) (int, error)
-	GetUserProfile(userId int) (models.User, error)
-
-	AddProduct(name, description string, price, quantity int) error
-	GetAllProducts() ([]models.Product, error)
-	GetProductById(productId int) (models.Product, error)
-	UpdateProduct(productId int, name, description string, price, quantity int) error
-	DeleteProduct(productId int) error
-
-	MakePurchase(userID, productID, quantity int) error
-	GetUserPurchases(userID int) ([]models.Purchase, error)
-	GetProductPurchases(productID int) ([]models.Purchase, error)
+	RegisterUser(ctx context.Context, username, password, email string) error
+	LoginUser(ctx context.Context, username, password string) (int, error)
+	GetUserProfile(ctx context.Context, userId int) (models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	UpdateUserProfile(ctx context.Context, userId int, username, email string) error
+	ChangePassword(ctx context.Context, userId int, currentPassword, newPassword string) error
+	DeleteUser(ctx context.Context, userId int) error
+	GetUsers(ctx context.Context, filter models.UserFilter) ([]models.User, int, error)
+	GetUserAuth(ctx context.Context, userId int) (bool, time.Time, error)
+	SetUserRole(ctx context.Context, userId int, role string) error
+	SetUserDisabled(ctx context.Context, userId int, disabled bool) error
+	RevokeUserTokens(ctx context.Context, userId int) error
+	ForcePasswordReset(ctx context.Context, userId int) error
+	AddUserToken(ctx context.Context, userId int, purpose, tokenHash string, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, tokenHash string) (int, error)
+	ResetPassword(ctx context.Context, tokenHash, password string) (int, error)
+	LoginExternalUser(ctx context.Context, identity models.ExternalIdentity) (models.User, bool, error)
+	GetUserIdentities(ctx context.Context, userId int) ([]models.ExternalIdentity, error)
+
+	SetTOTPSecret(ctx context.Context, userId int, secret string) error
+	GetTOTP(ctx context.Context, userId int) (string, bool, int64, error)
+	UseTOTPStep(ctx context.Context, userId int, step int64) error
+	EnableTOTP(ctx context.Context, userId int, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userId int) error
+	ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userId int, codeHash string) error
+
+	RecordLoginFailure(ctx context.Context, key string, resetAfter time.Duration) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	GetLoginLock(ctx context.Context, keys []string) (time.Time, error)
+	ClearLoginFailures(ctx context.Context, keys []string) error
+
+	AddProduct(ctx context.Context, sku, name, description string, price, quantity int) (int, error)
+	GetAllProducts(ctx context.Context) ([]models.Product, error)
+	GetProductById(ctx context.Context, productId int) (models.Product, error)
+	UpdateProduct(ctx context.Context, productId, version int, sku, name, description string, price, quantity int) (int, error)
+	DeleteProduct(ctx context.Context, productId, version int) error
+	GetDeletedProducts(ctx context.Context) ([]models.Product, error)
+	RestoreProduct(ctx context.Context, productId int) error
+	UpsertProducts(ctx context.Context, products []models.Product, atomic, dryRun bool) ([]models.ImportResult, error)
+	StreamProducts(ctx context.Context, fn func(product models.Product) error) error
+
+	MakePurchase(ctx context.Context, userID, productID, quantity int) (models.Purchase, error)
+	GetUserPurchases(ctx context.Context, userID int) ([]models.Purchase, error)
+	GetProductPurchases(ctx context.Context, productID int) ([]models.Purchase, error)
+	GetPurchaseSummary(ctx context.Context, userId int) (models.PurchaseSummary, error)
+
+	AddWebhook(ctx context.Context, url, secret string, events []string) error
+	GetAllWebhooks(ctx context.Context) ([]models.Webhook, error)
+	GetWebhookById(ctx context.Context, webhookId int) (models.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhookId int, url, secret string, events []string, active bool) error
+	DeleteWebhook(ctx context.Context, webhookId int) error
+	EnqueueWebhookEvent(ctx context.Context, event, payload string) error
+	GetWebhookDeliveries(ctx context.Context, webhookId int) ([]models.WebhookDelivery, error)
+	RedeliverWebhookDelivery(ctx context.Context, deliveryId int) error
+
+	AddAuditEntry(ctx context.Context, entry models.AuditEntry) error
+	GetAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error)
+	VerifyAuditChain(ctx context.Context) (int, error)
+
+	AddProductImage(ctx context.Context, image models.ProductImage) (models.ProductImage, error)
+	GetProductImages(ctx context.Context, productId int) ([]models.ProductImage, error)
+	GetProductImage(ctx context.Context, productId, imageId int) (models.ProductImage, error)
+	DeleteProductImage(ctx context.Context, productId, imageId int) (models.ProductImage, error)
+	ReorderProductImages(ctx context.Context, productId int, imageIds []int) error
+	SetPrimaryProductImage(ctx context.Context, productId, imageId int) error
+
+	AddReview(ctx context.Context, review models.Review) (models.Review, error)
+	GetProductReviews(ctx context.Context, productId int) ([]models.Review, error)
+	GetUserReviews(ctx context.Context, userId int) ([]models.Review, error)
+	GetReviews(ctx context.Context, status string) ([]models.Review, error)
+	UpdateReview(ctx context.Context, productId, reviewId, userId, rating int, text string) (models.Review, error)
+	DeleteReview(ctx context.Context, productId, reviewId, userId int) error
+	SetReviewStatus(ctx context.Context, reviewId int, status string) error
+
+	AddWishlistItem(ctx context.Context, userId, productId int) error
+	RemoveWishlistItem(ctx context.Context, userId, productId int) error
+	GetWishlist(ctx context.Context, userId int) ([]models.Product, error)
+	SubscribeStock(ctx context.Context, userId, productId int) error
+	UnsubscribeStock(ctx context.Context, userId, productId int) error
+	GetStockSubscriptions(ctx context.Context, userId int) ([]int, error)
+	TakeStockSubscribers(ctx context.Context, productId int) ([]models.User, error)
+
+	AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, error)
+	TouchAPIKey(ctx context.Context, keyId int) error
+	RevokeAPIKey(ctx context.Context, keyId int) error
+
+	GetNotifications(ctx context.Context, userId int, unreadOnly bool) ([]models.Notification, error)
+	MarkNotificationRead(ctx context.Context, userId, notificationId int) error
+
+	Ready(ctx context.Context) error
 }
 
 type Server struct {
-	addr     string
-	store    Storage
-	validate *validator.Validate
+	addr             string
+	metricsAddr      string
+	secret           string
+	requireAdmin2FA  bool
+	maxLoginFailures int
+	maxIPFailures    int
+	loginLockout     time.Duration
+	shutdownTimeout  time.Duration
+	drainDelay       time.Duration
+	store            Storage
+	blobs            media.BlobStore
+	mailer           mail.Mailer
+	notifier         notify.Notifier
+	limiter          ratelimit.Store
+	idp              *oidc.Provider
+	keys             *signing.KeySet
+	validate         *validator.Validate
+	draining         atomic.Bool
+	background       sync.WaitGroup
 }
 
-func NewServer(addr string, store Storage) *Server {
+// NewServer creates a server listening on cfg.ListenAddr. Metrics are served on a separate
+// cfg.MetricsAddr listener, or on /metrics behind admin auth when it is empty.
+func NewServer(cfg config.Config, store Storage, blobs media.BlobStore, mailer mail.Mailer, notifier notify.Notifier, limiter ratelimit.Store, idp *oidc.Provider, keys *signing.KeySet) *Server {
 	return &Server{
-		addr:     addr,
-		store:    store,
-		validate: validator.New(),
+		addr:             cfg.ListenAddr,
+		metricsAddr:      cfg.MetricsAddr,
+		secret:           cfg.SecretKey,
+		requireAdmin2FA:  cfg.RequireAdmin2FA,
+		maxLoginFailures: cfg.MaxLoginFailures,
+		maxIPFailures:    cfg.MaxIPFailures,
+		loginLockout:     cfg.LoginLockout.Duration,
+		shutdownTimeout:  cfg.ShutdownTimeout.Duration,
+		drainDelay:       cfg.DrainDelay.Duration,
+		store:            store,
+		blobs:            blobs,
+		mailer:           mailer,
+		notifier:         notifier,
+		limiter:          limiter,
+		idp:              idp,
+		keys:             keys,
+		validate:         validation.New(),
 	}
 }
 
-func (s *Server) Run() error {
-	app := gin.Default()
+// Run serves requests until ctx is cancelled. It then fails /readyz for
+// drainDelay, so that load balancers stop sending traffic, before it stops
+// accepting new connections and waits up to shutdownTimeout for in-flight
+// requests.
+func (s *Server) Run(ctx context.Context) error {
+	servers := []*http.Server{{Addr: s.addr, Handler: s.routes()}}
+	if s.metricsAddr != "" {
+		servers = append(servers, &http.Server{Addr: s.metricsAddr, Handler: metrics.Handler()})
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			slog.Info("listening", "addr", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(srv)
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-errs:
+	}
+
+	s.draining.Store(true)
+	if runErr == nil && s.drainDelay > 0 {
+		slog.Info("draining", "delay", s.drainDelay.String())
+		time.Sleep(s.drainDelay)
+	}
+
+	slog.Info("shutting down", "timeout", s.shutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil && runErr == nil {
+			runErr = err
+		}
+	}
 
-	usersRoutes := app.Group("/users")
-	usersRoutes.POST("/register", s.handleRegisterUser)
-	usersRoutes.POST("/login", s.handleLoginUser)
+	// Notifications and emails started by requests are finished before the
+	// storage is closed, as long as the shutdown timeout allows.
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		slog.Warn("background tasks didn't finish before the shutdown timeout")
+	}
+
+	return runErr
+}
+
+func (s *Server) routes() http.Handler {
+	app := gin.New()
+	app.Use(otelgin.Middleware(tracing.ServiceName), RequestId(), RequestLogger(), gin.Recovery())
+
+	app.GET("/healthz", s.handleHealthz)
+	app.GET("/readyz", s.handleReadyz)
+	app.GET("/.well-known/jwks.json", s.handleJWKS)
+
+	usersRoutes := app.Group("/users", RateLimit(s, "api"))
+	usersRoutes.POST("/register", RateLimit(s, "auth"), s.handleRegisterUser)
+	usersRoutes.POST("/login", RateLimit(s, "auth"), s.handleLoginUser)
+	usersRoutes.POST("/login/2fa", RateLimit(s, "auth"), s.handleLoginSecondFactor)
+	usersRoutes.GET("/oidc/login", RateLimit(s, "auth"), s.handleOIDCLogin)
+	usersRoutes.GET("/oidc/callback", RateLimit(s, "auth"), s.handleOIDCCallback)
+	usersRoutes.POST("/verify", RateLimit(s, "auth"), s.handleVerifyEmail)
+	usersRoutes.POST("/verify/resend", JWTAuthUser(s), s.handleResendVerification)
+	usersRoutes.POST("/password/forgot", RateLimit(s, "auth"), s.handleForgotPassword)
+	usersRoutes.POST("/password/reset", RateLimit(s, "auth"), s.handleResetPassword)
+	usersRoutes.POST("/2fa/enroll", JWTAuthUser(s), s.handleEnrollTOTP)
+	usersRoutes.GET("/2fa/qr", JWTAuthUser(s), s.handleTOTPQRCode)
+	usersRoutes.POST("/2fa/activate", JWTAuthUser(s), s.handleActivateTOTP)
+	usersRoutes.POST("/2fa/disable", JWTAuthUser(s), s.handleDisableTOTP)
+	usersRoutes.POST("/2fa/recovery-codes", JWTAuthUser(s), s.handleRegenerateRecoveryCodes)
 	usersRoutes.GET("/:id", JWTAuthAdmin(s), s.handleGetUserProfile)
 	usersRoutes.GET("/profile", JWTAuthUser(s), s.handleProfile)
+	usersRoutes.PATCH("/profile", JWTAuthUser(s), s.handleUpdateProfile)
+	usersRoutes.DELETE("/profile", JWTAuthUser(s), s.handleDeleteProfile)
+	usersRoutes.GET("/profile/export", JWTAuthUser(s), s.handleExportProfile)
+	usersRoutes.POST("/password", RateLimit(s, "auth"), JWTAuthUser(s), s.handleChangePassword)
+	usersRoutes.GET("/wishlist", JWTAuthUser(s), s.handleGetWishlist)
+	usersRoutes.PUT("/wishlist/:id", JWTAuthUser(s), s.handleAddWishlistItem)
+	usersRoutes.DELETE("/wishlist/:id", JWTAuthUser(s), s.handleRemoveWishlistItem)
+	usersRoutes.GET("/notifications", JWTAuthUser(s), s.handleGetNotifications)
+	usersRoutes.POST("/notifications/:id/read", JWTAuthUser(s), s.handleMarkNotificationRead)
 
-	productsRoutes := app.Group("/products", JWTAuthUser(s))
+	productsRoutes := app.Group("/products", RateLimit(s, "api"), JWTAuthUser(s))
 	productsRoutes.POST("/", JWTAuthAdmin(s), s.handleAddProduct)
 	productsRoutes.GET("/list", s.handleGetAllProducts)
 	productsRoutes.GET("/:id", s.handleGetProductById)
 	productsRoutes.PUT("/:id", JWTAuthAdmin(s), s.handleUpdateProduct)
+	productsRoutes.PATCH("/:id", JWTAuthAdmin(s), s.handlePatchProduct)
 	productsRoutes.DELETE(":id", JWTAuthAdmin(s), s.handleDeleteProduct)
+	productsRoutes.POST("/:id/images", JWTAuthAdmin(s), s.handleUploadProductImage)
+	productsRoutes.GET("/:id/images", s.handleGetProductImages)
+	productsRoutes.GET("/:id/images/:imageId", s.handleGetProductImage)
+	productsRoutes.DELETE("/:id/images/:imageId", JWTAuthAdmin(s), s.handleDeleteProductImage)
+	productsRoutes.PUT("/:id/images/order", JWTAuthAdmin(s), s.handleReorderProductImages)
+	productsRoutes.POST("/:id/images/:imageId/primary", JWTAuthAdmin(s), s.handleSetPrimaryProductImage)
+	productsRoutes.GET("/:id/reviews", s.handleGetProductReviews)
+	productsRoutes.POST("/:id/reviews", s.handleAddReview)
+	productsRoutes.PUT("/:id/reviews/:reviewId", s.handleUpdateReview)
+	productsRoutes.DELETE("/:id/reviews/:reviewId", s.handleDeleteReview)
+	productsRoutes.POST("/:id/notify", s.handleSubscribeStock)
+	productsRoutes.DELETE("/:id/notify", s.handleUnsubscribeStock)
 
-	purchasesRoutes := app.Group("/purchases", JWTAuthUser(s))
+	purchasesRoutes := app.Group("/purchases", RateLimit(s, "api"), JWTAuthUser(s))
 	purchasesRoutes.POST("/:id", s.handleMakePurchase)
 	purchasesRoutes.GET("/list", s.handleGetUserPurchases)
 	purchasesRoutes.GET("/list/:id", JWTAuthAdmin(s), s.handleGetProductPurchases)
 
-	return app.Run(s.addr)
+	adminRoutes := app.Group("/admin", RateLimit(s, "admin"), JWTAuthAdmin(s))
+	adminRoutes.GET("/users", s.handleGetUsers)
+	adminRoutes.POST("/users/:id/unlock", s.handleUnlockUser)
+	adminRoutes.POST("/users/:id/disable", s.handleDisableUser)
+	adminRoutes.POST("/users/:id/enable", s.handleEnableUser)
+	adminRoutes.POST("/users/:id/logout", s.handleLogoutUser)
+	adminRoutes.POST("/users/:id/password-reset", s.handleForcePasswordReset)
+	adminRoutes.PUT("/users/:id/role", s.handleSetUserRole)
+	adminRoutes.GET("/users/:id/purchases", s.handleGetPurchaseSummary)
+	adminRoutes.POST("/api-keys", s.handleAddAPIKey)
+	adminRoutes.GET("/api-keys", s.handleGetAPIKeys)
+	adminRoutes.DELETE("/api-keys/:id", s.handleRevokeAPIKey)
+	adminRoutes.POST("/webhooks", s.handleAddWebhook)
+	adminRoutes.GET("/webhooks/list", s.handleGetAllWebhooks)
+	adminRoutes.GET("/webhooks/:id", s.handleGetWebhookById)
+	adminRoutes.PUT("/webhooks/:id", s.handleUpdateWebhook)
+	adminRoutes.DELETE("/webhooks/:id", s.handleDeleteWebhook)
+	adminRoutes.GET("/webhooks/:id/deliveries", s.handleGetWebhookDeliveries)
+	adminRoutes.POST("/deliveries/:id/redeliver", s.handleRedeliverWebhookDelivery)
+	adminRoutes.POST("/products/import", s.handleImportProducts)
+	adminRoutes.GET("/products/export", s.handleExportProducts)
+	adminRoutes.GET("/products/deleted", s.handleGetDeletedProducts)
+	adminRoutes.POST("/products/:id/restore", s.handleRestoreProduct)
+	adminRoutes.GET("/reviews", s.handleGetReviews)
+	adminRoutes.PUT("/reviews/:id/status", s.handleSetReviewStatus)
+	adminRoutes.GET("/audit", s.handleGetAuditEntries)
+	adminRoutes.GET("/audit/verify", s.handleVerifyAuditChain)
+
+	if s.metricsAddr == "" {
+		app.GET("/metrics", JWTAuthAdmin(s), gin.WrapH(metrics.Handler()))
+	}
+
+	return app
+}
+
+// respondValidationError reports validator errors field by field.
+func respondValidationError(c *gin.Context, err error) {
+	fieldErrors := validation.FieldErrors(err)
+	if fieldErrors == nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, models.ValidationResponse{Message: "validation failed", Errors: fieldErrors})
 }
 
 func ParseId(idParam string) (int, error) {
@@ -75,106 +346,190 @@ func ParseId(idParam string) (int, error) {
 	return id, nil
 }
 
-func CreateUserToken(id int) (string, error) {
-	claims := &jwt.MapClaims{
-		"id":        id,
-		"expiresAt": time.Now().Add(time.Hour * 24).Unix(),
-	}
-
-	secret := os.Getenv("SECRET_KEY")
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+func CreateUserToken(keys *signing.KeySet, id int) (string, error) {
+	expiresAt := time.Now().Add(time.Hour * 24).Unix()
 
-	return token.SignedString([]byte(secret))
+	// exp lets other services that verify the token with the published keys
+	// check its expiry, expiresAt is kept for existing clients.
+	return keys.Sign(jwt.MapClaims{
+		"id":        id,
+		"iat":       time.Now().Unix(),
+		"exp":       expiresAt,
+		"expiresAt": expiresAt,
+	})
 }
 
 func JWTAuthUser(s *Server) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.Request.Header["Authorization"]
-		if tokenString == nil {
-			c.JSON(http.StatusUnauthorized, models.Response{Message: "Authorization token is missing"})
-			c.Abort()
+		if secret, ok := apiKeyFromHeader(c); ok {
+			if s.authenticateAPIKey(c, secret) {
+				c.Next()
+			}
 			return
 		}
 
-		token, err := jwt.Parse(tokenString[0], func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("SECRET_KEY")), nil
-		})
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, models.Response{Message: "Invalid or expired token"})
-			c.Abort()
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, models.Response{Message: "Invalid token claims"})
-			c.Abort()
-			return
-		}
+		// The queries made while authenticating belong to the span, the
+		// handler's go back to the request span.
+		ctx := c.Request.Context()
+		spanCtx, span := tracing.Tracer().Start(ctx, "JWTAuthUser")
+		c.Request = c.Request.WithContext(spanCtx)
 
-		id, ok := claims["id"].(float64)
+		ok := s.authenticateUser(c)
+		span.End()
+		c.Request = c.Request.WithContext(ctx)
 		if !ok {
-			c.JSON(http.StatusForbidden, models.Response{Message: "Unauthorized access to the account"})
-			c.Abort()
 			return
 		}
 
-		c.Set("id", int(id))
+		c.Request = c.Request.WithContext(logging.WithUserId(ctx, c.GetInt("id")))
 		c.Next()
 	}
 }
 
-func CreateAdminToken(id int) (string, error) {
-	claims := &jwt.MapClaims{
-		"id":        id,
-		"role":      "admin",
-		"expiresAt": time.Now().Add(time.Hour * 24).Unix(),
+// authenticateUser sets the id of the user of a valid token, or aborts the
+// request.
+func (s *Server) authenticateUser(c *gin.Context) bool {
+	claims, ok := parseTokenClaims(c, s.keys)
+	if !ok {
+		return false
+	}
+
+	id, ok := claims["id"].(float64)
+	if !ok {
+		c.JSON(http.StatusForbidden, models.Response{Message: "Unauthorized access to the account"})
+		c.Abort()
+		return false
+	}
+
+	if !s.checkTokenUser(c, claims, int(id)) {
+		return false
+	}
+
+	c.Set("id", int(id))
+	return true
+}
+
+// parseTokenClaims validates the token from the Authorization header and
+// aborts the request with 401 if it is missing or invalid.
+func parseTokenClaims(c *gin.Context, keys *signing.KeySet) (jwt.MapClaims, bool) {
+	tokenString := c.Request.Header["Authorization"]
+	if tokenString == nil {
+		c.JSON(http.StatusUnauthorized, models.Response{Message: "Authorization token is missing"})
+		c.Abort()
+		return nil, false
 	}
 
-	secret := os.Getenv("SECRET_KEY")
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	claims, err := keys.Parse(tokenString[0])
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.Response{Message: err.Error()})
+		c.Abort()
+		return nil, false
+	}
+
+	return claims, true
+}
+
+func CreateAdminToken(keys *signing.KeySet, id int) (string, error) {
+	expiresAt := time.Now().Add(time.Hour * 24).Unix()
 
-	return token.SignedString([]byte(secret))
+	return keys.Sign(jwt.MapClaims{
+		"id":        id,
+		"role":      "admin",
+		"iat":       time.Now().Unix(),
+		"exp":       expiresAt,
+		"expiresAt": expiresAt,
+	})
 }
 
 func JWTAuthAdmin(s *Server) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.Request.Header["Authorization"]
-		if tokenString == nil {
-			c.JSON(http.StatusUnauthorized, models.Response{Message: "Authorization token is missing"})
-			c.Abort()
+		if secret, ok := apiKeyFromHeader(c); ok {
+			if s.authenticateAPIKey(c, secret) {
+				c.Next()
+			}
 			return
 		}
 
-		token, err := jwt.Parse(tokenString[0], func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("SECRET_KEY")), nil
-		})
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, models.Response{Message: "Invalid or expired token"})
-			c.Abort()
-			return
-		}
+		ctx := c.Request.Context()
+		spanCtx, span := tracing.Tracer().Start(ctx, "JWTAuthAdmin")
+		c.Request = c.Request.WithContext(spanCtx)
 
-		claims, ok := token.Claims.(jwt.MapClaims)
+		ok := s.authenticateAdmin(c)
+		span.End()
+		c.Request = c.Request.WithContext(ctx)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, models.Response{Message: "Invalid token claims"})
-			c.Abort()
 			return
 		}
 
-		role, ok := claims["role"].(string)
-		if !ok {
-			c.JSON(http.StatusForbidden, models.Response{Message: "Unauthorized access to the account"})
-			c.Abort()
-			return
+		if id, ok := c.Get("id"); ok {
+			c.Request = c.Request.WithContext(logging.WithUserId(ctx, id.(int)))
 		}
 
-		if role != "admin" {
-			c.JSON(http.StatusForbidden, models.Response{Message: "Unauthorized access to the account"})
-			c.Abort()
-			return
+		c.Next()
+	}
+}
+
+// authenticateAdmin checks that the token has the admin role and sets the
+// id of its user, or aborts the request.
+func (s *Server) authenticateAdmin(c *gin.Context) bool {
+	claims, ok := parseTokenClaims(c, s.keys)
+	if !ok {
+		return false
+	}
+
+	role, ok := claims["role"].(string)
+	if !ok {
+		c.JSON(http.StatusForbidden, models.Response{Message: "Unauthorized access to the account"})
+		c.Abort()
+		return false
+	}
+
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, models.Response{Message: "Unauthorized access to the account"})
+		c.Abort()
+		return false
+	}
+
+	if id, ok := claims["id"].(float64); ok {
+		if !s.checkTokenUser(c, claims, int(id)) {
+			return false
 		}
 
-		c.Next()
+		c.Set("id", int(id))
 	}
+
+	return true
+}
+
+// checkTokenUser aborts the request if the user of the token has been
+// disabled or logged out after the token was issued.
+func (s *Server) checkTokenUser(c *gin.Context, claims jwt.MapClaims, id int) bool {
+	disabled, validAfter, err := s.store.GetUserAuth(c.Request.Context(), id)
+	if errors.Is(err, models.ErrUserNotFound) {
+		c.JSON(http.StatusUnauthorized, models.Response{Message: "Invalid or expired token"})
+		c.Abort()
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{Message: err.Error()})
+		c.Abort()
+		return false
+	}
+
+	if disabled {
+		c.JSON(http.StatusForbidden, models.Response{Message: models.ErrUserDisabled.Error()})
+		c.Abort()
+		return false
+	}
+
+	// Tokens without iat were issued before logouts existed. Both times are
+	// in whole seconds, a token of the second of the logout is revoked.
+	issuedAt, _ := claims["iat"].(float64)
+	if !validAfter.IsZero() && int64(issuedAt) <= validAfter.Unix() {
+		c.JSON(http.StatusUnauthorized, models.Response{Message: "token has been revoked"})
+		c.Abort()
+		return false
+	}
+
+	return true
 }
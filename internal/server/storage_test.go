@@ -0,0 +1,748 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/config"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/notify"
+	"github.com/ursuldaniel/go-market/internal/signing"
+	"github.com/ursuldaniel/go-market/internal/tracing"
+)
+
+// fakeStorage keeps what the tests need in memory. Methods a test reaches
+// without an implementation here panic on the nil embedded Storage.
+type fakeStorage struct {
+	Storage
+
+	mu          sync.Mutex
+	users       map[int]models.User
+	validAfter  map[int]time.Time
+	products    map[int]models.Product
+	subscribers map[int][]int
+	events      []string
+	audit       []models.AuditEntry
+	passwords   map[int]string
+	userTokens  map[string]*fakeToken
+	purchases   []models.Purchase
+	identities  []models.ExternalIdentity
+	apiKeys     []models.APIKey
+	webhooks    []models.Webhook
+	reviews     []models.Review
+	totp        map[int]*fakeTOTP
+	failures    map[string]int
+	locks       map[string]time.Time
+}
+
+// fakeTOTP is the two-factor state of a user, recovery codes are keyed by
+// their hash and true once used.
+type fakeTOTP struct {
+	secret        string
+	enabled       bool
+	lastStep      int64
+	recoveryCodes map[string]bool
+}
+
+// fakeToken is a row of user_tokens, keyed by the token hash.
+type fakeToken struct {
+	userId    int
+	purpose   string
+	expiresAt time.Time
+	used      bool
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		users:       map[int]models.User{},
+		validAfter:  map[int]time.Time{},
+		products:    map[int]models.Product{},
+		subscribers: map[int][]int{},
+		passwords:   map[int]string{},
+		userTokens:  map[string]*fakeToken{},
+		totp:        map[int]*fakeTOTP{},
+		failures:    map[string]int{},
+		locks:       map[string]time.Time{},
+	}
+}
+
+// query stands in for a database query: like the query tracer of the
+// storage, it opens a span under the context of the caller.
+func (f *fakeStorage) query(ctx context.Context, method string) func() {
+	_, span := tracing.Tracer().Start(ctx, "storage."+method)
+	f.mu.Lock()
+
+	return func() {
+		f.mu.Unlock()
+		span.End()
+	}
+}
+
+func (f *fakeStorage) GetUserAuth(ctx context.Context, userId int) (bool, time.Time, error) {
+	defer f.query(ctx, "GetUserAuth")()
+
+	user, ok := f.users[userId]
+	if !ok {
+		return false, time.Time{}, models.ErrUserNotFound
+	}
+
+	return user.Disabled, f.validAfter[userId], nil
+}
+
+func (f *fakeStorage) GetProductById(ctx context.Context, productId int) (models.Product, error) {
+	defer f.query(ctx, "GetProductById")()
+
+	product, ok := f.products[productId]
+	if !ok {
+		return models.Product{}, models.ErrProductNotFound
+	}
+
+	return f.withRating(product), nil
+}
+
+// withRating sets the rating of the product from its reviews that aren't
+// hidden, like the rating join of the storage.
+func (f *fakeStorage) withRating(product models.Product) models.Product {
+	sum, count := 0, 0
+	for _, review := range f.reviews {
+		if review.ProductId == product.Id && review.Status != models.ReviewHidden {
+			sum += review.Rating
+			count++
+		}
+	}
+
+	product.Rating, product.ReviewCount = 0, count
+	if count > 0 {
+		product.Rating = math.Round(float64(sum)/float64(count)*100) / 100
+	}
+
+	return product
+}
+
+func (f *fakeStorage) AddReview(ctx context.Context, review models.Review) (models.Review, error) {
+	defer f.query(ctx, "AddReview")()
+
+	for _, other := range f.reviews {
+		if other.ProductId == review.ProductId && other.UserId == review.UserId {
+			return models.Review{}, models.ErrReviewExists
+		}
+	}
+
+	review.Id, review.Status = len(f.reviews)+1, models.ReviewVisible
+	f.reviews = append(f.reviews, review)
+
+	return review, nil
+}
+
+func (f *fakeStorage) GetProductReviews(ctx context.Context, productId int) ([]models.Review, error) {
+	defer f.query(ctx, "GetProductReviews")()
+
+	reviews := []models.Review{}
+	for _, review := range f.reviews {
+		if review.ProductId == productId && review.Status != models.ReviewHidden {
+			reviews = append(reviews, review)
+		}
+	}
+
+	return reviews, nil
+}
+
+func (f *fakeStorage) SetReviewStatus(ctx context.Context, reviewId int, status string) error {
+	defer f.query(ctx, "SetReviewStatus")()
+
+	for i := range f.reviews {
+		if f.reviews[i].Id == reviewId {
+			f.reviews[i].Status = status
+			return nil
+		}
+	}
+
+	return models.ErrReviewNotFound
+}
+
+// UpsertProducts matches products by SKU like the storage does and reports
+// the stock before the import.
+func (f *fakeStorage) UpsertProducts(ctx context.Context, products []models.Product, atomic, dryRun bool) ([]models.ImportResult, error) {
+	defer f.query(ctx, "UpsertProducts")()
+
+	results := []models.ImportResult{}
+	for _, product := range products {
+		result := models.ImportResult{Sku: product.Sku, Action: models.ImportCreated, Quantity: product.Quantity}
+		for id, old := range f.products {
+			if old.Sku == product.Sku {
+				product.Id, product.Version = id, old.Version+1
+				result.Action, result.PreviousQuantity = models.ImportUpdated, old.Quantity
+			}
+		}
+
+		if product.Id == 0 {
+			product.Id, product.Version = len(f.products)+1, 1
+		}
+
+		result.Id = product.Id
+		if !dryRun {
+			f.products[product.Id] = product
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (f *fakeStorage) TakeStockSubscribers(ctx context.Context, productId int) ([]models.User, error) {
+	defer f.query(ctx, "TakeStockSubscribers")()
+
+	users := []models.User{}
+	for _, userId := range f.subscribers[productId] {
+		users = append(users, f.users[userId])
+	}
+	delete(f.subscribers, productId)
+
+	return users, nil
+}
+
+func (f *fakeStorage) EnqueueWebhookEvent(ctx context.Context, event, payload string) error {
+	defer f.query(ctx, "EnqueueWebhookEvent")()
+
+	f.events = append(f.events, event+" "+payload)
+	return nil
+}
+
+func (f *fakeStorage) AddAuditEntry(ctx context.Context, entry models.AuditEntry) error {
+	defer f.query(ctx, "AddAuditEntry")()
+
+	f.audit = append(f.audit, entry)
+	return nil
+}
+
+func (f *fakeStorage) GetUserProfile(ctx context.Context, userId int) (models.User, error) {
+	defer f.query(ctx, "GetUserProfile")()
+
+	user, ok := f.users[userId]
+	if !ok {
+		return models.User{}, models.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (f *fakeStorage) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	defer f.query(ctx, "GetUserByEmail")()
+
+	for _, user := range f.users {
+		if user.EmailVerified && strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+
+	return models.User{}, models.ErrUserNotFound
+}
+
+func (f *fakeStorage) AddUserToken(ctx context.Context, userId int, purpose, tokenHash string, expiresAt time.Time) error {
+	defer f.query(ctx, "AddUserToken")()
+
+	f.dropUserTokens(userId, purpose)
+	f.userTokens[tokenHash] = &fakeToken{userId: userId, purpose: purpose, expiresAt: expiresAt}
+	return nil
+}
+
+func (f *fakeStorage) VerifyEmail(ctx context.Context, tokenHash string) (int, error) {
+	defer f.query(ctx, "VerifyEmail")()
+
+	userId, err := f.consumeUserToken(models.TokenVerifyEmail, tokenHash)
+	if err != nil {
+		return -1, err
+	}
+
+	user := f.users[userId]
+	user.EmailVerified = true
+	f.users[userId] = user
+
+	return userId, nil
+}
+
+func (f *fakeStorage) ResetPassword(ctx context.Context, tokenHash, password string) (int, error) {
+	defer f.query(ctx, "ResetPassword")()
+
+	userId, err := f.consumeUserToken(models.TokenResetPassword, tokenHash)
+	if err != nil {
+		return -1, err
+	}
+
+	f.passwords[userId] = password
+	f.dropUserTokens(userId, models.TokenResetPassword)
+
+	return userId, nil
+}
+
+// consumeUserToken and dropUserTokens do what the queries of the same name
+// do in the storage.
+func (f *fakeStorage) consumeUserToken(purpose, tokenHash string) (int, error) {
+	token, ok := f.userTokens[tokenHash]
+	if !ok || token.purpose != purpose || token.used || !token.expiresAt.After(time.Now()) {
+		return -1, models.ErrInvalidToken
+	}
+
+	token.used = true
+	return token.userId, nil
+}
+
+func (f *fakeStorage) dropUserTokens(userId int, purpose string) {
+	for hash, token := range f.userTokens {
+		if token.userId == userId && token.purpose == purpose && !token.used {
+			delete(f.userTokens, hash)
+		}
+	}
+}
+
+func (f *fakeStorage) LoginUser(ctx context.Context, username, password string) (int, error) {
+	defer f.query(ctx, "LoginUser")()
+
+	for id, user := range f.users {
+		if user.Username == username && !user.Disabled && user.DeletedAt == nil && f.passwords[id] != "" && f.passwords[id] == password {
+			return id, nil
+		}
+	}
+
+	return -1, models.ErrInvalidCredentials
+}
+
+func (f *fakeStorage) GetUsers(ctx context.Context, filter models.UserFilter) ([]models.User, int, error) {
+	defer f.query(ctx, "GetUsers")()
+
+	users := []models.User{}
+	for id := 1; len(users) < len(f.users); id++ {
+		if user, ok := f.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+
+	return users, len(users), nil
+}
+
+func (f *fakeStorage) GetUserIdentities(ctx context.Context, userId int) ([]models.ExternalIdentity, error) {
+	defer f.query(ctx, "GetUserIdentities")()
+
+	identities := []models.ExternalIdentity{}
+	for _, identity := range f.identities {
+		if identity.UserId == userId {
+			identities = append(identities, identity)
+		}
+	}
+
+	return identities, nil
+}
+
+// LoginExternalUser links identities like the storage does: to the user who
+// has verified the same email if the provider has verified it too, and to a
+// new user otherwise.
+func (f *fakeStorage) LoginExternalUser(ctx context.Context, identity models.ExternalIdentity) (models.User, bool, error) {
+	defer f.query(ctx, "LoginExternalUser")()
+
+	for _, linked := range f.identities {
+		if linked.Issuer == identity.Issuer && linked.Subject == identity.Subject {
+			return f.users[linked.UserId], false, nil
+		}
+	}
+
+	userId := 0
+	if identity.EmailVerified && identity.Email != "" {
+		for id, user := range f.users {
+			if user.EmailVerified && strings.EqualFold(user.Email, identity.Email) {
+				userId = id
+			}
+		}
+	}
+
+	created := userId == 0
+	if created {
+		for id := range f.users {
+			userId = max(userId, id)
+		}
+		userId++
+
+		f.users[userId] = models.User{Id: userId, Username: identity.Username, Role: models.RoleUser}
+	}
+
+	identity.Id, identity.UserId = len(f.identities)+1, userId
+	f.identities = append(f.identities, identity)
+
+	return f.users[userId], created, nil
+}
+
+func (f *fakeStorage) GetTOTP(ctx context.Context, userId int) (string, bool, int64, error) {
+	defer f.query(ctx, "GetTOTP")()
+
+	state, ok := f.totp[userId]
+	if !ok {
+		return "", false, 0, nil
+	}
+
+	return state.secret, state.enabled, state.lastStep, nil
+}
+
+func (f *fakeStorage) UseTOTPStep(ctx context.Context, userId int, step int64) error {
+	defer f.query(ctx, "UseTOTPStep")()
+
+	state, ok := f.totp[userId]
+	if !ok || state.lastStep >= step {
+		return models.ErrInvalidTOTP
+	}
+
+	state.lastStep = step
+	return nil
+}
+
+func (f *fakeStorage) UseRecoveryCode(ctx context.Context, userId int, codeHash string) error {
+	defer f.query(ctx, "UseRecoveryCode")()
+
+	state, ok := f.totp[userId]
+	if !ok {
+		return models.ErrInvalidTOTP
+	}
+
+	used, ok := state.recoveryCodes[codeHash]
+	if !ok || used {
+		return models.ErrInvalidTOTP
+	}
+
+	state.recoveryCodes[codeHash] = true
+	return nil
+}
+
+// RecordLoginFailure counts failures in a row, the fake never resets them
+// on its own.
+func (f *fakeStorage) RecordLoginFailure(ctx context.Context, key string, resetAfter time.Duration) (int, error) {
+	defer f.query(ctx, "RecordLoginFailure")()
+
+	f.failures[key]++
+	return f.failures[key], nil
+}
+
+func (f *fakeStorage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	defer f.query(ctx, "LockLogin")()
+
+	f.locks[key] = until
+	return nil
+}
+
+func (f *fakeStorage) GetLoginLock(ctx context.Context, keys []string) (time.Time, error) {
+	defer f.query(ctx, "GetLoginLock")()
+
+	until := time.Time{}
+	for _, key := range keys {
+		if lock := f.locks[key]; lock.After(time.Now()) && lock.After(until) {
+			until = lock
+		}
+	}
+
+	return until, nil
+}
+
+func (f *fakeStorage) ClearLoginFailures(ctx context.Context, keys []string) error {
+	defer f.query(ctx, "ClearLoginFailures")()
+
+	for _, key := range keys {
+		delete(f.failures, key)
+		delete(f.locks, key)
+	}
+
+	return nil
+}
+
+func (f *fakeStorage) AddProduct(ctx context.Context, sku, name, description string, price, quantity int) (int, error) {
+	defer f.query(ctx, "AddProduct")()
+
+	id := len(f.products) + 1
+	f.products[id] = models.Product{Id: id, Sku: sku, Name: name, Description: description, Price: price, Quantity: quantity, Version: 1}
+	return id, nil
+}
+
+func (f *fakeStorage) UpdateProduct(ctx context.Context, productId, version int, sku, name, description string, price, quantity int) (int, error) {
+	defer f.query(ctx, "UpdateProduct")()
+
+	product, ok := f.products[productId]
+	if !ok || product.DeletedAt != nil {
+		return 0, models.ErrProductNotFound
+	}
+
+	if product.Version != version {
+		return 0, models.ErrVersionConflict
+	}
+
+	product.Sku, product.Name, product.Description, product.Price, product.Quantity = sku, name, description, price, quantity
+	product.Version++
+	f.products[productId] = product
+
+	return product.Version, nil
+}
+
+func (f *fakeStorage) GetAllProducts(ctx context.Context) ([]models.Product, error) {
+	defer f.query(ctx, "GetAllProducts")()
+
+	return f.productList(func(product models.Product) bool { return product.DeletedAt == nil }), nil
+}
+
+func (f *fakeStorage) GetDeletedProducts(ctx context.Context) ([]models.Product, error) {
+	defer f.query(ctx, "GetDeletedProducts")()
+
+	return f.productList(func(product models.Product) bool { return product.DeletedAt != nil }), nil
+}
+
+func (f *fakeStorage) StreamProducts(ctx context.Context, fn func(product models.Product) error) error {
+	defer f.query(ctx, "StreamProducts")()
+
+	for _, product := range f.productList(func(product models.Product) bool { return product.DeletedAt == nil }) {
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// productList returns the products that match, ordered by id.
+func (f *fakeStorage) productList(match func(product models.Product) bool) []models.Product {
+	products := []models.Product{}
+	for id, seen := 1, 0; seen < len(f.products); id++ {
+		if product, ok := f.products[id]; ok {
+			seen++
+			if match(product) {
+				products = append(products, f.withRating(product))
+			}
+		}
+	}
+
+	return products
+}
+
+func (f *fakeStorage) Ready(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeStorage) GetUserPurchases(ctx context.Context, userId int) ([]models.Purchase, error) {
+	defer f.query(ctx, "GetUserPurchases")()
+
+	return f.purchaseList(func(purchase models.Purchase) bool { return purchase.UserId == userId }), nil
+}
+
+func (f *fakeStorage) GetProductPurchases(ctx context.Context, productId int) ([]models.Purchase, error) {
+	defer f.query(ctx, "GetProductPurchases")()
+
+	return f.purchaseList(func(purchase models.Purchase) bool { return purchase.ProductId == productId }), nil
+}
+
+func (f *fakeStorage) purchaseList(match func(purchase models.Purchase) bool) []models.Purchase {
+	purchases := []models.Purchase{}
+	for _, purchase := range f.purchases {
+		if match(purchase) {
+			purchases = append(purchases, purchase)
+		}
+	}
+
+	return purchases
+}
+
+func (f *fakeStorage) GetPurchaseSummary(ctx context.Context, userId int) (models.PurchaseSummary, error) {
+	defer f.query(ctx, "GetPurchaseSummary")()
+
+	summary := models.PurchaseSummary{UserId: userId}
+	for _, purchase := range f.purchases {
+		if purchase.UserId == userId {
+			summary.Purchases++
+			summary.Items += purchase.Quantity
+		}
+	}
+
+	return summary, nil
+}
+
+func (f *fakeStorage) GetUserReviews(ctx context.Context, userId int) ([]models.Review, error) {
+	defer f.query(ctx, "GetUserReviews")()
+
+	reviews := []models.Review{}
+	for _, review := range f.reviews {
+		if review.UserId == userId {
+			reviews = append(reviews, review)
+		}
+	}
+
+	return reviews, nil
+}
+
+func (f *fakeStorage) GetWishlist(ctx context.Context, userId int) ([]models.Product, error) {
+	defer f.query(ctx, "GetWishlist")()
+
+	return []models.Product{}, nil
+}
+
+func (f *fakeStorage) GetStockSubscriptions(ctx context.Context, userId int) ([]int, error) {
+	defer f.query(ctx, "GetStockSubscriptions")()
+
+	productIds := []int{}
+	for productId, userIds := range f.subscribers {
+		if slices.Contains(userIds, userId) {
+			productIds = append(productIds, productId)
+		}
+	}
+
+	return productIds, nil
+}
+
+func (f *fakeStorage) GetNotifications(ctx context.Context, userId int, unreadOnly bool) ([]models.Notification, error) {
+	defer f.query(ctx, "GetNotifications")()
+
+	return []models.Notification{}, nil
+}
+
+func (f *fakeStorage) GetAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	defer f.query(ctx, "GetAuditEntries")()
+
+	entries := []models.AuditEntry{}
+	for _, entry := range f.audit {
+		if filter.ActorId == 0 || entry.ActorId == filter.ActorId {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (f *fakeStorage) AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	defer f.query(ctx, "AddAPIKey")()
+
+	key.Id = len(f.apiKeys) + 1
+	key.CreatedAt = time.Now()
+	f.apiKeys = append(f.apiKeys, key)
+
+	return key, nil
+}
+
+func (f *fakeStorage) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	defer f.query(ctx, "GetAPIKeys")()
+
+	return slices.Clone(f.apiKeys), nil
+}
+
+func (f *fakeStorage) GetAPIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, error) {
+	defer f.query(ctx, "GetAPIKeyByPrefix")()
+
+	for _, key := range f.apiKeys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+
+	return models.APIKey{}, models.ErrAPIKeyNotFound
+}
+
+func (f *fakeStorage) TouchAPIKey(ctx context.Context, keyId int) error {
+	defer f.query(ctx, "TouchAPIKey")()
+
+	return nil
+}
+
+func (f *fakeStorage) AddWebhook(ctx context.Context, url, secret string, events []string) error {
+	defer f.query(ctx, "AddWebhook")()
+
+	f.webhooks = append(f.webhooks, models.Webhook{Id: len(f.webhooks) + 1, Url: url, Secret: secret, Events: events, Active: true})
+	return nil
+}
+
+func (f *fakeStorage) GetAllWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	defer f.query(ctx, "GetAllWebhooks")()
+
+	return slices.Clone(f.webhooks), nil
+}
+
+func (f *fakeStorage) GetWebhookById(ctx context.Context, webhookId int) (models.Webhook, error) {
+	defer f.query(ctx, "GetWebhookById")()
+
+	if webhookId < 1 || webhookId > len(f.webhooks) {
+		return models.Webhook{}, errors.New("webhook not found")
+	}
+
+	return f.webhooks[webhookId-1], nil
+}
+
+// fakeNotifier records the notifications instead of sending them.
+type fakeNotifier struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, message notify.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestServer(t *testing.T, store Storage) *Server {
+	t.Helper()
+
+	keys, err := signing.LoadKeySet(nil, "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	cfg.SecretKey = "test-secret"
+
+	return NewServer(cfg, store, nil, nil, nil, nil, nil, keys)
+}
+
+// do sends a request to the routes of s, with the token if there is one.
+func do(t *testing.T, handler http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	return w
+}
+
+func userToken(t *testing.T, s *Server, id int) string {
+	t.Helper()
+
+	token, err := CreateUserToken(s.keys, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func adminToken(t *testing.T, s *Server, id int) string {
+	t.Helper()
+
+	token, err := CreateAdminToken(s.keys, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
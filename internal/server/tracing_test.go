@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRequestSpanTree(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := tracing.SetupWithExporter(exporter)
+	defer shutdown(context.Background())
+
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "admin", Role: models.RoleAdmin}
+	store.products[5] = models.Product{Id: 5, Name: "Kettle", Price: 100, Quantity: 3, Version: 1}
+
+	s := newTestServer(t, store)
+	w := do(t, s.routes(), http.MethodGet, "/products/5", adminToken(t, s, 1), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	if err := otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	request, ok := spans["/products/:id"]
+	if !ok {
+		t.Fatalf("no request span among %v", spanNames(exporter.GetSpans()))
+	}
+
+	want := map[string]string{
+		"JWTAuthUser":            "/products/:id",
+		"storage.GetUserAuth":    "JWTAuthUser",
+		"storage.GetProductById": "/products/:id",
+	}
+	for name, parent := range want {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span among %v", name, spanNames(exporter.GetSpans()))
+			continue
+		}
+
+		if span.SpanContext.TraceID() != request.SpanContext.TraceID() {
+			t.Errorf("%s is not in the trace of the request", name)
+		}
+
+		if got := span.Parent.SpanID(); got != spans[parent].SpanContext.SpanID() {
+			t.Errorf("parent of %s = %s, want %s", name, got, parent)
+		}
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := []string{}
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+
+	return names
+}
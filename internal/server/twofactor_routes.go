@@ -0,0 +1,383 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/totp"
+)
+
+const (
+	totpIssuer        = "go-market"
+	mfaTokenTTL       = time.Minute * 5
+	recoveryCodeCount = 10
+)
+
+type activateTOTPRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// secondFactorRequest takes either a code from the authenticator app or one
+// of the recovery codes.
+type secondFactorRequest struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+type loginSecondFactorRequest struct {
+	MfaToken     string `json:"mfaToken" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type recoveryCodesResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// handleEnrollTOTP starts the enrollment with a new secret. It has no effect
+// on login until the user proves the app works with handleActivateTOTP.
+func (s *Server) handleEnrollTOTP(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.SetTOTPSecret(c.Request.Context(), id, secret); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, models.ErrTOTPEnabled) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, totpEnrollment{Secret: secret, Uri: totp.URI(totpIssuer, user.Username, secret)})
+}
+
+// handleTOTPQRCode renders the otpauth URI of a pending enrollment as a PNG.
+func (s *Server) handleTOTPQRCode(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	secret, enabled, _, err := s.store.GetTOTP(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if secret == "" || enabled {
+		c.JSON(http.StatusNotFound, models.Response{Message: "there is no pending two-factor enrollment"})
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URI(totpIssuer, user.Username, secret), qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+func (s *Server) handleActivateTOTP(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	request := activateTOTPRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	secret, enabled, lastStep, err := s.store.GetTOTP(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if enabled {
+		c.JSON(http.StatusConflict, models.Response{Message: models.ErrTOTPEnabled.Error()})
+		return
+	}
+
+	if secret == "" {
+		c.JSON(http.StatusBadRequest, models.Response{Message: "there is no pending two-factor enrollment"})
+		return
+	}
+
+	step, ok := totp.Validate(secret, request.Code, time.Now(), lastStep)
+	if !ok {
+		c.JSON(http.StatusBadRequest, models.Response{Message: models.ErrInvalidTOTP.Error()})
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	if err := s.store.EnableTOTP(c.Request.Context(), id, step, hashes); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.2fa_enable", "user:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, recoveryCodesResponse{
+		Message:       "two-factor authentication enabled, store the recovery codes in a safe place",
+		RecoveryCodes: codes,
+	})
+}
+
+func (s *Server) handleDisableTOTP(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	request := secondFactorRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if s.requireAdmin2FA && user.Role == models.RoleAdmin {
+		c.JSON(http.StatusForbidden, models.Response{Message: "two-factor authentication is required for admins"})
+		return
+	}
+
+	if err := s.checkSecondFactor(c.Request.Context(), id, request.Code, request.RecoveryCode); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.DisableTOTP(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.2fa_disable", "user:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "two-factor authentication disabled"})
+}
+
+// handleRegenerateRecoveryCodes replaces all recovery codes, used or not.
+func (s *Server) handleRegenerateRecoveryCodes(c *gin.Context) {
+	id := c.MustGet("id").(int)
+
+	request := activateTOTPRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	if err := s.checkSecondFactor(c.Request.Context(), id, request.Code, ""); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	codes, hashes := newRecoveryCodes()
+	if err := s.store.ReplaceRecoveryCodes(c.Request.Context(), id, hashes); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "user.2fa_recovery_codes", "user:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, recoveryCodesResponse{Message: "recovery codes replaced", RecoveryCodes: codes})
+}
+
+// handleLoginSecondFactor completes a login started by handleLoginUser for
+// users with two-factor authentication.
+func (s *Server) handleLoginSecondFactor(c *gin.Context) {
+	request := loginSecondFactorRequest{}
+	if err := c.ShouldBindBodyWithJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&request); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	id, admin, err := parseMFAToken(s.secret, request.MfaToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.Set("id", id)
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	// Codes are guessed more easily than passwords, so they count towards
+	// the same lockout.
+	if !s.checkLoginLock(c, user.Username) {
+		return
+	}
+
+	if err := s.checkSecondFactor(c.Request.Context(), id, request.Code, request.RecoveryCode); err != nil {
+		s.audit(c, "user.login_2fa_failed", "user:"+strconv.Itoa(id), nil, nil)
+		if errors.Is(err, models.ErrInvalidTOTP) {
+			s.recordLoginFailure(c, user.Username)
+		}
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if request.Code == "" {
+		s.audit(c, "user.2fa_recovery_code_used", "user:"+strconv.Itoa(id), nil, nil)
+	}
+
+	s.audit(c, "user.login", "user:"+strconv.Itoa(id), nil, nil)
+	s.clearLoginFailures(c, user.Username)
+
+	token, err := createLoginToken(s.keys, id, admin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.LoginResponse{Message: token})
+}
+
+// checkSecondFactor accepts a fresh TOTP code or burns an unused recovery code.
+func (s *Server) checkSecondFactor(ctx context.Context, userId int, code, recoveryCode string) error {
+	secret, enabled, lastStep, err := s.store.GetTOTP(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		return models.ErrTOTPNotEnabled
+	}
+
+	if code == "" {
+		return s.store.UseRecoveryCode(ctx, userId, hashToken(normalizeRecoveryCode(recoveryCode)))
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), lastStep)
+	if !ok {
+		return models.ErrInvalidTOTP
+	}
+
+	// A concurrent request with the same code loses here.
+	return s.store.UseTOTPStep(ctx, userId, step)
+}
+
+// createMFAToken returns a short-lived token that proves the password was
+// checked. It has no "id" claim, so the auth middlewares don't accept it.
+func createMFAToken(secret string, id int, admin bool) (string, error) {
+	claims := &jwt.MapClaims{
+		"mfa":   id,
+		"admin": admin,
+		"exp":   time.Now().Add(mfaTokenTTL).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(secret))
+}
+
+func parseMFAToken(secret, tokenString string) (int, bool, error) {
+	errInvalid := errors.New("two-factor login token is invalid or expired")
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	})
+	if err != nil || !token.Valid {
+		return -1, false, errInvalid
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return -1, false, errInvalid
+	}
+
+	// exp is optional for jwt.MapClaims.Valid, but always set on MFA tokens.
+	id, ok := claims["mfa"].(float64)
+	_, hasExp := claims["exp"]
+	if !ok || !hasExp {
+		return -1, false, errInvalid
+	}
+
+	admin, _ := claims["admin"].(bool)
+
+	return int(id), admin, nil
+}
+
+// newRecoveryCodes returns codes like "k3jx9-pq2mz" for the user and their
+// hashes for the storage.
+func newRecoveryCodes() ([]string, []string) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		rand.Read(b)
+
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	return codes, hashes
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/totp"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// newTOTPTestServer has alice with two-factor authentication and one
+// recovery code, and returns the body of a second factor login.
+func newTOTPTestServer(t *testing.T) (*Server, *fakeStorage, func(field, code string) string) {
+	t.Helper()
+
+	store := newFakeStorage()
+	store.users[1] = models.User{Id: 1, Username: "alice", Role: models.RoleUser, TOTPEnabled: true}
+	store.totp[1] = &fakeTOTP{
+		secret:        testTOTPSecret,
+		enabled:       true,
+		recoveryCodes: map[string]bool{hashToken("k3jx9pq2mz"): false},
+	}
+
+	s := newTestServer(t, store)
+
+	body := func(field, code string) string {
+		t.Helper()
+
+		mfaToken, err := createMFAToken(s.secret, 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return `{"mfaToken": "` + mfaToken + `", "` + field + `": "` + code + `"}`
+	}
+
+	return s, store, body
+}
+
+func TestLoginSecondFactorCodeIsSingleUse(t *testing.T) {
+	s, _, body := newTOTPTestServer(t)
+	handler := s.routes()
+
+	code, err := totp.Code(testTOTPSecret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := do(t, handler, http.MethodPost, "/users/login/2fa", "", body("code", code)); w.Code != http.StatusOK {
+		t.Fatalf("first use: status = %d, body %s", w.Code, w.Body)
+	}
+
+	if w := do(t, handler, http.MethodPost, "/users/login/2fa", "", body("code", code)); w.Code != http.StatusBadRequest {
+		t.Errorf("second use: status = %d, want 400", w.Code)
+	}
+}
+
+func TestLoginRecoveryCodeIsSingleUse(t *testing.T) {
+	s, store, body := newTOTPTestServer(t)
+	handler := s.routes()
+
+	if w := do(t, handler, http.MethodPost, "/users/login/2fa", "", body("recoveryCode", "K3JX9-PQ2MZ")); w.Code != http.StatusOK {
+		t.Fatalf("first use: status = %d, body %s", w.Code, w.Body)
+	}
+
+	if w := do(t, handler, http.MethodPost, "/users/login/2fa", "", body("recoveryCode", "k3jx9-pq2mz")); w.Code != http.StatusBadRequest {
+		t.Errorf("second use: status = %d, want 400", w.Code)
+	}
+
+	// A used code counts as a failed login.
+	if failures := store.failures[loginUserKey("alice")]; failures != 1 {
+		t.Errorf("%d login failures, want 1", failures)
+	}
+
+	used := 0
+	for _, entry := range store.audit {
+		if entry.Action == "user.2fa_recovery_code_used" {
+			used++
+		}
+	}
+
+	if used != 1 {
+		t.Errorf("%d recovery code uses audited, want 1", used)
+	}
+}
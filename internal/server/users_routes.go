@@ -1,64 +1,122 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/signing"
 )
 
 func (s *Server) handleRegisterUser(c *gin.Context) {
-	user := models.User{}
+	user := registerRequest{}
 	if err := c.ShouldBindBodyWithJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
 	if err := s.validate.Struct(&user); err != nil {
-		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		respondValidationError(c, err)
 		return
 	}
 
-	if err := s.store.RegisterUser(user.Username, user.Password, user.Email); err != nil {
+	if err := s.store.RegisterUser(c.Request.Context(), user.Username, user.Password, user.Email); err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
+	s.audit(c, "user.register", "user:"+user.Username, nil, nil)
+
+	if user.Email != "" {
+		if err := s.sendVerification(c.Request.Context(), user.Username); err != nil {
+			slog.ErrorContext(c.Request.Context(), "failed to send verification email", "username", user.Username, "error", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, models.Response{Message: "user successfully created"})
 }
 
 func (s *Server) handleLoginUser(c *gin.Context) {
-	loginUser := models.User{}
+	loginUser := loginRequest{}
 	if err := c.ShouldBindBodyWithJSON(&loginUser); err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
 	if err := s.validate.Struct(&loginUser); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	if !s.checkLoginLock(c, loginUser.Username) {
+		return
+	}
+
+	id, err := s.store.LoginUser(c.Request.Context(), loginUser.Username, loginUser.Password)
+	if err != nil {
+		s.audit(c, "user.login_failed", "user:"+loginUser.Username, nil, nil)
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			s.recordLoginFailure(c, loginUser.Username)
+		}
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	id, err := s.store.LoginUser(loginUser.Username, loginUser.Password)
+	c.Set("id", id)
+
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	var token string
+	s.completeLogin(c, user, user.Role == models.RoleAdmin)
+}
 
-	if loginUser.Username == "admin" && loginUser.Password == "admin" {
-		token, err = CreateAdminToken(id)
-	} else {
-		token, err = CreateUserToken(id)
+// completeLogin responds to a user who has passed the first factor: with a
+// two-factor challenge if the account has one, and with the token otherwise.
+func (s *Server) completeLogin(c *gin.Context, user models.User, admin bool) {
+	if user.TOTPEnabled {
+		mfaToken, err := createMFAToken(s.secret, user.Id, admin)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.LoginResponse{
+			Message:     "two-factor authentication required",
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		})
+		return
 	}
 
+	s.audit(c, "user.login", "user:"+user.Username, nil, nil)
+	s.clearLoginFailures(c, user.Username)
+
+	// Admins without two-factor authentication get a user token while the
+	// policy is on, which is enough to enroll.
+	enrollmentRequired := admin && s.requireAdmin2FA
+
+	token, err := createLoginToken(s.keys, user.Id, admin && !enrollmentRequired)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, models.Response{Message: token})
+	c.JSON(http.StatusOK, models.LoginResponse{Message: token, MfaEnrollmentRequired: enrollmentRequired})
+}
+
+func createLoginToken(keys *signing.KeySet, id int, admin bool) (string, error) {
+	if admin {
+		return CreateAdminToken(keys, id)
+	}
+
+	return CreateUserToken(keys, id)
 }
 
 func (s *Server) handleGetUserProfile(c *gin.Context) {
@@ -68,23 +126,34 @@ func (s *Server) handleGetUserProfile(c *gin.Context) {
 		return
 	}
 
-	user, err := s.store.GetUserProfile(id)
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	s.audit(c, "user.view", "user:"+strconv.Itoa(id), nil, nil)
+	c.JSON(http.StatusOK, newUserResponse(user))
 }
 
 func (s *Server) handleProfile(c *gin.Context) {
 	id := c.MustGet("id").(int)
 
-	user, err := s.store.GetUserProfile(id)
+	user, err := s.store.GetUserProfile(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, newUserResponse(user))
+}
+
+// sendVerification sends the verification email to a freshly registered user.
+func (s *Server) sendVerification(ctx context.Context, username string) error {
+	user, err := s.store.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	return s.sendVerificationEmail(ctx, user)
 }
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func (s *Server) handleAddWebhook(c *gin.Context) {
+	webhook := models.Webhook{}
+	if err := c.ShouldBindBodyWithJSON(&webhook); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&webhook); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	if err := s.store.AddWebhook(c.Request.Context(), webhook.Url, webhook.Secret, webhook.Events); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "webhook.create", "webhook", nil, webhook)
+
+	c.JSON(http.StatusOK, models.Response{Message: "webhook successfully added"})
+}
+
+func (s *Server) handleGetAllWebhooks(c *gin.Context) {
+	webhooks, err := s.store.GetAllWebhooks(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	responses := make([]webhookResponse, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		responses = append(responses, newWebhookResponse(webhook))
+	}
+
+	c.JSON(http.StatusOK, responses)
+}
+
+func (s *Server) handleGetWebhookById(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	webhook, err := s.store.GetWebhookById(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newWebhookResponse(webhook))
+}
+
+func (s *Server) handleUpdateWebhook(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	webhook := models.Webhook{}
+	if err := c.ShouldBindBodyWithJSON(&webhook); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.validate.Struct(&webhook); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	oldWebhook, err := s.store.GetWebhookById(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.UpdateWebhook(c.Request.Context(), id, webhook.Url, webhook.Secret, webhook.Events, webhook.Active); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	webhook.Id = id
+	s.audit(c, "webhook.update", "webhook:"+strconv.Itoa(id), oldWebhook, webhook)
+
+	c.JSON(http.StatusOK, models.Response{Message: "webhook successfully updated"})
+}
+
+func (s *Server) handleDeleteWebhook(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.DeleteWebhook(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "webhook.delete", "webhook:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "webhook successfully deleted"})
+}
+
+func (s *Server) handleGetWebhookDeliveries(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	deliveries, err := s.store.GetWebhookDeliveries(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (s *Server) handleRedeliverWebhookDelivery(c *gin.Context) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.RedeliverWebhookDelivery(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	s.audit(c, "webhook.redeliver", "delivery:"+strconv.Itoa(id), nil, nil)
+
+	c.JSON(http.StatusOK, models.Response{Message: "delivery successfully queued"})
+}
+
+// publishEvent queues a webhook event. Failures are only logged so that
+// the action which triggered the event is never rolled back because of it.
+func (s *Server) publishEvent(ctx context.Context, event string, data any) {
+	payload, err := json.Marshal(map[string]any{"event": event, "data": data})
+	if err != nil {
+		slog.ErrorContext(ctx, "webhooks: failed to encode event", "event", event, "error", err)
+		return
+	}
+
+	if err := s.store.EnqueueWebhookEvent(ctx, event, string(payload)); err != nil {
+		slog.ErrorContext(ctx, "webhooks: failed to enqueue event", "event", event, "error", err)
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+	"github.com/ursuldaniel/go-market/internal/notify"
+)
+
+func (s *Server) handleGetWishlist(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	products, err := s.store.GetWishlist(c.Request.Context(), userId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newProductResponses(products))
+}
+
+func (s *Server) handleAddWishlistItem(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	product, ok := s.catalogProduct(c)
+	if !ok {
+		return
+	}
+
+	if err := s.store.AddWishlistItem(c.Request.Context(), userId, product.Id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Message: "product successfully added to wishlist"})
+}
+
+func (s *Server) handleRemoveWishlistItem(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.RemoveWishlistItem(c.Request.Context(), userId, id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Message: "product successfully removed from wishlist"})
+}
+
+// handleSubscribeStock asks to be notified once an out of stock product is
+// available again.
+func (s *Server) handleSubscribeStock(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	product, ok := s.catalogProduct(c)
+	if !ok {
+		return
+	}
+
+	if product.Quantity > 0 {
+		c.JSON(http.StatusBadRequest, models.Response{Message: models.ErrInStock.Error()})
+		return
+	}
+
+	if err := s.store.SubscribeStock(c.Request.Context(), userId, product.Id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Message: "you will be notified when the product is back in stock"})
+}
+
+func (s *Server) handleUnsubscribeStock(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	if err := s.store.UnsubscribeStock(c.Request.Context(), userId, id); err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Message: "subscription successfully removed"})
+}
+
+func (s *Server) handleGetNotifications(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	notifications, err := s.store.GetNotifications(c.Request.Context(), userId, c.Query("unread") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+func (s *Server) handleMarkNotificationRead(c *gin.Context) {
+	userId := c.MustGet("id").(int)
+
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	err = s.store.MarkNotificationRead(c.Request.Context(), userId, id)
+	if errors.Is(err, models.ErrNotificationNotFound) {
+		c.JSON(http.StatusNotFound, models.Response{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.Response{Message: "notification marked as read"})
+}
+
+// catalogProduct loads the product addressed by the :id parameter. On
+// failure the response is written and false is returned.
+func (s *Server) catalogProduct(c *gin.Context) (models.Product, bool) {
+	id, err := ParseId(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return models.Product{}, false
+	}
+
+	product, err := s.store.GetProductById(c.Request.Context(), id)
+	if errors.Is(err, models.ErrProductNotFound) {
+		c.JSON(http.StatusNotFound, models.Response{Message: err.Error()})
+		return models.Product{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.Response{Message: err.Error()})
+		return models.Product{}, false
+	}
+
+	return product, true
+}
+
+// notifyBackInStock notifies the subscribers of product in the background.
+// Subscriptions are removed up front, so a user is notified at most once per
+// restock even if the quantity changes again meanwhile.
+func (s *Server) notifyBackInStock(ctx context.Context, product models.Product) {
+	users, err := s.store.TakeStockSubscribers(ctx, product.Id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load stock subscribers", "product_id", product.Id, "error", err)
+		return
+	}
+
+	if len(users) == 0 {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+
+		for _, user := range users {
+			message := notify.Message{
+				UserId:  user.Id,
+				Email:   user.Email,
+				Subject: fmt.Sprintf("%s is back in stock", product.Name),
+				Body:    fmt.Sprintf("Hi %s,\n\n%s is available again: /products/%d\n", user.Username, product.Name, product.Id),
+			}
+
+			if err := s.notifier.Notify(ctx, message); err != nil {
+				slog.ErrorContext(ctx, "failed to send back in stock notification", "user_id", user.Id, "product_id", product.Id, "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,238 @@
+// Package signing signs the tokens of the API and verifies them. Tokens are
+// signed with RS256 or EdDSA keys and name their key in the kid header, so
+// that other services can verify them with the published public keys and
+// keys can be rotated without logging everyone out.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	jwt "github.com/golang-jwt/jwt"
+	"github.com/ursuldaniel/go-market/internal/oidc"
+)
+
+// Algorithms of the signing keys. HS256 is only used with the shared
+// secret when no keys are configured.
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+	HS256 = "HS256"
+)
+
+const minRSABits = 2048
+
+// Key is a key tokens are verified with, and signed with if the private
+// part is known.
+type Key struct {
+	ID        string
+	Algorithm string
+
+	signKey   any
+	verifyKey any
+}
+
+// KeySet holds every key that verifies tokens. The first one signs new
+// tokens.
+type KeySet struct {
+	keys []*Key
+	byID map[string]*Key
+}
+
+// LoadKeySet reads PEM files with PKCS #8 or PKCS #1 private keys, or PKIX
+// public keys of retired keys that only verify. The first file must have a
+// private key, it signs new tokens. Without files, tokens are signed with
+// HS256 and the secret.
+func LoadKeySet(paths []string, secret string) (*KeySet, error) {
+	if len(paths) == 0 {
+		key := &Key{Algorithm: HS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+		return &KeySet{keys: []*Key{key}, byID: map[string]*Key{"": key}}, nil
+	}
+
+	ks := &KeySet{byID: map[string]*Key{}}
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := ParseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if _, ok := ks.byID[key.ID]; ok {
+			return nil, fmt.Errorf("%s: key %s is listed twice", path, key.ID)
+		}
+
+		if i == 0 && key.signKey == nil {
+			return nil, fmt.Errorf("%s: the first key signs tokens and needs the private key", path)
+		}
+
+		ks.keys = append(ks.keys, key)
+		ks.byID[key.ID] = key
+	}
+
+	return ks, nil
+}
+
+// ParseKey reads a PEM encoded key. Its id is the RFC 7638 thumbprint of the
+// public key, so every instance names the same key the same way.
+func ParseKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.signKey, key.verifyKey = RS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Algorithm, key.verifyKey = RS256, k
+	case ed25519.PrivateKey:
+		key.Algorithm, key.signKey, key.verifyKey = EdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Algorithm, key.verifyKey = EdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+
+	if rsaKey, ok := key.verifyKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+	}
+
+	jwk, err := oidc.NewJSONWebKey("", key.Algorithm, key.verifyKey)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = jwk.Thumbprint()
+
+	return key, nil
+}
+
+// GenerateKey returns a new private key of the algorithm as PEM.
+func GenerateKey(algorithm string) ([]byte, error) {
+	var key any
+	var err error
+	switch algorithm {
+	case RS256:
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case EdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q, use %s or %s", algorithm, RS256, EdDSA)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// SigningKey returns the key new tokens are signed with.
+func (ks *KeySet) SigningKey() *Key {
+	return ks.keys[0]
+}
+
+// Sign returns a token with the claims, signed with the signing key.
+func (ks *KeySet) Sign(claims jwt.MapClaims) (string, error) {
+	key := ks.SigningKey()
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	return token.SignedString(key.signKey)
+}
+
+// Parse verifies a token and returns its claims. The token has to name one
+// of the keys in kid and use the algorithm of that key: the alg header
+// alone never decides how a token is checked.
+func (ks *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	parser := jwt.Parser{ValidMethods: ks.algorithms()}
+
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.byID[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("key %q doesn't sign with %v", kid, token.Header["alg"])
+		}
+
+		return key.verifyKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("Invalid or expired token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("Invalid token claims")
+	}
+
+	return claims, nil
+}
+
+func (ks *KeySet) algorithms() []string {
+	seen := map[string]bool{}
+	algorithms := []string{}
+	for _, key := range ks.keys {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	return algorithms
+}
+
+// JWKS returns the public keys for /.well-known/jwks.json, the signing key
+// first. The shared secret is never published, so the set is empty without
+// keys.
+func (ks *KeySet) JWKS() oidc.JSONWebKeySet {
+	set := oidc.JSONWebKeySet{Keys: []oidc.JSONWebKey{}}
+	for _, key := range ks.keys {
+		if key.Algorithm == HS256 {
+			continue
+		}
+
+		jwk, err := oidc.NewJSONWebKey(key.ID, key.Algorithm, key.verifyKey)
+		if err == nil {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+
+	return set
+}
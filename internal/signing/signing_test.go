@@ -0,0 +1,183 @@
+package signing
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// writeKeys generates a private key of every algorithm and returns the
+// paths of their PEM files.
+func writeKeys(t *testing.T, algorithms ...string) []string {
+	t.Helper()
+
+	dir := t.TempDir()
+	paths := []string{}
+	for i, algorithm := range algorithms {
+		data, err := GenerateKey(algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, algorithm+"-"+string(rune('a'+i))+".pem")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+func loadKeySet(t *testing.T, paths ...string) *KeySet {
+	t.Helper()
+
+	ks, err := LoadKeySet(paths, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ks
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"id": 1, "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func TestParseRejectsForgedTokens(t *testing.T) {
+	paths := writeKeys(t, RS256, EdDSA)
+	ks := loadKeySet(t, paths[0])
+	rsaKey := ks.SigningKey()
+
+	// Only the public key is published, so an attacker knows it.
+	publicDER, err := x509.MarshalPKIXPublicKey(rsaKey.verifyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	other := loadKeySet(t, paths[1])
+	shared := loadKeySet(t)
+
+	tests := []struct {
+		name  string
+		token func() (string, error)
+	}{
+		{
+			name: "alg none",
+			token: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims())
+				token.Header["kid"] = rsaKey.ID
+				return token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+			},
+		},
+		{
+			name: "HS256 with the RSA public key as PEM",
+			token: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+				token.Header["kid"] = rsaKey.ID
+				return token.SignedString(publicPEM)
+			},
+		},
+		{
+			name: "HS256 with the RSA public key as DER",
+			token: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+				token.Header["kid"] = rsaKey.ID
+				return token.SignedString(publicDER)
+			},
+		},
+		{
+			name: "unknown kid",
+			token: func() (string, error) {
+				return other.Sign(testClaims())
+			},
+		},
+		{
+			name: "unknown kid signed with the key",
+			token: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, testClaims())
+				token.Header["kid"] = other.SigningKey().ID
+				return token.SignedString(rsaKey.signKey)
+			},
+		},
+		{
+			name: "no kid",
+			token: func() (string, error) {
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, testClaims())
+				return token.SignedString(rsaKey.signKey)
+			},
+		},
+		{
+			name: "shared secret",
+			token: func() (string, error) {
+				return shared.Sign(testClaims())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := test.token()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims, err := ks.Parse(token); err == nil {
+				t.Errorf("token accepted with claims %v", claims)
+			}
+		})
+	}
+
+	// The set itself accepts what it signs.
+	token, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ks.Parse(token); err != nil {
+		t.Errorf("valid token rejected: %v", err)
+	}
+}
+
+// TestParseDuringRotation checks that tokens of every listed key verify,
+// whichever key signs new tokens.
+func TestParseDuringRotation(t *testing.T) {
+	paths := writeKeys(t, EdDSA, EdDSA)
+	current, next := loadKeySet(t, paths[0]), loadKeySet(t, paths[1])
+
+	// The next key is published before it signs, then the two swap.
+	before := loadKeySet(t, paths[0], paths[1])
+	after := loadKeySet(t, paths[1], paths[0])
+
+	for _, signer := range []*KeySet{current, next} {
+		token, err := signer.Sign(testClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, ks := range map[string]*KeySet{"before": before, "after": after} {
+			if _, err := ks.Parse(token); err != nil {
+				t.Errorf("token of key %s rejected %s the swap: %v", signer.SigningKey().ID, name, err)
+			}
+		}
+	}
+}
+
+func TestParseRejectsExpiredTokens(t *testing.T) {
+	ks := loadKeySet(t, writeKeys(t, EdDSA)...)
+
+	token, err := ks.Sign(jwt.MapClaims{"id": 1, "exp": time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ks.Parse(token); err == nil {
+		t.Error("expired token accepted")
+	}
+}
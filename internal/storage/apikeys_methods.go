@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, COALESCE(created_by, 0), created_at, expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row pgx.Row, key *models.APIKey) error {
+	return row.Scan(&key.Id, &key.Name, &key.Prefix, &key.KeyHash, &key.Scopes, &key.CreatedBy, &key.CreatedAt, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt)
+}
+
+func (s *PostgresStorage) AddAPIKey(ctx context.Context, key models.APIKey) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddAPIKey"), time.Second*5)
+	defer cancel()
+
+	query := `
+	INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, expires_at) VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING ` + apiKeyColumns
+	err := scanAPIKey(s.conn.QueryRow(ctx, query, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.CreatedBy, key.ExpiresAt), &key)
+
+	return key, err
+}
+
+func (s *PostgresStorage) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetAPIKeys"), time.Second*5)
+	defer cancel()
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id`
+	rows, err := s.conn.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key := models.APIKey{}
+		if err := scanAPIKey(rows, &key); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// GetAPIKeyByPrefix returns the key with the given prefix, revoked and
+// expired ones included.
+func (s *PostgresStorage) GetAPIKeyByPrefix(ctx context.Context, prefix string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetAPIKeyByPrefix"), time.Second*5)
+	defer cancel()
+
+	key := models.APIKey{}
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE prefix = $1`
+	err := scanAPIKey(s.conn.QueryRow(ctx, query, prefix), &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.APIKey{}, models.ErrAPIKeyNotFound
+	}
+
+	return key, err
+}
+
+// TouchAPIKey records that the key has been used. The time is updated at
+// most once a minute to spare the database.
+func (s *PostgresStorage) TouchAPIKey(ctx context.Context, keyId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "TouchAPIKey"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE api_keys SET last_used_at = now() WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - INTERVAL '1 minute')`
+	_, err := s.conn.Exec(ctx, query, keyId)
+
+	return err
+}
+
+func (s *PostgresStorage) RevokeAPIKey(ctx context.Context, keyId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "RevokeAPIKey"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`
+	tag, err := s.conn.Exec(ctx, query, keyId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// auditLockKey serializes appends so that every entry is chained to the previous one.
+const auditLockKey = 727001
+
+// AddAuditEntry appends an entry to the audit log, chaining its hash to the hash of the last entry.
+func (s *PostgresStorage) AddAuditEntry(ctx context.Context, entry models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddAuditEntry"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, auditLockKey); err != nil {
+		return err
+	}
+
+	query := `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`
+	err = tx.QueryRow(ctx, query).Scan(&entry.PrevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	if len(entry.Diff) == 0 {
+		entry.Diff = []byte("{}")
+	}
+
+	entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	entry.Hash = HashAuditEntry(entry)
+
+	_, err = tx.Prepare(ctx, "insert audit entry", `
+	INSERT INTO audit_log (actor_id, action, target, diff, ip, request_id, created_at, prev_hash, hash)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "insert audit entry", entry.ActorId, entry.Action, entry.Target, string(entry.Diff), entry.Ip, entry.RequestId, entry.CreatedAt, entry.PrevHash, entry.Hash)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) GetAuditEntries(ctx context.Context, filter models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetAuditEntries"), time.Second*5)
+	defer cancel()
+
+	conditions := []string{}
+	args := []any{}
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ActorId != 0 {
+		addCondition("actor_id = $%d", filter.ActorId)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.Target != "" {
+		addCondition("target = $%d", filter.Target)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at <= $%d", filter.To)
+	}
+
+	query := `SELECT id, actor_id, action, target, diff, ip, request_id, created_at, prev_hash, hash FROM audit_log`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	query += " ORDER BY id DESC LIMIT " + strconv.Itoa(limit) + " OFFSET " + strconv.Itoa(filter.Offset)
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAuditEntries(rows)
+}
+
+// VerifyAuditChain recomputes the hash chain and returns the id of the first
+// entry that does not match, or 0 if the whole log is intact.
+func (s *PostgresStorage) VerifyAuditChain(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "VerifyAuditChain"), time.Second*30)
+	defer cancel()
+
+	query := `SELECT id, actor_id, action, target, diff, ip, request_id, created_at, prev_hash, hash FROM audit_log ORDER BY id`
+	rows, err := s.conn.Query(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	entries, err := scanAuditEntries(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	return brokenAuditEntry(entries), nil
+}
+
+// brokenAuditEntry returns the id of the first entry, in the order of the
+// log, whose hash doesn't match its content or whose previous hash isn't
+// the hash of the entry before it. Editing, deleting or reordering entries
+// breaks the chain there; only dropping the newest entries goes unnoticed.
+func brokenAuditEntry(entries []models.AuditEntry) int {
+	prevHash := ""
+	for _, entry := range entries {
+		if entry.PrevHash != prevHash || HashAuditEntry(entry) != entry.Hash {
+			return entry.Id
+		}
+
+		prevHash = entry.Hash
+	}
+
+	return 0
+}
+
+func HashAuditEntry(entry models.AuditEntry) string {
+	fields := []string{
+		entry.PrevHash,
+		strconv.Itoa(entry.ActorId),
+		entry.Action,
+		entry.Target,
+		string(entry.Diff),
+		entry.Ip,
+		entry.RequestId,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanAuditEntries(rows pgx.Rows) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		entry := models.AuditEntry{}
+		var diff string
+		err := rows.Scan(
+			&entry.Id,
+			&entry.ActorId,
+			&entry.Action,
+			&entry.Target,
+			&diff,
+			&entry.Ip,
+			&entry.RequestId,
+			&entry.CreatedAt,
+			&entry.PrevHash,
+			&entry.Hash,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Diff = []byte(diff)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// auditChain builds a log of n entries chained the way AddAuditEntry does.
+func auditChain(n int) []models.AuditEntry {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	entries := []models.AuditEntry{}
+	prevHash := ""
+	for i := 0; i < n; i++ {
+		entry := models.AuditEntry{
+			Id:        i + 1,
+			ActorId:   1,
+			Action:    "product.update",
+			Target:    "product:" + strconv.Itoa(i+1),
+			Diff:      json.RawMessage(`{"price":{"after":200,"before":100}}`),
+			Ip:        "192.0.2.1",
+			RequestId: "request",
+			CreatedAt: createdAt.Add(time.Minute * time.Duration(i)),
+			PrevHash:  prevHash,
+		}
+		entry.Hash = HashAuditEntry(entry)
+
+		entries = append(entries, entry)
+		prevHash = entry.Hash
+	}
+
+	return entries
+}
+
+func TestBrokenAuditEntry(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(entries []models.AuditEntry) []models.AuditEntry
+		broken int
+	}{
+		{
+			name:   "untouched",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry { return entries },
+			broken: 0,
+		},
+		{
+			name: "edited diff",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[2].Diff = json.RawMessage(`{"price":{"after":1,"before":100}}`)
+				return entries
+			},
+			broken: 3,
+		},
+		{
+			name: "edited diff with a new hash",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[2].Diff = json.RawMessage(`{}`)
+				entries[2].Hash = HashAuditEntry(entries[2])
+				return entries
+			},
+			broken: 4,
+		},
+		{
+			name: "deleted entry",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				return append(entries[:1], entries[2:]...)
+			},
+			broken: 3,
+		},
+		{
+			name: "reordered entries",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[1], entries[2] = entries[2], entries[1]
+				return entries
+			},
+			broken: 3,
+		},
+		{
+			name: "edited first entry",
+			tamper: func(entries []models.AuditEntry) []models.AuditEntry {
+				entries[0].ActorId = 2
+				return entries
+			},
+			broken: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if broken := brokenAuditEntry(test.tamper(auditChain(5))); broken != test.broken {
+				t.Errorf("broken entry = %d, want %d", broken, test.broken)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// LoginExternalUser returns the user linked to an identity of an OpenID
+// Connect provider. An identity seen for the first time is linked to the
+// user with the same email if both sides have verified it, and gets a new
+// user otherwise. The bool reports whether the user was created.
+func (s *PostgresStorage) LoginExternalUser(ctx context.Context, identity models.ExternalIdentity) (models.User, bool, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "LoginExternalUser"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return models.User{}, false, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var userId int
+	created := false
+	query := `
+	UPDATE user_identities SET email = $3, email_verified = $4, last_login_at = now()
+	WHERE issuer = $1 AND subject = $2
+	RETURNING user_id`
+	err = tx.QueryRow(ctx, query, identity.Issuer, identity.Subject, identity.Email, identity.EmailVerified).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		userId, created, err = linkExternalIdentity(ctx, tx, identity)
+	}
+	if err != nil {
+		return models.User{}, false, err
+	}
+
+	user := models.User{}
+	query = `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND deleted_at IS NULL`
+	err = scanUser(tx.QueryRow(ctx, query, userId), &user)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.User{}, false, models.ErrUserNotFound
+	}
+	if err != nil {
+		return models.User{}, false, err
+	}
+
+	return user, created, tx.Commit(ctx)
+}
+
+func linkExternalIdentity(ctx context.Context, tx pgx.Tx, identity models.ExternalIdentity) (int, bool, error) {
+	var userId int
+	if identity.EmailVerified && identity.Email != "" {
+		query := `SELECT id FROM users WHERE lower(email) = lower($1) AND email_verified AND deleted_at IS NULL`
+		err := tx.QueryRow(ctx, query, identity.Email).Scan(&userId)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return -1, false, err
+		}
+	}
+
+	created := userId == 0
+	if created {
+		username, err := freeUsername(ctx, tx, identity.Username)
+		if err != nil {
+			return -1, false, err
+		}
+
+		// The email is taken over only if the provider has verified it and
+		// no other user has it, so that password resets stay unambiguous.
+		email := ""
+		if identity.EmailVerified && identity.Email != "" {
+			var taken bool
+			query := `SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1))`
+			if err := tx.QueryRow(ctx, query, identity.Email).Scan(&taken); err != nil {
+				return -1, false, err
+			}
+
+			if !taken {
+				email = identity.Email
+			}
+		}
+
+		// Without a password the user can only sign in through the provider,
+		// or after setting one with a password reset.
+		query := `INSERT INTO users (username, password, email, email_verified) VALUES ($1, '', $2, $3) RETURNING id`
+		if err := tx.QueryRow(ctx, query, username, email, email != "").Scan(&userId); err != nil {
+			return -1, false, err
+		}
+	}
+
+	query := `
+	INSERT INTO user_identities (user_id, issuer, subject, email, email_verified, last_login_at)
+	VALUES ($1, $2, $3, $4, $5, now())`
+	if _, err := tx.Exec(ctx, query, userId, identity.Issuer, identity.Subject, identity.Email, identity.EmailVerified); err != nil {
+		return -1, false, err
+	}
+
+	return userId, created, nil
+}
+
+// freeUsername returns username, or username with the lowest free number
+// appended if it is taken.
+func freeUsername(ctx context.Context, tx pgx.Tx, username string) (string, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		username = "user"
+	}
+	if runes := []rune(username); len(runes) > 56 {
+		username = string(runes[:56])
+	}
+
+	query := `SELECT username FROM users WHERE username = $1 OR left(username, length($1) + 1) = $1 || '-'`
+	rows, err := tx.Query(ctx, query, username)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	taken := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+
+		taken[name] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	candidate := username
+	for i := 2; taken[candidate]; i++ {
+		candidate = username + "-" + strconv.Itoa(i)
+	}
+
+	return candidate, nil
+}
+
+func (s *PostgresStorage) GetUserIdentities(ctx context.Context, userId int) ([]models.ExternalIdentity, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetUserIdentities"), time.Second*5)
+	defer cancel()
+
+	query := `
+	SELECT id, user_id, issuer, subject, email, email_verified, created_at, last_login_at
+	FROM user_identities WHERE user_id = $1 ORDER BY id`
+	rows, err := s.conn.Query(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.ExternalIdentity{}
+	for rows.Next() {
+		identity := models.ExternalIdentity{}
+		err := rows.Scan(
+			&identity.Id,
+			&identity.UserId,
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.Email,
+			&identity.EmailVerified,
+			&identity.CreatedAt,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+const imageColumns = `id, product_id, key, content_type, thumbnail_type, width, height, size, position, is_primary, created_at`
+
+func scanImage(row pgx.Row, image *models.ProductImage) error {
+	return row.Scan(&image.Id, &image.ProductId, &image.Key, &image.ContentType, &image.ThumbnailType,
+		&image.Width, &image.Height, &image.Size, &image.Position, &image.Primary, &image.CreatedAt)
+}
+
+// AddProductImage appends the image after the existing ones. The first image
+// of a product becomes its primary image.
+func (s *PostgresStorage) AddProductImage(ctx context.Context, image models.ProductImage) (models.ProductImage, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddProductImage"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// Locking the product serializes concurrent uploads, so positions stay unique.
+	query := `SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(ctx, query, image.ProductId).Scan(&image.ProductId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ProductImage{}, models.ErrProductNotFound
+	}
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+
+	query = `
+	INSERT INTO product_images (product_id, key, content_type, thumbnail_type, width, height, size, position, is_primary)
+	SELECT $1, $2, $3, $4, $5, $6, $7, COALESCE(MAX(position), 0) + 1, COUNT(*) = 0
+	FROM product_images WHERE product_id = $1
+	RETURNING ` + imageColumns
+	row := tx.QueryRow(ctx, query, image.ProductId, image.Key, image.ContentType, image.ThumbnailType, image.Width, image.Height, image.Size)
+	if err := scanImage(row, &image); err != nil {
+		return models.ProductImage{}, err
+	}
+
+	return image, tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) GetProductImages(ctx context.Context, productId int) ([]models.ProductImage, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetProductImages"), time.Second*5)
+	defer cancel()
+
+	query := `SELECT ` + imageColumns + ` FROM product_images WHERE product_id = $1 ORDER BY position, id`
+	rows, err := s.conn.Query(ctx, query, productId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []models.ProductImage{}
+	for rows.Next() {
+		image := models.ProductImage{}
+		if err := scanImage(rows, &image); err != nil {
+			return nil, err
+		}
+
+		images = append(images, image)
+	}
+
+	return images, rows.Err()
+}
+
+func (s *PostgresStorage) GetProductImage(ctx context.Context, productId, imageId int) (models.ProductImage, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetProductImage"), time.Second*5)
+	defer cancel()
+
+	image := models.ProductImage{}
+	query := `SELECT ` + imageColumns + ` FROM product_images WHERE product_id = $1 AND id = $2`
+	err := scanImage(s.conn.QueryRow(ctx, query, productId, imageId), &image)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ProductImage{}, models.ErrImageNotFound
+	}
+
+	return image, err
+}
+
+// DeleteProductImage removes the image row and returns it, so that the caller
+// can remove the blobs. If it was the primary image, the next one takes over.
+func (s *PostgresStorage) DeleteProductImage(ctx context.Context, productId, imageId int) (models.ProductImage, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "DeleteProductImage"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	image := models.ProductImage{}
+	query := `DELETE FROM product_images WHERE product_id = $1 AND id = $2 RETURNING ` + imageColumns
+	err = scanImage(tx.QueryRow(ctx, query, productId, imageId), &image)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ProductImage{}, models.ErrImageNotFound
+	}
+	if err != nil {
+		return models.ProductImage{}, err
+	}
+
+	if image.Primary {
+		query = `
+		UPDATE product_images SET is_primary = TRUE
+		WHERE id = (SELECT id FROM product_images WHERE product_id = $1 ORDER BY position, id LIMIT 1)`
+		if _, err := tx.Exec(ctx, query, productId); err != nil {
+			return models.ProductImage{}, err
+		}
+	}
+
+	return image, tx.Commit(ctx)
+}
+
+// ReorderProductImages sets the positions to the order of imageIds, which
+// must list every image of the product exactly once.
+func (s *PostgresStorage) ReorderProductImages(ctx context.Context, productId int, imageIds []int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "ReorderProductImages"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var count int
+	query := `SELECT COUNT(*) FROM product_images WHERE product_id = $1 AND id = ANY($2)`
+	if err := tx.QueryRow(ctx, query, productId, imageIds).Scan(&count); err != nil {
+		return err
+	}
+
+	var total int
+	query = `SELECT COUNT(*) FROM product_images WHERE product_id = $1`
+	if err := tx.QueryRow(ctx, query, productId).Scan(&total); err != nil {
+		return err
+	}
+
+	if count != len(imageIds) || count != total {
+		return fmt.Errorf("order must list every image of the product exactly once")
+	}
+
+	query = `
+	UPDATE product_images SET position = ordered.position
+	FROM unnest($2::INTEGER[]) WITH ORDINALITY AS ordered (id, position)
+	WHERE product_images.product_id = $1 AND product_images.id = ordered.id`
+	if _, err := tx.Exec(ctx, query, productId, imageIds); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) SetPrimaryProductImage(ctx context.Context, productId, imageId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "SetPrimaryProductImage"), time.Second*5)
+	defer cancel()
+
+	query := `
+	UPDATE product_images SET is_primary = (id = $2)
+	WHERE product_id = $1 AND EXISTS (SELECT 1 FROM product_images WHERE product_id = $1 AND id = $2)`
+	tag, err := s.conn.Exec(ctx, query, productId, imageId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrImageNotFound
+	}
+
+	return nil
+}
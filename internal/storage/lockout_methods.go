@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// RecordLoginFailure counts a failed login for key and returns the number of
+// failures in a row. The count starts over when the previous failure is older
+// than resetAfter.
+func (s *PostgresStorage) RecordLoginFailure(ctx context.Context, key string, resetAfter time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "RecordLoginFailure"), time.Second*5)
+	defer cancel()
+
+	var failures int
+	query := `
+	INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, now())
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN login_attempts.last_failure_at < $2 THEN 1 ELSE login_attempts.failures + 1 END,
+		last_failure_at = now()
+	RETURNING failures`
+	err := s.conn.QueryRow(ctx, query, key, time.Now().Add(-resetAfter)).Scan(&failures)
+
+	return failures, err
+}
+
+// LockLogin rejects logins for key until the given time.
+func (s *PostgresStorage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "LockLogin"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`
+	_, err := s.conn.Exec(ctx, query, key, until)
+
+	return err
+}
+
+// GetLoginLock returns the time until which any of keys is locked, or the
+// zero time when none of them is.
+func (s *PostgresStorage) GetLoginLock(ctx context.Context, keys []string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetLoginLock"), time.Second*5)
+	defer cancel()
+
+	var until *time.Time
+	query := `SELECT max(locked_until) FROM login_attempts WHERE key = ANY($1) AND locked_until > now()`
+	if err := s.conn.QueryRow(ctx, query, keys).Scan(&until); err != nil {
+		return time.Time{}, err
+	}
+
+	if until == nil {
+		return time.Time{}, nil
+	}
+
+	return *until, nil
+}
+
+// ClearLoginFailures forgets the failures and locks of keys.
+func (s *PostgresStorage) ClearLoginFailures(ctx context.Context, keys []string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "ClearLoginFailures"), time.Second*5)
+	defer cancel()
+
+	query := `DELETE FROM login_attempts WHERE key = ANY($1)`
+	_, err := s.conn.Exec(ctx, query, keys)
+
+	return err
+}
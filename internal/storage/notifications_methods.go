@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func (s *PostgresStorage) AddNotification(ctx context.Context, userId int, subject, body string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddNotification"), time.Second*5)
+	defer cancel()
+
+	query := `INSERT INTO notifications (user_id, subject, body) VALUES ($1, $2, $3)`
+	_, err := s.conn.Exec(ctx, query, userId, subject, body)
+	return err
+}
+
+// GetNotifications returns the inbox of the user, newest first.
+func (s *PostgresStorage) GetNotifications(ctx context.Context, userId int, unreadOnly bool) ([]models.Notification, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetNotifications"), time.Second*5)
+	defer cancel()
+
+	query := `
+	SELECT id, user_id, subject, body, created_at, read_at FROM notifications
+	WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+	ORDER BY id DESC LIMIT 100`
+	rows, err := s.conn.Query(ctx, query, userId, unreadOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		notification := models.Notification{}
+		if err := rows.Scan(&notification.Id, &notification.UserId, &notification.Subject, &notification.Body, &notification.CreatedAt, &notification.ReadAt); err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, rows.Err()
+}
+
+func (s *PostgresStorage) MarkNotificationRead(ctx context.Context, userId, notificationId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "MarkNotificationRead"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE notifications SET read_at = COALESCE(read_at, now()) WHERE user_id = $1 AND id = $2`
+	tag, err := s.conn.Exec(ctx, query, userId, notificationId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrNotificationNotFound
+	}
+
+	return nil
+}
@@ -2,56 +2,73 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"sort"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/ursuldaniel/go-market/internal/domain/models"
 )
 
-func (s *PostgresStorage) AddProduct(name, description string, price, quantity int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (s *PostgresStorage) AddProduct(ctx context.Context, sku, name, description string, price, quantity int) (int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddProduct"), time.Second*5)
 	defer cancel()
 
 	tx, err := s.conn.Begin(ctx)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Prepare(ctx, "insert product", "INSERT INTO products (name, description, price, quantity) VALUES ($1, $2, $3, $4)")
+	_, err = tx.Prepare(ctx, "insert product", "INSERT INTO products (sku, name, description, price, quantity) VALUES (NULLIF($1, ''), $2, $3, $4, $5) RETURNING id")
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	_, err = tx.Exec(ctx, "insert product", name, description, price, quantity)
+	var id int
+	err = tx.QueryRow(ctx, "insert product", sku, name, description, price, quantity).Scan(&id)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	return tx.Commit(ctx)
+	return id, tx.Commit(ctx)
 }
 
-func (s *PostgresStorage) GetAllProducts() ([]models.Product, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+const productColumns = `p.id, COALESCE(p.sku, ''), p.name, p.description, p.price, p.quantity, p.version, COALESCE(r.rating, 0), r.count`
+
+// ratingJoin adds the average rating and the number of reviews that are not hidden.
+const ratingJoin = `
+	LEFT JOIN LATERAL (
+		SELECT ROUND(AVG(rating), 2)::FLOAT8 AS rating, COUNT(*) AS count
+		FROM reviews WHERE reviews.product_id = p.id AND reviews.status <> 'hidden'
+	) r ON TRUE`
+
+func (s *PostgresStorage) GetAllProducts(ctx context.Context) ([]models.Product, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetAllProducts"), time.Second*5)
 	defer cancel()
 
-	query := `SELECT * FROM products`
+	query := `SELECT ` + productColumns + ` FROM products p` + ratingJoin + ` WHERE p.deleted_at IS NULL`
 	rows, err := s.conn.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	products := []models.Product{}
 	for rows.Next() {
 		product := models.Product{}
-		if err := rows.Scan(&product.Id, &product.Name, &product.Description, &product.Price, &product.Quantity); err != nil {
+		if err := rows.Scan(&product.Id, &product.Sku, &product.Name, &product.Description, &product.Price, &product.Quantity, &product.Version, &product.Rating, &product.ReviewCount); err != nil {
 			return nil, err
 		}
 
 		products = append(products, product)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	sortById := func(i, j int) bool {
 		return products[i].Id < products[j].Id
 	}
@@ -60,11 +77,11 @@ func (s *PostgresStorage) GetAllProducts() ([]models.Product, error) {
 	return products, nil
 }
 
-func (s *PostgresStorage) GetProductById(productId int) (models.Product, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (s *PostgresStorage) GetProductById(ctx context.Context, productId int) (models.Product, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetProductById"), time.Second*5)
 	defer cancel()
 
-	query := `SELECT * FROM products WHERE id = $1`
+	query := `SELECT ` + productColumns + ` FROM products p` + ratingJoin + ` WHERE p.id = $1 AND p.deleted_at IS NULL`
 	rows, err := s.conn.Query(ctx, query, productId)
 	if err != nil {
 		return models.Product{}, err
@@ -73,51 +90,238 @@ func (s *PostgresStorage) GetProductById(productId int) (models.Product, error)
 
 	product := models.Product{}
 	for rows.Next() {
-		if err := rows.Scan(&product.Id, &product.Name, &product.Description, &product.Price, &product.Quantity); err != nil {
+		if err := rows.Scan(&product.Id, &product.Sku, &product.Name, &product.Description, &product.Price, &product.Quantity, &product.Version, &product.Rating, &product.ReviewCount); err != nil {
 			return models.Product{}, err
 		}
+	}
 
-		if err != nil {
-			return models.Product{}, err
-		}
+	if err := rows.Err(); err != nil {
+		return models.Product{}, err
+	}
+
+	if product.Id == 0 {
+		return models.Product{}, models.ErrProductNotFound
 	}
 
 	return product, nil
 }
 
-func (s *PostgresStorage) UpdateProduct(productId int, name, description string, price, quantity int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// UpdateProduct overwrites the product if it still has the given version and
+// bumps the version. ErrVersionConflict is returned otherwise.
+func (s *PostgresStorage) UpdateProduct(ctx context.Context, productId, version int, sku, name, description string, price, quantity int) (int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UpdateProduct"), time.Second*5)
 	defer cancel()
 
 	tx, err := s.conn.Begin(ctx)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Prepare(ctx, "update", "UPDATE products SET name = $1, description = $2, price = $3, quantity = $4 WHERE id = $5")
+	_, err = tx.Prepare(ctx, "update", "UPDATE products SET sku = NULLIF($1, ''), name = $2, description = $3, price = $4, quantity = $5, version = version + 1 WHERE id = $6 AND version = $7 AND deleted_at IS NULL RETURNING version")
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	_, err = tx.Exec(ctx, "update", name, description, price, quantity, productId)
+	var newVersion int
+	err = tx.QueryRow(ctx, "update", sku, name, description, price, quantity, productId, version).Scan(&newVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, models.ErrVersionConflict
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	return newVersion, tx.Commit(ctx)
+}
+
+// DeleteProduct soft deletes the product if it still has the given version.
+// ErrVersionConflict is returned otherwise. The row is kept so that purchases
+// keep pointing at it until PurgeDeletedProducts removes it.
+func (s *PostgresStorage) DeleteProduct(ctx context.Context, productId, version int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "DeleteProduct"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE products SET deleted_at = now(), version = version + 1 WHERE id = $1 AND version = $2 AND deleted_at IS NULL`
+	tag, err := s.conn.Exec(ctx, query, productId, version)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit(ctx)
+	if tag.RowsAffected() == 0 {
+		return models.ErrVersionConflict
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) GetDeletedProducts(ctx context.Context) ([]models.Product, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetDeletedProducts"), time.Second*5)
+	defer cancel()
+
+	query := `SELECT id, COALESCE(sku, ''), name, description, price, quantity, version, deleted_at FROM products WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+	rows, err := s.conn.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []models.Product{}
+	for rows.Next() {
+		product := models.Product{}
+		if err := rows.Scan(&product.Id, &product.Sku, &product.Name, &product.Description, &product.Price, &product.Quantity, &product.Version, &product.DeletedAt); err != nil {
+			return nil, err
+		}
+
+		products = append(products, product)
+	}
+
+	return products, rows.Err()
 }
 
-func (s *PostgresStorage) DeleteProduct(productId int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (s *PostgresStorage) RestoreProduct(ctx context.Context, productId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "RestoreProduct"), time.Second*5)
 	defer cancel()
 
-	query := `DELETE FROM products WHERE id = $1`
-	_, err := s.conn.Exec(ctx, query, productId)
+	query := `UPDATE products SET deleted_at = NULL, version = version + 1 WHERE id = $1 AND deleted_at IS NOT NULL`
+	tag, err := s.conn.Exec(ctx, query, productId)
 	if err != nil {
 		return err
 	}
 
+	if tag.RowsAffected() == 0 {
+		return models.ErrProductNotFound
+	}
+
 	return nil
 }
+
+// PurgeDeletedProducts removes products soft deleted before the given time.
+// Products that were ever purchased are kept for the purchase history.
+// Their images go with them, the keys of the images are returned so that the
+// caller can delete the blobs.
+func (s *PostgresStorage) PurgeDeletedProducts(ctx context.Context, before time.Time) (int, []string, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "PurgeDeletedProducts"), time.Second*30)
+	defer cancel()
+
+	// Every part of the statement sees the images as they were before the
+	// cascade removed them.
+	query := `
+	WITH purged AS (
+		DELETE FROM products
+		WHERE deleted_at < $1 AND NOT EXISTS (SELECT 1 FROM purchases WHERE purchases.product_id = products.id)
+		RETURNING id
+	)
+	SELECT
+		(SELECT count(*) FROM purged),
+		COALESCE((SELECT array_agg(key ORDER BY id) FROM product_images WHERE product_id IN (SELECT id FROM purged)), '{}')`
+	var purged int
+	var imageKeys []string
+	if err := s.conn.QueryRow(ctx, query, before).Scan(&purged, &imageKeys); err != nil {
+		return 0, nil, err
+	}
+
+	return purged, imageKeys, nil
+}
+
+// UpsertProducts inserts products or updates the ones with the same SKU.
+// A soft deleted product with the same SKU is restored.
+// In atomic mode the first failure rolls back every row, otherwise each row
+// is applied independently. With dryRun nothing is committed.
+func (s *PostgresStorage) UpsertProducts(ctx context.Context, products []models.Product, atomic, dryRun bool) ([]models.ImportResult, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UpsertProducts"), time.Second*60)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// The old row is read in the same statement, before the update.
+	query := `
+	WITH old AS (SELECT quantity, deleted_at FROM products WHERE sku = $1)
+	INSERT INTO products (sku, name, description, price, quantity) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (sku) DO UPDATE SET name = $2, description = $3, price = $4, quantity = $5, version = products.version + 1, deleted_at = NULL
+	RETURNING id, xmax = 0, COALESCE((SELECT quantity FROM old WHERE deleted_at IS NULL), 0)`
+
+	results := make([]models.ImportResult, len(products))
+	failed := false
+	for i, product := range products {
+		results[i].Sku = product.Sku
+
+		row, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var inserted bool
+		results[i].Quantity = product.Quantity
+		err = row.QueryRow(ctx, query, product.Sku, product.Name, product.Description, product.Price, product.Quantity).Scan(&results[i].Id, &inserted, &results[i].PreviousQuantity)
+		if err != nil {
+			row.Rollback(ctx)
+			results[i].Action = models.ImportFailed
+			results[i].Error = err.Error()
+			failed = true
+
+			if atomic {
+				break
+			}
+
+			continue
+		}
+
+		if err := row.Commit(ctx); err != nil {
+			return nil, err
+		}
+
+		results[i].Action = models.ImportUpdated
+		if inserted {
+			results[i].Action = models.ImportCreated
+		}
+	}
+
+	if atomic && failed {
+		for i := range results {
+			if results[i].Action != models.ImportFailed {
+				results[i].Id = 0
+				results[i].Action = models.ImportRolledBack
+			}
+		}
+
+		return results, nil
+	}
+
+	if dryRun {
+		return results, nil
+	}
+
+	return results, tx.Commit(ctx)
+}
+
+// StreamProducts calls fn for every product ordered by id without loading the whole catalog.
+func (s *PostgresStorage) StreamProducts(ctx context.Context, fn func(product models.Product) error) error {
+	ctx = withMethod(ctx, "StreamProducts")
+
+	query := `SELECT id, COALESCE(sku, ''), name, description, price, quantity, version FROM products WHERE deleted_at IS NULL ORDER BY id`
+	rows, err := s.conn.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		product := models.Product{}
+		if err := rows.Scan(&product.Id, &product.Sku, &product.Name, &product.Description, &product.Price, &product.Quantity, &product.Version); err != nil {
+			return err
+		}
+
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -2,56 +2,67 @@ package storage
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"sort"
 	"time"
 
+	pgx "github.com/jackc/pgx/v5"
 	"github.com/ursuldaniel/go-market/internal/domain/models"
 )
 
-func (s *PostgresStorage) MakePurchase(userID, productID, quantity int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// MakePurchase takes quantity items of the product out of stock and returns
+// the new purchase.
+func (s *PostgresStorage) MakePurchase(ctx context.Context, userID, productID, quantity int) (models.Purchase, error) {
+	if quantity <= 0 {
+		return models.Purchase{}, models.ErrInvalidQuantity
+	}
+
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "MakePurchase"), time.Second*5)
 	defer cancel()
 
 	var productQuantity int
-	query := `SELECT quantity FROM products WHERE id = $1`
+	query := `SELECT quantity FROM products WHERE id = $1 AND deleted_at IS NULL`
 	err := s.conn.QueryRow(ctx, query, productID).Scan(&productQuantity)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Purchase{}, models.ErrProductNotFound
+	}
 	if err != nil {
-		return err
+		return models.Purchase{}, err
 	}
 
 	if productQuantity-quantity < 0 {
-		return fmt.Errorf("not enough products")
+		return models.Purchase{}, models.ErrNotEnoughProducts
 	}
 
-	query = `UPDATE products SET quantity = $1 WHERE id = $2`
+	query = `UPDATE products SET quantity = $1, version = version + 1 WHERE id = $2`
 	_, err = s.conn.Exec(ctx, query, productQuantity-quantity, productID)
 	if err != nil {
-		return err
+		return models.Purchase{}, err
 	}
 
 	tx, err := s.conn.Begin(ctx)
 	if err != nil {
-		return err
+		return models.Purchase{}, err
 	}
 
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Prepare(ctx, "insert purchase", "INSERT INTO purchases (user_id, product_id, quantity, timestamp) VALUES ($1, $2, $3, $4)")
+	_, err = tx.Prepare(ctx, "insert purchase", "INSERT INTO purchases (user_id, product_id, quantity, timestamp) VALUES ($1, $2, $3, $4) RETURNING id")
 	if err != nil {
-		return err
+		return models.Purchase{}, err
 	}
 
-	_, err = tx.Exec(ctx, "insert purchase", userID, productID, quantity, (time.Now().String())[:19])
+	purchase := models.Purchase{UserId: userID, ProductId: productID, Quantity: quantity, Timestamp: (time.Now().String())[:19]}
+	err = tx.QueryRow(ctx, "insert purchase", userID, productID, quantity, purchase.Timestamp).Scan(&purchase.Id)
 	if err != nil {
-		return err
+		return models.Purchase{}, err
 	}
 
-	return tx.Commit(ctx)
+	return purchase, tx.Commit(ctx)
 }
 
-func (s *PostgresStorage) GetUserPurchases(userID int) ([]models.Purchase, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (s *PostgresStorage) GetUserPurchases(ctx context.Context, userID int) ([]models.Purchase, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetUserPurchases"), time.Second*5)
 	defer cancel()
 
 	query := `SELECT * FROM purchases WHERE user_id = $1`
@@ -59,6 +70,7 @@ func (s *PostgresStorage) GetUserPurchases(userID int) ([]models.Purchase, error
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	purchases := []models.Purchase{}
 	for rows.Next() {
@@ -70,6 +82,10 @@ func (s *PostgresStorage) GetUserPurchases(userID int) ([]models.Purchase, error
 		purchases = append(purchases, purchase)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	sortById := func(i, j int) bool {
 		return purchases[i].Id < purchases[j].Id
 	}
@@ -78,8 +94,8 @@ func (s *PostgresStorage) GetUserPurchases(userID int) ([]models.Purchase, error
 	return purchases, nil
 }
 
-func (s *PostgresStorage) GetProductPurchases(productID int) ([]models.Purchase, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (s *PostgresStorage) GetProductPurchases(ctx context.Context, productID int) ([]models.Purchase, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetProductPurchases"), time.Second*5)
 	defer cancel()
 
 	query := `SELECT * FROM purchases WHERE product_id = $1`
@@ -87,6 +103,7 @@ func (s *PostgresStorage) GetProductPurchases(productID int) ([]models.Purchase,
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	purchases := []models.Purchase{}
 	for rows.Next() {
@@ -98,6 +115,10 @@ func (s *PostgresStorage) GetProductPurchases(productID int) ([]models.Purchase,
 		purchases = append(purchases, purchase)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	sortById := func(i, j int) bool {
 		return purchases[i].Id < purchases[j].Id
 	}
@@ -105,3 +126,30 @@ func (s *PostgresStorage) GetProductPurchases(productID int) ([]models.Purchase,
 
 	return purchases, nil
 }
+
+func (s *PostgresStorage) GetPurchaseSummary(ctx context.Context, userId int) (models.PurchaseSummary, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetPurchaseSummary"), time.Second*5)
+	defer cancel()
+
+	summary := models.PurchaseSummary{UserId: userId}
+	query := `
+	SELECT
+		count(*),
+		COALESCE(sum(pur.quantity), 0),
+		count(DISTINCT pur.product_id),
+		COALESCE(sum(pur.quantity * p.price), 0),
+		COALESCE(min(pur.timestamp), ''),
+		COALESCE(max(pur.timestamp), '')
+	FROM purchases pur LEFT JOIN products p ON p.id = pur.product_id
+	WHERE pur.user_id = $1`
+	err := s.conn.QueryRow(ctx, query, userId).Scan(
+		&summary.Purchases,
+		&summary.Items,
+		&summary.Products,
+		&summary.Spent,
+		&summary.FirstPurchaseAt,
+		&summary.LastPurchaseAt,
+	)
+
+	return summary, err
+}
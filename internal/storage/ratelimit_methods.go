@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// TakeRateLimitToken refills the bucket of key for the time since its last
+// request and takes a token from it if there is one. It returns the tokens
+// left and whether a token was taken. The rate and the burst are kept with
+// the bucket for DeleteFullRateLimits.
+func (s *PostgresStorage) TakeRateLimitToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "TakeRateLimitToken"), time.Second*5)
+	defer cancel()
+
+	var tokens float64
+	var allowed bool
+	query := `
+	INSERT INTO rate_limits (key, tokens, allowed, updated_at, rate, burst) VALUES ($1, $3::FLOAT8 - 1, TRUE, now(), $2, $3)
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = LEAST($3::FLOAT8, rate_limits.tokens + EXTRACT(EPOCH FROM now() - rate_limits.updated_at)::FLOAT8 * $2::FLOAT8)
+			- CASE WHEN LEAST($3::FLOAT8, rate_limits.tokens + EXTRACT(EPOCH FROM now() - rate_limits.updated_at)::FLOAT8 * $2::FLOAT8) >= 1 THEN 1 ELSE 0 END,
+		allowed = LEAST($3::FLOAT8, rate_limits.tokens + EXTRACT(EPOCH FROM now() - rate_limits.updated_at)::FLOAT8 * $2::FLOAT8) >= 1,
+		updated_at = now(),
+		rate = $2,
+		burst = $3
+	RETURNING tokens, allowed`
+	err := s.conn.QueryRow(ctx, query, key, rate, burst).Scan(&tokens, &allowed)
+
+	return tokens, allowed, err
+}
+
+// DeleteFullRateLimits drops the buckets that have refilled since their last
+// request, they are the same as missing ones. Buckets stored before the rate
+// was kept are dropped too, at worst they start over full.
+func (s *PostgresStorage) DeleteFullRateLimits(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "DeleteFullRateLimits"), time.Second*5)
+	defer cancel()
+
+	query := `
+	DELETE FROM rate_limits
+	WHERE rate IS NULL OR tokens + EXTRACT(EPOCH FROM now() - updated_at)::FLOAT8 * rate >= burst`
+	tag, err := s.conn.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+const reviewColumns = `id, product_id, user_id, rating, text, status, created_at, updated_at`
+
+func scanReview(row pgx.Row, review *models.Review) error {
+	return row.Scan(&review.Id, &review.ProductId, &review.UserId, &review.Rating, &review.Text, &review.Status, &review.CreatedAt, &review.UpdatedAt)
+}
+
+// AddReview stores the review. Each user can review a product only once,
+// ErrReviewExists is returned for a second review.
+func (s *PostgresStorage) AddReview(ctx context.Context, review models.Review) (models.Review, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddReview"), time.Second*5)
+	defer cancel()
+
+	query := `INSERT INTO reviews (product_id, user_id, rating, text) VALUES ($1, $2, $3, $4) RETURNING ` + reviewColumns
+	err := scanReview(s.conn.QueryRow(ctx, query, review.ProductId, review.UserId, review.Rating, review.Text), &review)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return models.Review{}, models.ErrReviewExists
+	}
+
+	return review, err
+}
+
+// GetProductReviews returns the reviews of a product that are not hidden, newest first.
+func (s *PostgresStorage) GetProductReviews(ctx context.Context, productId int) ([]models.Review, error) {
+	query := `SELECT ` + reviewColumns + ` FROM reviews WHERE product_id = $1 AND status <> 'hidden' ORDER BY created_at DESC, id DESC`
+	return s.queryReviews(withMethod(ctx, "GetProductReviews"), query, productId)
+}
+
+func (s *PostgresStorage) GetUserReviews(ctx context.Context, userId int) ([]models.Review, error) {
+	query := `SELECT ` + reviewColumns + ` FROM reviews WHERE user_id = $1 ORDER BY created_at DESC, id DESC`
+	return s.queryReviews(withMethod(ctx, "GetUserReviews"), query, userId)
+}
+
+// GetReviews returns every review with the given status, or all of them if status is empty.
+func (s *PostgresStorage) GetReviews(ctx context.Context, status string) ([]models.Review, error) {
+	query := `SELECT ` + reviewColumns + ` FROM reviews WHERE $1 = '' OR status = $1 ORDER BY created_at DESC, id DESC`
+	return s.queryReviews(withMethod(ctx, "GetReviews"), query, status)
+}
+
+func (s *PostgresStorage) queryReviews(ctx context.Context, query string, args ...any) ([]models.Review, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []models.Review{}
+	for rows.Next() {
+		review := models.Review{}
+		if err := scanReview(rows, &review); err != nil {
+			return nil, err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	return reviews, rows.Err()
+}
+
+// UpdateReview changes the rating and text of a review written by userId.
+func (s *PostgresStorage) UpdateReview(ctx context.Context, productId, reviewId, userId, rating int, text string) (models.Review, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UpdateReview"), time.Second*5)
+	defer cancel()
+
+	review := models.Review{}
+	query := `
+	UPDATE reviews SET rating = $4, text = $5, updated_at = now()
+	WHERE product_id = $1 AND id = $2 AND user_id = $3
+	RETURNING ` + reviewColumns
+	err := scanReview(s.conn.QueryRow(ctx, query, productId, reviewId, userId, rating, text), &review)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Review{}, models.ErrReviewNotFound
+	}
+
+	return review, err
+}
+
+// DeleteReview deletes a review written by userId.
+func (s *PostgresStorage) DeleteReview(ctx context.Context, productId, reviewId, userId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "DeleteReview"), time.Second*5)
+	defer cancel()
+
+	query := `DELETE FROM reviews WHERE product_id = $1 AND id = $2 AND user_id = $3`
+	tag, err := s.conn.Exec(ctx, query, productId, reviewId, userId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrReviewNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) SetReviewStatus(ctx context.Context, reviewId int, status string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "SetReviewStatus"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE reviews SET status = $2 WHERE id = $1`
+	tag, err := s.conn.Exec(ctx, query, reviewId, status)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrReviewNotFound
+	}
+
+	return nil
+}
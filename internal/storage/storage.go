@@ -3,22 +3,31 @@ package storage
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
-	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type PostgresStorage struct {
-	conn *pgx.Conn
+	conn *pgxpool.Pool
 }
 
+// schemaTables lists the tables created by CreatePostgresDB.
+var schemaTables = []string{"users", "user_tokens", "user_identities", "recovery_codes", "login_attempts", "rate_limits", "api_keys", "products", "product_images", "reviews", "wishlist_items", "stock_subscriptions", "notifications", "purchases", "webhooks", "webhook_deliveries", "audit_log"}
+
 func NewPostgresStorage(ctx context.Context, connStr string) (*PostgresStorage, error) {
-	conn, err := pgx.Connect(ctx, connStr)
+	config, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	config.ConnConfig.Tracer = queryTracer{}
+
+	conn, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
 		return nil, err
 	}
-	// defer conn.Close(context.Background())
 
 	if err := conn.Ping(ctx); err != nil {
 		return nil, err
@@ -33,7 +42,43 @@ func NewPostgresStorage(ctx context.Context, connStr string) (*PostgresStorage,
 	}, nil
 }
 
-func CreatePostgresDB(ctx context.Context, conn *pgx.Conn) error {
+// Ready reports whether the database is reachable and the schema has been created.
+func (s *PostgresStorage) Ready(ctx context.Context) error {
+	ctx = withMethod(ctx, "Ready")
+
+	if err := s.conn.Ping(ctx); err != nil {
+		return err
+	}
+
+	var count int
+	query := `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ANY($1)`
+	if err := s.conn.QueryRow(ctx, query, schemaTables).Scan(&count); err != nil {
+		return err
+	}
+
+	if count != len(schemaTables) {
+		return fmt.Errorf("migrations have not been applied")
+	}
+
+	return nil
+}
+
+// Migrate creates or updates the database schema. It is safe to run repeatedly.
+func (s *PostgresStorage) Migrate(ctx context.Context) error {
+	return CreatePostgresDB(ctx, s.conn)
+}
+
+func (s *PostgresStorage) Close() {
+	s.conn.Close()
+}
+
+func (s *PostgresStorage) PoolStats() *pgxpool.Stat {
+	return s.conn.Stat()
+}
+
+func CreatePostgresDB(ctx context.Context, conn *pgxpool.Pool) error {
+	ctx = withMethod(ctx, "CreatePostgresDB")
+
 	query := `
 	CREATE TABLE IF NOT EXISTS users (
 		id SERIAL PRIMARY KEY,
@@ -42,6 +87,79 @@ func CreatePostgresDB(ctx context.Context, conn *pgx.Conn) error {
 		email TEXT
 	);
 	
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT DEFAULT 'user';
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN DEFAULT FALSE;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT DEFAULT 0;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ DEFAULT now();
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMPTZ;
+
+	UPDATE users SET email_verified = FALSE
+	WHERE email_verified AND id NOT IN (SELECT min(id) FROM users WHERE email_verified GROUP BY lower(email));
+	CREATE UNIQUE INDEX IF NOT EXISTS users_verified_email_key ON users (lower(email)) WHERE email_verified;
+
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER REFERENCES users (id),
+		code_hash TEXT,
+		used_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		key TEXT PRIMARY KEY,
+		failures INTEGER DEFAULT 0,
+		last_failure_at TIMESTAMPTZ,
+		locked_until TIMESTAMPTZ
+	);
+
+	CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,
+		tokens DOUBLE PRECISION,
+		allowed BOOLEAN,
+		updated_at TIMESTAMPTZ
+	);
+
+	ALTER TABLE rate_limits ADD COLUMN IF NOT EXISTS rate DOUBLE PRECISION;
+	ALTER TABLE rate_limits ADD COLUMN IF NOT EXISTS burst INTEGER;
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		name TEXT,
+		prefix TEXT UNIQUE,
+		key_hash TEXT,
+		scopes TEXT[],
+		created_by INTEGER REFERENCES users (id),
+		created_at TIMESTAMPTZ DEFAULT now(),
+		expires_at TIMESTAMPTZ,
+		last_used_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	);
+
+	CREATE TABLE IF NOT EXISTS user_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER REFERENCES users (id),
+		purpose TEXT,
+		token_hash TEXT UNIQUE,
+		expires_at TIMESTAMPTZ,
+		used_at TIMESTAMPTZ,
+		created_at TIMESTAMPTZ DEFAULT now()
+	);
+
+	CREATE TABLE IF NOT EXISTS user_identities (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER REFERENCES users (id),
+		issuer TEXT,
+		subject TEXT,
+		email TEXT DEFAULT '',
+		email_verified BOOLEAN DEFAULT FALSE,
+		created_at TIMESTAMPTZ DEFAULT now(),
+		last_login_at TIMESTAMPTZ,
+		UNIQUE (issuer, subject)
+	);
+
 	CREATE TABLE IF NOT EXISTS products (
 		id SERIAL PRIMARY KEY,
 		name TEXT,
@@ -49,7 +167,65 @@ func CreatePostgresDB(ctx context.Context, conn *pgx.Conn) error {
 		price INTEGER,
 		quantity INTEGER
 	);
+
+	ALTER TABLE products ADD COLUMN IF NOT EXISTS sku TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS products_sku_key ON products (sku);
+	ALTER TABLE products ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
 	
+	CREATE TABLE IF NOT EXISTS product_images (
+		id SERIAL PRIMARY KEY,
+		product_id INTEGER REFERENCES products (id) ON DELETE CASCADE,
+		key TEXT,
+		content_type TEXT,
+		thumbnail_type TEXT,
+		width INTEGER,
+		height INTEGER,
+		size BIGINT,
+		position INTEGER,
+		is_primary BOOLEAN DEFAULT FALSE,
+		created_at TIMESTAMPTZ DEFAULT now()
+	);
+
+	CREATE INDEX IF NOT EXISTS product_images_product_id_idx ON product_images (product_id, position);
+
+	CREATE TABLE IF NOT EXISTS reviews (
+		id SERIAL PRIMARY KEY,
+		product_id INTEGER REFERENCES products (id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users (id),
+		rating INTEGER CHECK (rating BETWEEN 1 AND 5),
+		text TEXT DEFAULT '',
+		status TEXT DEFAULT 'visible',
+		created_at TIMESTAMPTZ DEFAULT now(),
+		updated_at TIMESTAMPTZ DEFAULT now(),
+		UNIQUE (product_id, user_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS wishlist_items (
+		user_id INTEGER REFERENCES users (id),
+		product_id INTEGER REFERENCES products (id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ DEFAULT now(),
+		PRIMARY KEY (user_id, product_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS stock_subscriptions (
+		user_id INTEGER REFERENCES users (id),
+		product_id INTEGER REFERENCES products (id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ DEFAULT now(),
+		PRIMARY KEY (user_id, product_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS notifications (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER REFERENCES users (id),
+		subject TEXT,
+		body TEXT,
+		created_at TIMESTAMPTZ DEFAULT now(),
+		read_at TIMESTAMPTZ
+	);
+
+	CREATE INDEX IF NOT EXISTS notifications_user_id_idx ON notifications (user_id, id);
+
 	CREATE TABLE IF NOT EXISTS purchases (
 		id SERIAL PRIMARY KEY,
 		user_id INTEGER,
@@ -57,6 +233,98 @@ func CreatePostgresDB(ctx context.Context, conn *pgx.Conn) error {
 		quantity INTEGER,
 		timestamp TEXT
 	);
+
+	-- Purchases from the hard delete era may point at users and products that
+	-- are gone. They get soft deleted placeholders, shaped like the rows
+	-- DeleteUser and DeleteProduct leave behind, so that the history stays
+	-- and the foreign keys can be validated. The keys are added as NOT VALID
+	-- first so that the placeholders and the check run in this migration.
+	DO $$
+	DECLARE
+		placeholders INTEGER;
+	BEGIN
+		INSERT INTO users (id, username, password, email, disabled, deleted_at)
+		SELECT DISTINCT user_id, 'deleted-' || user_id, '', '', TRUE, now()
+		FROM purchases
+		WHERE user_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = purchases.user_id);
+
+		GET DIAGNOSTICS placeholders = ROW_COUNT;
+		IF placeholders > 0 THEN
+			RAISE NOTICE 'added % deleted users for orphaned purchases', placeholders;
+			PERFORM setval(pg_get_serial_sequence('users', 'id'), max(id)) FROM users
+			HAVING max(id) > COALESCE(pg_sequence_last_value(pg_get_serial_sequence('users', 'id')::regclass), 0);
+		END IF;
+
+		INSERT INTO products (id, name, description, price, quantity, deleted_at)
+		SELECT DISTINCT product_id, 'Deleted product ' || product_id, '', 0, 0, now()
+		FROM purchases
+		WHERE product_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM products WHERE products.id = purchases.product_id);
+
+		GET DIAGNOSTICS placeholders = ROW_COUNT;
+		IF placeholders > 0 THEN
+			RAISE NOTICE 'added % deleted products for orphaned purchases', placeholders;
+			PERFORM setval(pg_get_serial_sequence('products', 'id'), max(id)) FROM products
+			HAVING max(id) > COALESCE(pg_sequence_last_value(pg_get_serial_sequence('products', 'id')::regclass), 0);
+		END IF;
+
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'purchases_user_id_fkey') THEN
+			ALTER TABLE purchases ADD CONSTRAINT purchases_user_id_fkey
+				FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT NOT VALID;
+		END IF;
+
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'purchases_product_id_fkey') THEN
+			ALTER TABLE purchases ADD CONSTRAINT purchases_product_id_fkey
+				FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE RESTRICT NOT VALID;
+		END IF;
+	END;
+	$$;
+
+	ALTER TABLE purchases VALIDATE CONSTRAINT purchases_user_id_fkey;
+	ALTER TABLE purchases VALIDATE CONSTRAINT purchases_product_id_fkey;
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id SERIAL PRIMARY KEY,
+		url TEXT,
+		secret TEXT,
+		events TEXT[],
+		active BOOLEAN DEFAULT TRUE
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id SERIAL PRIMARY KEY,
+		webhook_id INTEGER,
+		event TEXT,
+		payload TEXT,
+		status TEXT,
+		attempts INTEGER DEFAULT 0,
+		response_code INTEGER DEFAULT 0,
+		last_error TEXT DEFAULT '',
+		next_attempt_at TIMESTAMPTZ DEFAULT now(),
+		created_at TIMESTAMPTZ DEFAULT now()
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id SERIAL PRIMARY KEY,
+		actor_id INTEGER,
+		action TEXT,
+		target TEXT,
+		diff TEXT,
+		ip TEXT,
+		request_id TEXT,
+		created_at TIMESTAMPTZ,
+		prev_hash TEXT,
+		hash TEXT
+	);
+
+	CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+
+	CREATE OR REPLACE TRIGGER audit_log_append_only
+		BEFORE UPDATE OR DELETE ON audit_log
+		FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
 	`
 
 	_, err := conn.Exec(ctx, query)
@@ -66,7 +334,7 @@ func CreatePostgresDB(ctx context.Context, conn *pgx.Conn) error {
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	return string(hashedPassword), nil
@@ -80,8 +348,15 @@ func VerifyPassword(savedHash, inputPassword string) error {
 	return nil
 }
 
-func IsDataUnique(conn *pgx.Conn, login string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// dummyPasswordHash is compared against when a user doesn't exist. It is
+// computed on first use with the same cost as real hashes.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("go-market"), bcrypt.DefaultCost)
+	return string(hash)
+})
+
+func IsDataUnique(ctx context.Context, conn *pgxpool.Pool, login string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "IsDataUnique"), time.Second*5)
 	defer cancel()
 
 	var count int
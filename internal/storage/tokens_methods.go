@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// AddUserToken stores the hash of a single-use token. Earlier unused tokens
+// of the user with the same purpose stop working.
+func (s *PostgresStorage) AddUserToken(ctx context.Context, userId int, purpose, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddUserToken"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := `DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	if _, err := tx.Exec(ctx, query, userId, purpose); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(ctx, query, userId, purpose, tokenHash, expiresAt); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// consumeUserToken marks the token as used and returns its user.
+func consumeUserToken(ctx context.Context, tx pgx.Tx, purpose, tokenHash string) (int, error) {
+	var userId int
+	query := `
+	UPDATE user_tokens SET used_at = now()
+	WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+	RETURNING user_id`
+	err := tx.QueryRow(ctx, query, tokenHash, purpose).Scan(&userId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, models.ErrInvalidToken
+	}
+
+	return userId, err
+}
+
+// VerifyEmail marks the email of the token's user as verified. A verified
+// email belongs to a single user, ErrEmailTaken is returned if another user
+// has already verified it.
+func (s *PostgresStorage) VerifyEmail(ctx context.Context, tokenHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "VerifyEmail"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return -1, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	userId, err := consumeUserToken(ctx, tx, models.TokenVerifyEmail, tokenHash)
+	if err != nil {
+		return -1, err
+	}
+
+	query := `UPDATE users SET email_verified = TRUE WHERE id = $1`
+	_, err = tx.Exec(ctx, query, userId)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return -1, models.ErrEmailTaken
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	return userId, tx.Commit(ctx)
+}
+
+// ResetPassword sets a new password with a reset token and logs the user out
+// everywhere. Other outstanding reset tokens of the user are revoked.
+func (s *PostgresStorage) ResetPassword(ctx context.Context, tokenHash, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "ResetPassword"), time.Second*5)
+	defer cancel()
+
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return -1, err
+	}
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return -1, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	userId, err := consumeUserToken(ctx, tx, models.TokenResetPassword, tokenHash)
+	if err != nil {
+		return -1, err
+	}
+
+	query := `UPDATE users SET password = $1, ` + revokeTokens + ` WHERE id = $2`
+	if _, err := tx.Exec(ctx, query, hashedPassword, userId); err != nil {
+		return -1, err
+	}
+
+	query = `DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	if _, err := tx.Exec(ctx, query, userId, models.TokenResetPassword); err != nil {
+		return -1, err
+	}
+
+	return userId, tx.Commit(ctx)
+}
+
+// GetUserByEmail finds the user who has verified the email. Unverified
+// emails are ignored: anyone can claim them, and several users may have.
+func (s *PostgresStorage) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetUserByEmail"), time.Second*5)
+	defer cancel()
+
+	user := models.User{}
+	query := `SELECT id, username, email, role, disabled FROM users WHERE lower(email) = lower($1) AND email_verified AND deleted_at IS NULL`
+	err := s.conn.QueryRow(ctx, query, email).Scan(&user.Id, &user.Username, &user.Email, &user.Role, &user.Disabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.User{}, models.ErrUserNotFound
+	}
+
+	return user, err
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/ursuldaniel/go-market/internal/metrics"
+	"github.com/ursuldaniel/go-market/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type queryStartKey struct{}
+
+type methodKey struct{}
+
+type queryStart struct {
+	method string
+	sql    string
+	start  time.Time
+}
+
+// queryTracer logs, measures and traces every query issued through the pool.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	method := storageMethod(ctx)
+	ctx, _ = tracing.Tracer().Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", data.SQL),
+		),
+	)
+
+	return context.WithValue(ctx, queryStartKey{}, queryStart{method: method, sql: data.SQL, start: time.Now()})
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	query, _ := ctx.Value(queryStartKey{}).(queryStart)
+	duration := time.Since(query.start)
+	metrics.ObserveQuery(query.method, duration, data.Err)
+
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", query.method),
+		slog.String("sql", query.sql),
+		slog.Float64("latency_ms", float64(duration.Microseconds())/1000),
+	}
+
+	if data.Err != nil {
+		slog.LogAttrs(ctx, slog.LevelError, "query failed", append(attrs, slog.String("error", data.Err.Error()))...)
+		return
+	}
+
+	slog.LogAttrs(ctx, slog.LevelDebug, "query", append(attrs, slog.Int64("rows", data.CommandTag.RowsAffected()))...)
+}
+
+// withMethod names the storage method that issues the queries made with ctx,
+// for the spans, metrics and logs of the queries.
+func withMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, methodKey{}, method)
+}
+
+// storageMethod returns the name of the PostgresStorage method that issued the query.
+func storageMethod(ctx context.Context) string {
+	if method, ok := ctx.Value(methodKey{}).(string); ok {
+		return method
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ursuldaniel/go-market/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestQuerySpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown := tracing.SetupWithExporter(exporter)
+	defer shutdown(context.Background())
+
+	ctx, request := tracing.Tracer().Start(context.Background(), "GET /products/:id")
+
+	tracer := queryTracer{}
+	for _, method := range []string{"GetUserAuth", "GetProductById"} {
+		queryCtx := tracer.TraceQueryStart(withMethod(ctx, method), nil, pgx.TraceQueryStartData{SQL: "SELECT 1"})
+		tracer.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 1")})
+	}
+
+	// A query without a method label is still traced.
+	queryCtx := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT 2"})
+	tracer.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{})
+	request.End()
+
+	if err := otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	for _, name := range []string{"storage.GetUserAuth", "storage.GetProductById", "storage.unknown"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+
+		if span.Parent.SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the request span", name)
+		}
+
+		if !hasAttribute(span.Attributes, attribute.String("db.system", "postgresql")) {
+			t.Errorf("%s has attributes %v", name, span.Attributes)
+		}
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == want {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// SetTOTPSecret stores a secret that is pending until EnableTOTP is called.
+// It fails while two-factor authentication is enabled.
+func (s *PostgresStorage) SetTOTPSecret(ctx context.Context, userId int, secret string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "SetTOTPSecret"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE users SET totp_secret = $2, totp_last_step = 0 WHERE id = $1 AND NOT COALESCE(totp_enabled, FALSE)`
+	tag, err := s.conn.Exec(ctx, query, userId, secret)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrTOTPEnabled
+	}
+
+	return nil
+}
+
+// GetTOTP returns the secret of the user, whether it is enabled and the last
+// time step a code was accepted for.
+func (s *PostgresStorage) GetTOTP(ctx context.Context, userId int) (string, bool, int64, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetTOTP"), time.Second*5)
+	defer cancel()
+
+	var secret string
+	var enabled bool
+	var lastStep int64
+	query := `SELECT COALESCE(totp_secret, ''), COALESCE(totp_enabled, FALSE), COALESCE(totp_last_step, 0) FROM users WHERE id = $1`
+	err := s.conn.QueryRow(ctx, query, userId).Scan(&secret, &enabled, &lastStep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, 0, models.ErrUserNotFound
+	}
+
+	return secret, enabled, lastStep, err
+}
+
+// UseTOTPStep records that a code of the given step was accepted. A step can
+// be used only once, so a concurrent replay of the same code fails.
+func (s *PostgresStorage) UseTOTPStep(ctx context.Context, userId int, step int64) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UseTOTPStep"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE users SET totp_last_step = $2 WHERE id = $1 AND COALESCE(totp_last_step, 0) < $2`
+	tag, err := s.conn.Exec(ctx, query, userId, step)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrInvalidTOTP
+	}
+
+	return nil
+}
+
+// EnableTOTP turns on the pending secret and replaces the recovery codes.
+func (s *PostgresStorage) EnableTOTP(ctx context.Context, userId int, step int64, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "EnableTOTP"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := `
+	UPDATE users SET totp_enabled = TRUE, totp_last_step = $2
+	WHERE id = $1 AND totp_secret IS NOT NULL AND NOT COALESCE(totp_enabled, FALSE)`
+	tag, err := tx.Exec(ctx, query, userId, step)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrTOTPEnabled
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) DisableTOTP(ctx context.Context, userId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "DisableTOTP"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, userId); err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "ReplaceRecoveryCodes"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userId int, codeHashes []string) error {
+	query := `DELETE FROM recovery_codes WHERE user_id = $1`
+	if _, err := tx.Exec(ctx, query, userId); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, unnest($2::TEXT[])`
+	_, err := tx.Exec(ctx, query, userId, codeHashes)
+	return err
+}
+
+// UseRecoveryCode burns an unused recovery code of the user.
+func (s *PostgresStorage) UseRecoveryCode(ctx context.Context, userId int, codeHash string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UseRecoveryCode"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := s.conn.Exec(ctx, query, userId, codeHash)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrInvalidTOTP
+	}
+
+	return nil
+}
@@ -2,17 +2,21 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	pgx "github.com/jackc/pgx/v5"
 	"github.com/ursuldaniel/go-market/internal/domain/models"
 )
 
-func (s *PostgresStorage) RegisterUser(username, password, email string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (s *PostgresStorage) RegisterUser(ctx context.Context, username, password, email string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "RegisterUser"), time.Second*5)
 	defer cancel()
 
-	err := IsDataUnique(s.conn, username)
+	err := IsDataUnique(ctx, s.conn, username)
 	if err != nil {
 		return fmt.Errorf("non unique data")
 	}
@@ -42,11 +46,11 @@ func (s *PostgresStorage) RegisterUser(username, password, email string) error {
 	return tx.Commit(ctx)
 }
 
-func (s *PostgresStorage) LoginUser(username, password string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (s *PostgresStorage) LoginUser(ctx context.Context, username, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "LoginUser"), time.Second*5)
 	defer cancel()
 
-	query := `SELECT id, password FROM users WHERE username = $1`
+	query := `SELECT id, password, disabled FROM users WHERE username = $1`
 	rows, err := s.conn.Query(ctx, query, username)
 	if err != nil {
 		return -1, err
@@ -55,10 +59,12 @@ func (s *PostgresStorage) LoginUser(username, password string) (int, error) {
 
 	var id int
 	var savedHash string
+	var disabled bool
 	for rows.Next() {
 		err := rows.Scan(
 			&id,
 			&savedHash,
+			&disabled,
 		)
 
 		if err != nil {
@@ -66,19 +72,51 @@ func (s *PostgresStorage) LoginUser(username, password string) (int, error) {
 		}
 	}
 
+	if err := rows.Err(); err != nil {
+		return -1, err
+	}
+
+	// Unknown users and users without a password, such as deleted ones, are
+	// checked against a dummy hash, so that the response time doesn't tell
+	// whether the username exists.
+	known := savedHash != ""
+	if !known {
+		savedHash = dummyPasswordHash()
+	}
+
 	err = VerifyPassword(savedHash, password)
-	if err != nil {
-		return -1, fmt.Errorf("invalid data")
+	if err != nil || !known {
+		return -1, models.ErrInvalidCredentials
+	}
+
+	if disabled {
+		return -1, models.ErrUserDisabled
 	}
 
 	return id, nil
 }
 
-func (s *PostgresStorage) GetUserProfile(userId int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+const userColumns = `id, username, email, role, disabled, COALESCE(email_verified, FALSE), COALESCE(totp_enabled, FALSE), COALESCE(created_at, 'epoch'), deleted_at`
+
+func scanUser(row pgx.Row, user *models.User) error {
+	return row.Scan(
+		&user.Id,
+		&user.Username,
+		&user.Email,
+		&user.Role,
+		&user.Disabled,
+		&user.EmailVerified,
+		&user.TOTPEnabled,
+		&user.CreatedAt,
+		&user.DeletedAt,
+	)
+}
+
+func (s *PostgresStorage) GetUserProfile(ctx context.Context, userId int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetUserProfile"), time.Second*5)
 	defer cancel()
 
-	query := `SELECT * FROM users WHERE id = $1`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
 	rows, err := s.conn.Query(ctx, query, userId)
 	if err != nil {
 		return models.User{}, err
@@ -87,17 +125,334 @@ func (s *PostgresStorage) GetUserProfile(userId int) (models.User, error) {
 
 	user := models.User{}
 	for rows.Next() {
-		err := rows.Scan(
-			&user.Id,
-			&user.Username,
-			&user.Password,
-			&user.Email,
-		)
-
-		if err != nil {
+		if err := scanUser(rows, &user); err != nil {
 			return models.User{}, err
 		}
 	}
 
+	return user, rows.Err()
+}
+
+func (s *PostgresStorage) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetUserByUsername"), time.Second*5)
+	defer cancel()
+
+	user := models.User{}
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	err := scanUser(s.conn.QueryRow(ctx, query, username), &user)
+	if err != nil {
+		return models.User{}, models.ErrUserNotFound
+	}
+
 	return user, nil
 }
+
+// GetUsers returns a page of the users that match filter, newest first, and
+// the number of all matching users.
+func (s *PostgresStorage) GetUsers(ctx context.Context, filter models.UserFilter) ([]models.User, int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetUsers"), time.Second*5)
+	defer cancel()
+
+	conditions := []string{}
+	args := []any{}
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Query != "" {
+		addCondition("(username ILIKE '%%' || $%[1]d || '%%' OR email ILIKE '%%' || $%[1]d || '%%')", filter.Query)
+	}
+	if filter.Role != "" {
+		addCondition("role = $%d", filter.Role)
+	}
+	switch filter.Status {
+	case models.UserActive:
+		conditions = append(conditions, "NOT COALESCE(disabled, FALSE) AND deleted_at IS NULL")
+	case models.UserDisabled:
+		conditions = append(conditions, "COALESCE(disabled, FALSE) AND deleted_at IS NULL")
+	case models.UserDeleted:
+		conditions = append(conditions, "deleted_at IS NOT NULL")
+	}
+	if !filter.RegisteredFrom.IsZero() {
+		addCondition("created_at >= $%d", filter.RegisteredFrom)
+	}
+	if !filter.RegisteredTo.IsZero() {
+		addCondition("created_at <= $%d", filter.RegisteredTo)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.conn.QueryRow(ctx, `SELECT count(*) FROM users`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := filter.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	query := `SELECT ` + userColumns + ` FROM users` + where + ` ORDER BY id DESC LIMIT ` + strconv.Itoa(limit) + ` OFFSET ` + strconv.Itoa(filter.Offset)
+
+	rows, err := s.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user := models.User{}
+		if err := scanUser(rows, &user); err != nil {
+			return nil, 0, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, total, rows.Err()
+}
+
+// GetUserAuth returns what the auth middleware checks on every request:
+// whether the user is disabled and since when tokens are valid.
+func (s *PostgresStorage) GetUserAuth(ctx context.Context, userId int) (bool, time.Time, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetUserAuth"), time.Second*5)
+	defer cancel()
+
+	var disabled bool
+	var validAfter *time.Time
+	query := `SELECT COALESCE(disabled, FALSE) OR deleted_at IS NOT NULL, tokens_valid_after FROM users WHERE id = $1`
+	err := s.conn.QueryRow(ctx, query, userId).Scan(&disabled, &validAfter)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, time.Time{}, models.ErrUserNotFound
+	}
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	if validAfter == nil {
+		return disabled, time.Time{}, nil
+	}
+
+	return disabled, *validAfter, nil
+}
+
+// revokeTokens logs a user out everywhere when set in an UPDATE of users.
+// iat has a precision of seconds, so the time is truncated to make tokens
+// issued earlier in the same second stop working too.
+const revokeTokens = `tokens_valid_after = date_trunc('second', now())`
+
+// RevokeUserTokens logs the user out everywhere: tokens issued before now
+// stop working.
+func (s *PostgresStorage) RevokeUserTokens(ctx context.Context, userId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "RevokeUserTokens"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE users SET ` + revokeTokens + ` WHERE id = $1`
+	tag, err := s.conn.Exec(ctx, query, userId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// ForcePasswordReset removes the password of the user and logs them out, so
+// that the account can only be used again after a password reset.
+func (s *PostgresStorage) ForcePasswordReset(ctx context.Context, userId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "ForcePasswordReset"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE users SET password = '', ` + revokeTokens + ` WHERE id = $1 AND deleted_at IS NULL`
+	tag, err := s.conn.Exec(ctx, query, userId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) SetUserRole(ctx context.Context, userId int, role string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "SetUserRole"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE users SET role = $1 WHERE id = $2`
+	tag, err := s.conn.Exec(ctx, query, role, userId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) SetUserDisabled(ctx context.Context, userId int, disabled bool) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "SetUserDisabled"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE users SET disabled = $1 WHERE id = $2`
+	tag, err := s.conn.Exec(ctx, query, disabled, userId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// UpdateUserProfile changes the username and the email of a user. A new email
+// has to be verified again.
+func (s *PostgresStorage) UpdateUserProfile(ctx context.Context, userId int, username, email string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UpdateUserProfile"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var taken bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE username = $1 AND id <> $2)`
+	if err := tx.QueryRow(ctx, query, username, userId).Scan(&taken); err != nil {
+		return err
+	}
+
+	if taken {
+		return models.ErrUsernameTaken
+	}
+
+	var oldEmail string
+	query = `SELECT COALESCE(email, '') FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	err = tx.QueryRow(ctx, query, userId).Scan(&oldEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	emailChanged := oldEmail != email
+
+	query = `UPDATE users SET username = $2, email = $3, email_verified = email_verified AND NOT $4 WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, userId, username, email, emailChanged); err != nil {
+		return err
+	}
+
+	if emailChanged {
+		query = `DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+		if _, err := tx.Exec(ctx, query, userId, models.TokenVerifyEmail); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ChangePassword sets a new password if currentPassword matches and logs the
+// user out everywhere. Outstanding reset tokens of the user are revoked.
+func (s *PostgresStorage) ChangePassword(ctx context.Context, userId int, currentPassword, newPassword string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "ChangePassword"), time.Second*5)
+	defer cancel()
+
+	var savedHash string
+	query := `SELECT COALESCE(password, '') FROM users WHERE id = $1 AND deleted_at IS NULL`
+	err := s.conn.QueryRow(ctx, query, userId).Scan(&savedHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if savedHash == "" || VerifyPassword(savedHash, currentPassword) != nil {
+		return models.ErrInvalidCredentials
+	}
+
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	query = `UPDATE users SET password = $2, ` + revokeTokens + ` WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, userId, hashedPassword); err != nil {
+		return err
+	}
+
+	query = `DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	if _, err := tx.Exec(ctx, query, userId, models.TokenResetPassword); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteUser anonymizes a user: personal data, credentials and everything
+// that only matters to the user are removed. Purchases are kept for
+// accounting and reviews stay without the name of their author.
+func (s *PostgresStorage) DeleteUser(ctx context.Context, userId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "DeleteUser"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := `
+	UPDATE users SET
+		username = 'deleted-' || id,
+		password = '',
+		email = '',
+		email_verified = FALSE,
+		totp_secret = NULL,
+		totp_enabled = FALSE,
+		disabled = TRUE,
+		deleted_at = now(),
+		` + revokeTokens + `
+	WHERE id = $1 AND deleted_at IS NULL`
+	tag, err := tx.Exec(ctx, query, userId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrUserNotFound
+	}
+
+	for _, table := range []string{"user_tokens", "user_identities", "recovery_codes", "wishlist_items", "stock_subscriptions", "notifications"} {
+		query := `DELETE FROM ` + table + ` WHERE user_id = $1`
+		if _, err := tx.Exec(ctx, query, userId); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func (s *PostgresStorage) AddWebhook(ctx context.Context, url, secret string, events []string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddWebhook"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Prepare(ctx, "insert webhook", "INSERT INTO webhooks (url, secret, events) VALUES ($1, $2, $3)")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "insert webhook", url, secret, events)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) GetAllWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetAllWebhooks"), time.Second*5)
+	defer cancel()
+
+	query := `SELECT id, url, secret, events, active FROM webhooks ORDER BY id`
+	rows, err := s.conn.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		webhook := models.Webhook{}
+		if err := rows.Scan(&webhook.Id, &webhook.Url, &webhook.Secret, &webhook.Events, &webhook.Active); err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (s *PostgresStorage) GetWebhookById(ctx context.Context, webhookId int) (models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetWebhookById"), time.Second*5)
+	defer cancel()
+
+	webhook := models.Webhook{}
+	query := `SELECT id, url, secret, events, active FROM webhooks WHERE id = $1`
+	err := s.conn.QueryRow(ctx, query, webhookId).Scan(&webhook.Id, &webhook.Url, &webhook.Secret, &webhook.Events, &webhook.Active)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("webhook not found")
+	}
+
+	return webhook, nil
+}
+
+func (s *PostgresStorage) UpdateWebhook(ctx context.Context, webhookId int, url, secret string, events []string, active bool) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UpdateWebhook"), time.Second*5)
+	defer cancel()
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Prepare(ctx, "update webhook", "UPDATE webhooks SET url = $1, secret = $2, events = $3, active = $4 WHERE id = $5")
+	if err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, "update webhook", url, secret, events, active, webhookId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStorage) DeleteWebhook(ctx context.Context, webhookId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "DeleteWebhook"), time.Second*5)
+	defer cancel()
+
+	query := `DELETE FROM webhooks WHERE id = $1`
+	_, err := s.conn.Exec(ctx, query, webhookId)
+	if err != nil {
+		return err
+	}
+
+	query = `DELETE FROM webhook_deliveries WHERE webhook_id = $1`
+	_, err = s.conn.Exec(ctx, query, webhookId)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EnqueueWebhookEvent creates a pending delivery for every active webhook subscribed to the event.
+func (s *PostgresStorage) EnqueueWebhookEvent(ctx context.Context, event, payload string) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "EnqueueWebhookEvent"), time.Second*5)
+	defer cancel()
+
+	query := `
+	INSERT INTO webhook_deliveries (webhook_id, event, payload, status)
+	SELECT id, $1, $2, $3 FROM webhooks WHERE active AND $1 = ANY(events)`
+	_, err := s.conn.Exec(ctx, query, event, payload, models.DeliveryPending)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) GetDueWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetDueWebhookDeliveries"), time.Second*5)
+	defer cancel()
+
+	query := `
+	SELECT id, webhook_id, event, payload, status, attempts, response_code, last_error, next_attempt_at, created_at
+	FROM webhook_deliveries WHERE status = $1 AND next_attempt_at <= now()
+	ORDER BY next_attempt_at LIMIT $2`
+	rows, err := s.conn.Query(ctx, query, models.DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+func (s *PostgresStorage) GetWebhookDeliveries(ctx context.Context, webhookId int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetWebhookDeliveries"), time.Second*5)
+	defer cancel()
+
+	query := `
+	SELECT id, webhook_id, event, payload, status, attempts, response_code, last_error, next_attempt_at, created_at
+	FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id`
+	rows, err := s.conn.Query(ctx, query, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWebhookDeliveries(rows)
+}
+
+func (s *PostgresStorage) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UpdateWebhookDelivery"), time.Second*5)
+	defer cancel()
+
+	query := `
+	UPDATE webhook_deliveries SET status = $1, attempts = $2, response_code = $3, last_error = $4, next_attempt_at = $5
+	WHERE id = $6`
+	_, err := s.conn.Exec(ctx, query, delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.LastError, delivery.NextAttemptAt, delivery.Id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RedeliverWebhookDelivery puts a delivery back into the queue regardless of its current status,
+// with a fresh budget of attempts.
+func (s *PostgresStorage) RedeliverWebhookDelivery(ctx context.Context, deliveryId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "RedeliverWebhookDelivery"), time.Second*5)
+	defer cancel()
+
+	query := `UPDATE webhook_deliveries SET status = $1, attempts = 0, next_attempt_at = now() WHERE id = $2`
+	tag, err := s.conn.Exec(ctx, query, models.DeliveryPending, deliveryId)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("delivery not found")
+	}
+
+	return nil
+}
+
+func scanWebhookDeliveries(rows pgx.Rows) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		delivery := models.WebhookDelivery{}
+		err := rows.Scan(
+			&delivery.Id,
+			&delivery.WebhookId,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.ResponseCode,
+			&delivery.LastError,
+			&delivery.NextAttemptAt,
+			&delivery.CreatedAt,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+func (s *PostgresStorage) AddWishlistItem(ctx context.Context, userId, productId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "AddWishlistItem"), time.Second*5)
+	defer cancel()
+
+	query := `INSERT INTO wishlist_items (user_id, product_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := s.conn.Exec(ctx, query, userId, productId)
+	return err
+}
+
+func (s *PostgresStorage) RemoveWishlistItem(ctx context.Context, userId, productId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "RemoveWishlistItem"), time.Second*5)
+	defer cancel()
+
+	query := `DELETE FROM wishlist_items WHERE user_id = $1 AND product_id = $2`
+	_, err := s.conn.Exec(ctx, query, userId, productId)
+	return err
+}
+
+// GetWishlist returns the wished products of the user that are still in the
+// catalog, most recently added first.
+func (s *PostgresStorage) GetWishlist(ctx context.Context, userId int) ([]models.Product, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetWishlist"), time.Second*5)
+	defer cancel()
+
+	query := `
+	SELECT ` + productColumns + `
+	FROM wishlist_items w JOIN products p ON p.id = w.product_id` + ratingJoin + `
+	WHERE w.user_id = $1 AND p.deleted_at IS NULL
+	ORDER BY w.created_at DESC`
+	rows, err := s.conn.Query(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []models.Product{}
+	for rows.Next() {
+		product := models.Product{}
+		if err := rows.Scan(&product.Id, &product.Sku, &product.Name, &product.Description, &product.Price, &product.Quantity, &product.Version, &product.Rating, &product.ReviewCount); err != nil {
+			return nil, err
+		}
+
+		products = append(products, product)
+	}
+
+	return products, rows.Err()
+}
+
+func (s *PostgresStorage) SubscribeStock(ctx context.Context, userId, productId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "SubscribeStock"), time.Second*5)
+	defer cancel()
+
+	query := `INSERT INTO stock_subscriptions (user_id, product_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	_, err := s.conn.Exec(ctx, query, userId, productId)
+	return err
+}
+
+func (s *PostgresStorage) UnsubscribeStock(ctx context.Context, userId, productId int) error {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "UnsubscribeStock"), time.Second*5)
+	defer cancel()
+
+	query := `DELETE FROM stock_subscriptions WHERE user_id = $1 AND product_id = $2`
+	_, err := s.conn.Exec(ctx, query, userId, productId)
+	return err
+}
+
+// GetStockSubscriptions returns the ids of the products the user waits for.
+func (s *PostgresStorage) GetStockSubscriptions(ctx context.Context, userId int) ([]int, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "GetStockSubscriptions"), time.Second*5)
+	defer cancel()
+
+	query := `SELECT product_id FROM stock_subscriptions WHERE user_id = $1 ORDER BY product_id`
+	rows, err := s.conn.Query(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	productIds := []int{}
+	for rows.Next() {
+		var productId int
+		if err := rows.Scan(&productId); err != nil {
+			return nil, err
+		}
+
+		productIds = append(productIds, productId)
+	}
+
+	return productIds, rows.Err()
+}
+
+// TakeStockSubscribers removes and returns the users waiting for the product,
+// so that every subscription is notified once.
+func (s *PostgresStorage) TakeStockSubscribers(ctx context.Context, productId int) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(withMethod(ctx, "TakeStockSubscribers"), time.Second*5)
+	defer cancel()
+
+	query := `
+	DELETE FROM stock_subscriptions sub USING users u
+	WHERE sub.product_id = $1 AND u.id = sub.user_id
+	RETURNING u.id, u.username, COALESCE(u.email, '')`
+	rows, err := s.conn.Query(ctx, query, productId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user := models.User{}
+		if err := rows.Scan(&user.Id, &user.Username, &user.Email); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
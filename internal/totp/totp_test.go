@@ -0,0 +1,126 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 vectors of RFC 6238 Appendix B. The RFC
+// lists 8 digits, a 6 digit code is their last 6 digits.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != test.want {
+			t.Errorf("code at %d = %s, want %s", test.unix, code, test.want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+
+	for _, test := range tests {
+		code, err := Code(rfcSecret, current+test.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := Validate(rfcSecret, code, now, 0)
+		if ok != test.ok {
+			t.Errorf("code of step %+d: ok = %v, want %v", test.offset, ok, test.ok)
+		}
+
+		if ok && step != current+test.offset {
+			t.Errorf("code of step %+d: matched step %d", test.offset, step-current)
+		}
+	}
+}
+
+func TestValidateRejectsUsedSteps(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := Validate(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("fresh code rejected")
+	}
+
+	// The same code, in the same step and in the next one.
+	if _, ok := Validate(rfcSecret, code, now, step); ok {
+		t.Error("code accepted twice in the same step")
+	}
+
+	if _, ok := Validate(rfcSecret, code, now.Add(time.Second*Period), step); ok {
+		t.Error("code accepted twice in the next step")
+	}
+
+	// Codes of older steps in the window are used up too.
+	previous, err := Code(rfcSecret, step-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := Validate(rfcSecret, previous, now, step); ok {
+		t.Error("code of an earlier step accepted after a later one")
+	}
+
+	next, err := Code(rfcSecret, step+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := Validate(rfcSecret, next, now.Add(time.Second*Period), step); !ok {
+		t.Error("code of the next step rejected")
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	for _, code := range []string{"287 082", " 287082 "} {
+		if _, ok := Validate(rfcSecret, code, now, 0); !ok {
+			t.Errorf("%q rejected", code)
+		}
+	}
+
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := Validate(rfcSecret, code, now, 0); ok {
+			t.Errorf("%q accepted", code)
+		}
+	}
+}
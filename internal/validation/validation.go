@@ -0,0 +1,74 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+// New returns a validator that reports fields by their json names.
+func New() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "" || name == "-" {
+			return field.Name
+		}
+
+		return name
+	})
+
+	return validate
+}
+
+// FieldErrors converts validator errors into per-field messages.
+// It returns nil if err is not a validation error.
+func FieldErrors(err error) []models.FieldError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return nil
+	}
+
+	fieldErrors := []models.FieldError{}
+	for _, e := range validationErrors {
+		fieldErrors = append(fieldErrors, models.FieldError{Field: e.Field(), Error: message(e)})
+	}
+
+	return fieldErrors
+}
+
+func message(e validator.FieldError) string {
+	switch e.Tag() {
+	case "required":
+		return "is required"
+	case "gt":
+		return "must be greater than " + e.Param()
+	case "gte", "min":
+		return "must be at least " + e.Param() + unit(e.Kind())
+	case "max", "lte":
+		return "must be at most " + e.Param() + unit(e.Kind())
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid URL"
+	case "oneof":
+		return "must be one of " + e.Param()
+	default:
+		return fmt.Sprintf("failed the %q rule", e.Tag())
+	}
+}
+
+func unit(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return " characters long"
+	case reflect.Slice, reflect.Map:
+		return " items"
+	default:
+		return ""
+	}
+}
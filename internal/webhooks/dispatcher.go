@@ -0,0 +1,168 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+const (
+	SignatureHeader = "X-GoMarket-Signature"
+	TimestampHeader = "X-GoMarket-Timestamp"
+	EventHeader     = "X-GoMarket-Event"
+	DeliveryHeader  = "X-GoMarket-Delivery"
+)
+
+type Storage interface {
+	GetWebhookById(ctx context.Context, webhookId int) (models.Webhook, error)
+	GetDueWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+}
+
+type Dispatcher struct {
+	store       Storage
+	client      *http.Client
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+}
+
+func NewDispatcher(store Storage) *Dispatcher {
+	return &Dispatcher{
+		store:       store,
+		client:      &http.Client{Timeout: time.Second * 10},
+		interval:    time.Second * 5,
+		batchSize:   50,
+		maxAttempts: 8,
+		baseBackoff: time.Second * 10,
+		maxBackoff:  time.Hour,
+	}
+}
+
+// Run polls the delivery queue until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatchDue(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatchDue(ctx context.Context) {
+	deliveries, err := d.store.GetDueWebhookDeliveries(ctx, d.batchSize)
+	if err != nil {
+		slog.ErrorContext(ctx, "webhooks: failed to load deliveries", "error", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		d.deliver(ctx, delivery)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) {
+	delivery.Attempts++
+
+	webhook, err := d.store.GetWebhookById(ctx, delivery.WebhookId)
+	if err != nil {
+		delivery.Status = models.DeliveryDead
+		delivery.LastError = err.Error()
+		d.save(ctx, delivery)
+		return
+	}
+
+	code, err := d.send(ctx, webhook, delivery)
+	delivery.ResponseCode = code
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.LastError = ""
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = models.DeliveryDead
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts))
+	}
+
+	slog.InfoContext(ctx, "webhooks: delivery attempted", "delivery_id", delivery.Id, "status", delivery.Status, "attempts", delivery.Attempts, "response_code", delivery.ResponseCode)
+	d.save(ctx, delivery)
+}
+
+func (d *Dispatcher) send(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.Id))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) save(ctx context.Context, delivery models.WebhookDelivery) {
+	if err := d.store.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		slog.ErrorContext(ctx, "webhooks: failed to update delivery", "delivery_id", delivery.Id, "error", err)
+	}
+}
+
+// backoff doubles the delay after every failed attempt, up to maxBackoff.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.maxBackoff {
+			return d.maxBackoff
+		}
+	}
+
+	return delay
+}
+
+// Sign computes the hex encoded HMAC-SHA256 of "timestamp.payload" with the webhook secret.
+// Receivers should recompute it and compare with the X-GoMarket-Signature header.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
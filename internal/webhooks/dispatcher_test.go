@@ -0,0 +1,200 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ursuldaniel/go-market/internal/domain/models"
+)
+
+type memoryStorage struct {
+	mu         sync.Mutex
+	webhooks   map[int]models.Webhook
+	deliveries map[int]models.WebhookDelivery
+}
+
+func (m *memoryStorage) GetWebhookById(ctx context.Context, webhookId int) (models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook, ok := m.webhooks[webhookId]
+	if !ok {
+		return models.Webhook{}, errors.New("webhook not found")
+	}
+
+	return webhook, nil
+}
+
+func (m *memoryStorage) GetDueWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	due := []models.WebhookDelivery{}
+	for _, delivery := range m.deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(time.Now()) && len(due) < limit {
+			due = append(due, delivery)
+		}
+	}
+
+	return due, nil
+}
+
+func (m *memoryStorage) UpdateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliveries[delivery.Id] = delivery
+	return nil
+}
+
+func (m *memoryStorage) delivery(id int) models.WebhookDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deliveries[id]
+}
+
+// makeDue lets the next dispatchDue pick up a delivery waiting for its backoff.
+func (m *memoryStorage) makeDue(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery := m.deliveries[id]
+	delivery.NextAttemptAt = time.Now()
+	m.deliveries[id] = delivery
+}
+
+func newTestDispatcher(url string) (*Dispatcher, *memoryStorage) {
+	store := &memoryStorage{
+		webhooks: map[int]models.Webhook{
+			1: {Id: 1, Url: url, Secret: "whsec", Events: []string{models.EventPurchaseCreated}, Active: true},
+		},
+		deliveries: map[int]models.WebhookDelivery{
+			7: {Id: 7, WebhookId: 1, Event: models.EventPurchaseCreated, Payload: `{"event":"purchase.created"}`, Status: models.DeliveryPending},
+		},
+	}
+
+	d := NewDispatcher(store)
+	d.maxAttempts = 3
+
+	return d, store
+}
+
+func TestDeliverySignature(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 1)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header.Clone(), body: body}
+	}))
+	defer receiver.Close()
+
+	d, store := newTestDispatcher(receiver.URL)
+	d.dispatchDue(context.Background())
+
+	req := <-requests
+	timestamp := req.header.Get(TimestampHeader)
+	if want := "sha256=" + Sign("whsec", timestamp, req.body); req.header.Get(SignatureHeader) != want {
+		t.Errorf("signature = %q, want %q", req.header.Get(SignatureHeader), want)
+	}
+
+	if got := req.header.Get(EventHeader); got != models.EventPurchaseCreated {
+		t.Errorf("event header = %q", got)
+	}
+
+	if got := req.header.Get(DeliveryHeader); got != "7" {
+		t.Errorf("delivery header = %q", got)
+	}
+
+	if string(req.body) != `{"event":"purchase.created"}` {
+		t.Errorf("body = %s", req.body)
+	}
+
+	delivery := store.delivery(7)
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusOK {
+		t.Errorf("delivery = %+v, want delivered after one attempt", delivery)
+	}
+}
+
+func TestDeliveryRetriesThenDies(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	d, store := newTestDispatcher(receiver.URL)
+	ctx := context.Background()
+
+	for attempt := 1; attempt < d.maxAttempts; attempt++ {
+		before := time.Now()
+		d.dispatchDue(ctx)
+
+		delivery := store.delivery(7)
+		if delivery.Status != models.DeliveryPending || delivery.Attempts != attempt {
+			t.Fatalf("attempt %d: delivery = %+v, want pending", attempt, delivery)
+		}
+
+		if delivery.ResponseCode != http.StatusServiceUnavailable || delivery.LastError == "" {
+			t.Errorf("attempt %d: response code %d, last error %q", attempt, delivery.ResponseCode, delivery.LastError)
+		}
+
+		wait := delivery.NextAttemptAt.Sub(before)
+		if want := d.backoff(attempt); wait < want || wait > want+time.Second {
+			t.Errorf("attempt %d: retried after %v, want %v", attempt, wait, want)
+		}
+
+		// Not due yet: nothing is sent until the backoff has passed.
+		d.dispatchDue(ctx)
+		if got := store.delivery(7).Attempts; got != attempt {
+			t.Fatalf("attempt %d: delivery was retried before its backoff", attempt)
+		}
+
+		store.makeDue(7)
+	}
+
+	d.dispatchDue(ctx)
+	delivery := store.delivery(7)
+	if delivery.Status != models.DeliveryDead || delivery.Attempts != d.maxAttempts {
+		t.Fatalf("delivery = %+v, want dead after %d attempts", delivery, d.maxAttempts)
+	}
+
+	d.dispatchDue(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != d.maxAttempts {
+		t.Errorf("receiver was called %d times, want %d", calls, d.maxAttempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(nil)
+
+	want := []time.Duration{time.Second * 10, time.Second * 20, time.Second * 40, time.Second * 80}
+	for i, delay := range want {
+		if got := d.backoff(i + 1); got != delay {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, delay)
+		}
+	}
+
+	if got := d.backoff(30); got != d.maxBackoff {
+		t.Errorf("backoff(30) = %v, want the maximum %v", got, d.maxBackoff)
+	}
+}